@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"time"
+)
+
+type Event struct {
+	Type     EventType `json:"type" description:"Type of the event." example:"job.succeeded"`
+	Time     time.Time `json:"time" description:"Time when the event occurred."`
+	Job      *Job      `json:"job,omitempty" description:"Job the event refers to."`
+	Plotter  string    `json:"plotter,omitempty" description:"Network address of the plotter the event refers to." example:"hp-7550:1337"`
+	Progress *Progress `json:"progress,omitempty" description:"Progress of the job."`
+}
+
+type Progress struct {
+	Sent  int `json:"sent" description:"Number of bytes sent to the plotter." example:"4096"`
+	Total int `json:"total" description:"Total number of bytes to send to the plotter." example:"16384"`
+}
+
+type EventType string
+
+const (
+	EventJobSubmitted   EventType = "job.submitted"
+	EventJobStarted     EventType = "job.started"
+	EventJobProgress    EventType = "job.progress"
+	EventJobSucceeded   EventType = "job.succeeded"
+	EventJobFailed      EventType = "job.failed"
+	EventJobCanceled    EventType = "job.canceled"
+	EventJobRestored    EventType = "job.restored"
+	EventPlotterOnline  EventType = "plotter.online"
+	EventPlotterOffline EventType = "plotter.offline"
+	EventQueuePaused    EventType = "queue.paused"
+	EventQueueResumed   EventType = "queue.resumed"
+)
+
+func (EventType) Enum() []interface{} {
+	return []interface{}{
+		EventJobSubmitted,
+		EventJobStarted,
+		EventJobProgress,
+		EventJobSucceeded,
+		EventJobFailed,
+		EventJobCanceled,
+		EventJobRestored,
+		EventPlotterOnline,
+		EventPlotterOffline,
+		EventQueuePaused,
+		EventQueueResumed,
+	}
+}
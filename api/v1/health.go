@@ -0,0 +1,7 @@
+package v1
+
+// Readiness is the result of the readiness checks served at /readyz.
+type Readiness struct {
+	Ready  bool              `json:"ready" description:"Whether the service accepts job requests."`
+	Checks map[string]string `json:"checks" description:"Result of every check, ok or the error."`
+}
@@ -5,14 +5,28 @@ import (
 )
 
 type Job struct {
-	ID          string      `json:"id" description:"ID is a unique string that identifies a job." example:"hp7550-5fbbd6p8"`
-	User        string      `json:"user" description:"Name of the user that submitted the plot." example:"st3v"`
-	Plotter     string      `json:"plotter" description:"Network address of the plotter to use." example:"hp-7550:1337"`
-	Settings    JobSettings `json:"settings" description:"Settings to use for the plot."`
-	SVG         string      `json:"svg" description:"SVG file to be plotted." example:"uploads/hp7550-5fbbd6p8.svg"`
-	Status      JobStatus   `json:"status" description:"Current status of the job." example:"Pending"`
-	SubmittedAt time.Time   `json:"submittedAt" description:"Time when the job was submitted."`
-	Error       string      `json:"error,omitempty" description:"Error message if the job failed." example:""`
+	ID            string      `json:"id" description:"ID is a unique string that identifies a job." example:"hp7550-5fbbd6p8"`
+	User          string      `json:"user" description:"Name of the user that submitted the plot." example:"st3v"`
+	Plotter       string      `json:"plotter" description:"Network address of the plotter to use." example:"hp-7550:1337"`
+	Settings      JobSettings `json:"settings" description:"Settings to use for the plot."`
+	SVG           string      `json:"svg" description:"SVG file to be plotted." example:"uploads/hp7550-5fbbd6p8.svg"`
+	Filename      string      `json:"filename,omitempty" description:"Name of the uploaded SVG file." example:"drawing.svg"`
+	SVGHash       string      `json:"svgHash,omitempty" description:"SHA-256 hash of the SVG file." example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b4b0b822cd15d6c15b0f00a08"`
+	HPGL          string      `json:"hpgl,omitempty" description:"SHA-256 hash of the converted HPGL file." example:""`
+	Status        JobStatus   `json:"status" description:"Current status of the job." example:"Pending"`
+	Priority      int         `json:"priority,omitempty" description:"Jobs with a higher priority are processed first by queues supporting priorities." example:"0"`
+	Parent        string      `json:"parent,omitempty" description:"ID of the job this job has been resubmitted from." example:"hp7550-3kd8x1zq"`
+	SubmittedAt   time.Time   `json:"submittedAt" description:"Time when the job was submitted."`
+	StartedAt     *time.Time  `json:"startedAt,omitempty" description:"Time when the job started processing."`
+	FinishedAt    *time.Time  `json:"finishedAt,omitempty" description:"Time when the job succeeded, failed or was canceled."`
+	Error         string      `json:"error,omitempty" description:"Error message if the job failed." example:""`
+	PlotterErrors []string    `json:"plotterErrors,omitempty" description:"Error conditions reported by the plotter." example:"paper not loaded"`
+
+	// Notify is the email address notified about the job and TraceContext
+	// the W3C trace context of the request that submitted it. They are not
+	// exposed by the API but stored by the job queue.
+	Notify       string            `json:"-"`
+	TraceContext map[string]string `json:"-"`
 }
 
 type JobSettings struct {
@@ -0,0 +1,64 @@
+package v1
+
+import (
+	"time"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+type JobQuery struct {
+	Status          JobStatus `query:"status" description:"Only list jobs with the given status."`
+	User            string    `query:"user" description:"Only list jobs of the given user." example:"st3v"`
+	Plotter         string    `query:"plotter" description:"Only list jobs for the given plotter." example:"hp-7550:1337"`
+	SubmittedAfter  time.Time `query:"submittedAfter" description:"Only list jobs submitted at or after the given time."`
+	SubmittedBefore time.Time `query:"submittedBefore" description:"Only list jobs submitted before the given time."`
+	Search          string    `query:"q" description:"Only list jobs whose ID or file name contains the given text, ignoring case." example:"drawing"`
+	Sort            JobSort   `query:"sort" description:"Order of the jobs, prefixed by - for descending order. Jobs with the same value are ordered by submission time." default:"submittedAt"`
+	Limit           int       `query:"limit" description:"Maximum number of jobs to list." default:"100" minimum:"1" maximum:"1000"`
+	Cursor          string    `query:"cursor" description:"Cursor returned as next by the previous request to list the following jobs with the same query."`
+}
+
+type JobList struct {
+	Jobs []Job  `json:"jobs" description:"Jobs matching the query."`
+	Next string `json:"next,omitempty" description:"Cursor to list the following jobs, empty on the last page." example:"aQBzdWJtaXR0ZWRBdAAAGHqg"`
+}
+
+type JobSort string
+
+const (
+	JobSortSubmittedAt     JobSort = "submittedAt"
+	JobSortSubmittedAtDesc JobSort = "-submittedAt"
+	JobSortUser            JobSort = "user"
+	JobSortUserDesc        JobSort = "-user"
+	JobSortPlotter         JobSort = "plotter"
+	JobSortPlotterDesc     JobSort = "-plotter"
+	JobSortStatus          JobSort = "status"
+	JobSortStatusDesc      JobSort = "-status"
+)
+
+func (JobSort) Enum() []interface{} {
+	return []interface{}{
+		JobSortSubmittedAt,
+		JobSortSubmittedAtDesc,
+		JobSortUser,
+		JobSortUserDesc,
+		JobSortPlotter,
+		JobSortPlotterDesc,
+		JobSortStatus,
+		JobSortStatusDesc,
+	}
+}
+
+// Field returns the name of the field to sort by and whether to sort in descending order.
+func (s JobSort) Field() (string, bool) {
+	if s == "" {
+		return string(JobSortSubmittedAt), false
+	}
+	if s[0] == '-' {
+		return string(s[1:]), true
+	}
+	return string(s), false
+}
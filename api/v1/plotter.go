@@ -0,0 +1,24 @@
+package v1
+
+type Plotter struct {
+	Address    string        `json:"address" description:"Network address of the plotter." example:"hp-7550:1337"`
+	Status     PlotterStatus `json:"status" description:"Whether the plotter was reachable on the last connect." example:"Online"`
+	Pending    int           `json:"pending" description:"Number of jobs waiting for the plotter." example:"2"`
+	Processing string        `json:"processing,omitempty" description:"ID of the job currently being plotted." example:"hp7550-5fbbd6p8"`
+}
+
+type PlotterStatus string
+
+const (
+	PlotterStatusUnknown PlotterStatus = "Unknown"
+	PlotterStatusOnline  PlotterStatus = "Online"
+	PlotterStatusOffline PlotterStatus = "Offline"
+)
+
+func (PlotterStatus) Enum() []interface{} {
+	return []interface{}{
+		PlotterStatusUnknown,
+		PlotterStatusOnline,
+		PlotterStatusOffline,
+	}
+}
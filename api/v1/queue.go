@@ -0,0 +1,5 @@
+package v1
+
+type QueueStatus struct {
+	Paused bool `json:"paused" description:"Whether processing of queued jobs is paused. The queue is resumed when the service restarts." example:"false"`
+}
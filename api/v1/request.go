@@ -3,17 +3,20 @@ package v1
 import (
 	"errors"
 	"mime/multipart"
+	"net/mail"
 	"net/url"
 )
 
 type JobRequest struct {
-	User        string                `formData:"user" description:"Name of the user submitting the plot request." required:"true"`
+	User        string                `formData:"user,omitempty" description:"Name of the user submitting the plot request. Required unless authenticated, ignored otherwise."`
 	Plotter     string                `formData:"plotter" description:"Hostname of the plotter to use." required:"true" example:"hp7550"`
 	Device      Device                `formData:"device" description:"Device configuration." required:"true"`
 	Pagesize    Pagesize              `formData:"pagesize" description:"Pagesize of plot." required:"true"`
 	Orientation Orientation           `formData:"orientation,omitempty" description:"Orientation of plot."`
 	Velocity    uint8                 `formData:"velocity,omitempty" description:"Plotting velocity." example:"50"`
+	Priority    int                   `formData:"priority,omitempty" description:"Jobs with a higher priority are processed first by queues supporting priorities. Only admins can set a priority other than the default of 0." example:"0"`
 	SVG         *multipart.FileHeader `formData:"svg" description:"SVG file to be plotted." required:"true"`
+	Notify      string                `formData:"notify,omitempty" description:"Email address notified when the job finished or the plotter needs attention. Authenticated users can only be notified at their own address, which is the default." example:"st3v@example.com"`
 }
 
 func (r *JobRequest) Validate() error {
@@ -30,6 +33,15 @@ func (r *JobRequest) Validate() error {
 		return errors.New("invalid plotter network adress")
 	}
 
+	// the address is stored as it is used as recipient, without a display name
+	if r.Notify != "" {
+		addr, err := mail.ParseAddress(r.Notify)
+		if err != nil {
+			return errors.New("invalid notification address")
+		}
+		r.Notify = addr.Address
+	}
+
 	return nil
 }
 
@@ -42,3 +54,33 @@ func (r *JobRequest) SetDefaults() {
 		r.Orientation = DefaultOrientation
 	}
 }
+
+type ResubmitRequest struct {
+	User        string      `json:"user,omitempty" description:"Name of the user resubmitting the job. Defaults to the user of the previous job unless authenticated, ignored otherwise."`
+	Plotter     string      `json:"plotter,omitempty" description:"Hostname of the plotter to use. Defaults to the plotter of the previous job." example:"hp7550"`
+	Device      Device      `json:"device,omitempty" description:"Device configuration. Defaults to the device of the previous job."`
+	Pagesize    Pagesize    `json:"pagesize,omitempty" description:"Pagesize of plot. Defaults to the pagesize of the previous job."`
+	Orientation Orientation `json:"orientation,omitempty" description:"Orientation of plot. Defaults to the orientation of the previous job."`
+	Velocity    uint8       `json:"velocity,omitempty" description:"Plotting velocity. Defaults to the velocity of the previous job." example:"50"`
+	Priority    int         `json:"priority,omitempty" description:"Jobs with a higher priority are processed first by queues supporting priorities. Only admins can set a priority other than the default of 0." example:"0"`
+	Notify      string      `json:"notify,omitempty" description:"Email address notified when the job finished or the plotter needs attention. Defaults to the address of the previous job if resubmitted by the same user. Authenticated users can only be notified at their own address, which is the default." example:"st3v@example.com"`
+}
+
+func (r *ResubmitRequest) Validate() error {
+	if r.Plotter != "" {
+		if _, err := url.Parse(r.Plotter); err != nil {
+			return errors.New("invalid plotter network address")
+		}
+	}
+
+	// the address is stored as it is used as recipient, without a display name
+	if r.Notify != "" {
+		addr, err := mail.ParseAddress(r.Notify)
+		if err != nil {
+			return errors.New("invalid notification address")
+		}
+		r.Notify = addr.Address
+	}
+
+	return nil
+}
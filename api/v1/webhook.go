@@ -0,0 +1,17 @@
+package v1
+
+import (
+	"time"
+)
+
+type WebhookDelivery struct {
+	ID         string        `json:"id" description:"ID of the delivery, sent as X-Plotq-Delivery header." example:"7k2m9x0q4w8e1r5t"`
+	URL        string        `json:"url" description:"URL of the webhook." example:"https://chat.example.com/hooks/plotq"`
+	Event      EventType     `json:"event" description:"Type of the delivered event." example:"job.succeeded"`
+	JobID      string        `json:"jobId,omitempty" description:"ID of the job the event refers to." example:"hp7550-5fbbd6p8"`
+	Attempt    int           `json:"attempt" description:"Number of the delivery attempt, starting at 1." example:"1"`
+	StatusCode int           `json:"statusCode,omitempty" description:"HTTP status code returned by the webhook." example:"200"`
+	Error      string        `json:"error,omitempty" description:"Error message if the attempt failed." example:""`
+	Time       time.Time     `json:"time" description:"Time of the attempt."`
+	Duration   time.Duration `json:"duration" description:"Duration of the attempt in nanoseconds." example:"120000000"`
+}
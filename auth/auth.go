@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/swaggest/rest"
+	"github.com/swaggest/usecase/status"
+)
+
+// Role is a role granted to an authenticated user.
+type Role string
+
+const (
+	// RoleAdmin may cancel and modify jobs of all users.
+	RoleAdmin Role = "admin"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator if the request does not
+	// carry credentials it understands.
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrInvalidCredentials is returned by an Authenticator if the request's
+	// credentials are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is an authenticated user.
+type Identity struct {
+	User  string
+	Email string
+	Roles []Role
+}
+
+// HasRole returns whether the identity has been granted the given role.
+func (i *Identity) HasRole(role Role) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin returns whether the identity has been granted the admin role.
+func (i *Identity) IsAdmin() bool {
+	return i.HasRole(RoleAdmin)
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// Authenticate returns the identity for the request's credentials.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type contextKey struct{}
+
+// NewContext returns a new context carrying the given identity.
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity carried by the context, if any.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+	return identity, ok
+}
+
+// Middleware returns a middleware that authenticates requests using the first
+// authenticator that understands the request's credentials. Requests that
+// cannot be authenticated are rejected.
+func Middleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, a := range authenticators {
+				identity, err := a.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				} else if err != nil {
+					unauthenticated(w, err)
+					return
+				}
+
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+				return
+			}
+
+			unauthenticated(w, ErrNoCredentials)
+		})
+	}
+}
+
+// unauthenticated writes an error response in the format used by the API.
+func unauthenticated(w http.ResponseWriter, err error) {
+	code, resp := rest.Err(status.Wrap(err, status.Unauthenticated))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Basic realm="plotq", charset="UTF-8"`)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseRoles parses a comma-separated list of roles.
+func parseRoles(s string) []Role {
+	roles := []Role{}
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles = append(roles, Role(r))
+		}
+	}
+	return roles
+}
+
+// parseEmail parses a plain email address, e.g. alice@example.com.
+func parseEmail(s string) (string, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return "", fmt.Errorf("invalid email address %q", s)
+	}
+	return addr.Address, nil
+}
@@ -0,0 +1,136 @@
+package auth_test
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/st3v/plotq/auth"
+)
+
+func writeFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestTokens(t *testing.T) {
+	path := writeFile(t, "# comment\nalice:secret:admin\n\nbob:token::bob@example.com\n")
+
+	tokens, err := auth.LoadTokens(path)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = tokens.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrNoCredentials)
+
+	req.Header.Set("Authorization", "Bearer secret")
+	identity, err := tokens.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, "alice", identity.User)
+	require.True(t, identity.IsAdmin())
+
+	req.Header.Set("Authorization", "Bearer token")
+	identity, err = tokens.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, "bob", identity.User)
+	require.Equal(t, "bob@example.com", identity.Email)
+	require.False(t, identity.IsAdmin())
+
+	req.Header.Set("Authorization", "Bearer invalid")
+	_, err = tokens.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrNoCredentials)
+}
+
+func TestLoadTokensInvalid(t *testing.T) {
+	path := writeFile(t, "alice\n")
+
+	_, err := auth.LoadTokens(path)
+	require.ErrorContains(t, err, ":1: expected user:token[:roles]")
+
+	path = writeFile(t, "alice:secret::alice\n")
+
+	_, err = auth.LoadTokens(path)
+	require.ErrorContains(t, err, `:1: invalid email address "alice"`)
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("wonderland"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	sum := sha1.Sum([]byte("builder"))
+	sha := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+
+	path := writeFile(t, "alice:"+string(hash)+":admin\nbob:"+sha+"\n")
+
+	htpasswd, err := auth.LoadHtpasswd(path)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = htpasswd.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrNoCredentials)
+
+	req.SetBasicAuth("alice", "wonderland")
+	identity, err := htpasswd.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, "alice", identity.User)
+	require.True(t, identity.IsAdmin())
+
+	req.SetBasicAuth("bob", "builder")
+	identity, err = htpasswd.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, "bob", identity.User)
+	require.False(t, identity.IsAdmin())
+
+	req.SetBasicAuth("bob", "wonderland")
+	_, err = htpasswd.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	req.SetBasicAuth("eve", "wonderland")
+	_, err = htpasswd.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestLoadHtpasswdUnsupportedHash(t *testing.T) {
+	path := writeFile(t, "alice:$apr1$abc$def\n")
+
+	_, err := auth.LoadHtpasswd(path)
+	require.ErrorContains(t, err, "unsupported hash for user alice")
+}
+
+func TestLoadHtpasswdInvalidEmail(t *testing.T) {
+	path := writeFile(t, "alice:{SHA}abc::Alice <alice@example.com>\n")
+
+	_, err := auth.LoadHtpasswd(path)
+	require.ErrorContains(t, err, `:1: invalid email address "Alice <alice@example.com>"`)
+}
+
+func TestMiddleware(t *testing.T) {
+	tokens := auth.NewTokens(map[string]auth.Identity{"secret": {User: "alice"}})
+
+	var identity *auth.Identity
+	handler := auth.Middleware(tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = auth.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.JSONEq(t, `{"status":"UNAUTHENTICATED","error":"unauthenticated: no credentials"}`, rec.Body.String())
+	require.Nil(t, identity)
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "alice", identity.User)
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// shaPrefix marks SHA-1 hashed passwords in htpasswd files.
+const shaPrefix = "{SHA}"
+
+type htpasswdEntry struct {
+	hash  string
+	roles []Role
+	email string
+}
+
+type htpasswd struct {
+	users map[string]htpasswdEntry
+}
+
+// htpasswd implements Authenticator
+var _ Authenticator = &htpasswd{}
+
+// LoadHtpasswd returns an authenticator that accepts HTTP basic credentials of
+// the users listed in the given htpasswd file. Passwords must be hashed with
+// bcrypt or SHA-1. Every line may list the user's roles in an additional field,
+// e.g. `alice:$2y$05$...:admin`, optionally followed by the user's email address.
+func LoadHtpasswd(path string) (*htpasswd, error) {
+	users := map[string]htpasswdEntry{}
+
+	err := readLines(path, func(fields []string) error {
+		if len(fields) < 2 || fields[0] == "" {
+			return fmt.Errorf("expected user:hash[:roles]")
+		}
+
+		entry := htpasswdEntry{hash: fields[1]}
+		if !strings.HasPrefix(entry.hash, "$2") && !strings.HasPrefix(entry.hash, shaPrefix) {
+			return fmt.Errorf("unsupported hash for user %s, use bcrypt or SHA-1", fields[0])
+		}
+
+		if len(fields) > 2 {
+			entry.roles = parseRoles(fields[2])
+		}
+
+		if len(fields) > 3 {
+			email, err := parseEmail(fields[3])
+			if err != nil {
+				return err
+			}
+			entry.email = email
+		}
+
+		users[fields[0]] = entry
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &htpasswd{users: users}, nil
+}
+
+// Authenticate implements Authenticator.
+func (h *htpasswd) Authenticate(r *http.Request) (*Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	entry, ok := h.users[user]
+	if !ok || !entry.matches(password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{User: user, Email: entry.email, Roles: entry.roles}, nil
+}
+
+// matches returns whether the password matches the entry's hash.
+func (e htpasswdEntry) matches(password string) bool {
+	if strings.HasPrefix(e.hash, shaPrefix) {
+		sum := sha1.Sum([]byte(password))
+		expected := shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(e.hash)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(e.hash), []byte(password)) == nil
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+)
+
+const (
+	// defaultUserClaim is the claim used as user name if none is configured.
+	// Unlike preferred_username, the subject is unique per issuer and stable.
+	defaultUserClaim = "sub"
+
+	// defaultGroupsClaim is the claim holding the user's groups if none is configured.
+	defaultGroupsClaim = "groups"
+
+	// defaultOIDCTimeout limits requests to the issuer unless a client is configured.
+	defaultOIDCTimeout = 10 * time.Second
+)
+
+// OIDCConfig is the configuration for OpenID Connect bearer token validation.
+type OIDCConfig struct {
+	// Issuer is the issuer URL of the identity provider. Its discovery document
+	// is expected at /.well-known/openid-configuration.
+	Issuer string
+
+	// Audience is the expected audience of tokens, usually the client ID.
+	Audience string
+
+	// UserClaim is the claim used as user name. Defaults to sub, the subject is
+	// also used if the claim is missing. The user name identifies the owner of
+	// jobs, so the claim must be unique and must not change.
+	UserClaim string
+
+	// GroupsClaim is the claim holding the user's groups. Defaults to groups.
+	GroupsClaim string
+
+	// Roles maps groups to plotq roles.
+	Roles map[string]Role
+
+	// Client is the HTTP client used to talk to the issuer. Defaults to a
+	// client with a timeout of 10 seconds.
+	Client *http.Client
+}
+
+// signingAlgs are the algorithms accepted for token signatures.
+var signingAlgs = []string{
+	gooidc.RS256, gooidc.RS384, gooidc.RS512,
+	gooidc.ES256, gooidc.ES384, gooidc.ES512,
+}
+
+type oidc struct {
+	config   OIDCConfig
+	verifier *gooidc.IDTokenVerifier
+}
+
+// oidc implements Authenticator
+var _ Authenticator = &oidc{}
+
+// NewOIDC returns an authenticator that accepts bearer tokens issued by the
+// configured OpenID Connect provider. Tokens are verified with the keys the
+// issuer publishes, which are refetched when a token is signed by an unknown key.
+func NewOIDC(config OIDCConfig) (*oidc, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("no issuer specified")
+	}
+
+	if config.Audience == "" {
+		return nil, errors.New("no audience specified")
+	}
+
+	if config.UserClaim == "" {
+		config.UserClaim = defaultUserClaim
+	}
+
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = defaultGroupsClaim
+	}
+
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: defaultOIDCTimeout}
+	}
+
+	// the client is also used to fetch the issuer's keys later on
+	ctx := gooidc.ClientContext(context.Background(), config.Client)
+
+	provider, err := gooidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover issuer %s: %w", config.Issuer, err)
+	}
+
+	return &oidc{
+		config:   config,
+		verifier: provider.Verifier(&gooidc.Config{ClientID: config.Audience, SupportedSigningAlgs: signingAlgs}),
+	}, nil
+}
+
+// Authenticate implements Authenticator. Bearer tokens that are not JWTs are left
+// to other authenticators.
+func (o *oidc) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	idToken, err := o.verifier.Verify(r.Context(), token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims: %v", ErrInvalidCredentials, err)
+	}
+
+	return o.identity(claims)
+}
+
+// identity returns the identity described by the given claims.
+func (o *oidc) identity(claims map[string]interface{}) (*Identity, error) {
+	user, _ := claims[o.config.UserClaim].(string)
+	if user == "" {
+		user, _ = claims["sub"].(string)
+	}
+
+	if user == "" {
+		return nil, fmt.Errorf("%w: no user in token", ErrInvalidCredentials)
+	}
+
+	identity := &Identity{User: user}
+
+	// only verified addresses are used for notifications
+	if verified, _ := claims["email_verified"].(bool); verified {
+		identity.Email, _ = claims["email"].(string)
+	}
+
+	groups, _ := claims[o.config.GroupsClaim].([]interface{})
+	for _, g := range groups {
+		group, _ := g.(string)
+		if role, ok := o.config.Roles[group]; ok && !identity.HasRole(role) {
+			identity.Roles = append(identity.Roles, role)
+		}
+	}
+
+	return identity, nil
+}
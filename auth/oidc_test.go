@@ -0,0 +1,161 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/testutil"
+)
+
+func newOIDC(t *testing.T) (*testutil.OIDCIssuer, auth.Authenticator) {
+	issuer := testutil.NewOIDCIssuer(t)
+
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:   issuer.URL,
+		Audience: "plotq",
+		Roles:    map[string]auth.Role{"plotq-admins": auth.RoleAdmin},
+	})
+	require.NoError(t, err)
+
+	return issuer, oidc
+}
+
+func claims(issuer *testutil.OIDCIssuer) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                issuer.URL,
+		"aud":                []string{"other", "plotq"},
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"groups":             []string{"hackers", "plotq-admins"},
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func authenticate(a auth.Authenticator, token string) (*auth.Identity, error) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(req)
+}
+
+func TestOIDC(t *testing.T) {
+	issuer, oidc := newOIDC(t)
+	defer issuer.Close()
+
+	identity, err := authenticate(oidc, issuer.Token(claims(issuer)))
+	require.NoError(t, err)
+	require.Equal(t, "1234", identity.User)
+	require.Equal(t, "alice@example.com", identity.Email)
+	require.True(t, identity.IsAdmin())
+
+	c := claims(issuer)
+	c["email_verified"] = false
+	c["groups"] = []string{"hackers"}
+	identity, err = authenticate(oidc, issuer.Token(c))
+	require.NoError(t, err)
+	require.Empty(t, identity.Email)
+	require.False(t, identity.IsAdmin())
+
+	// addresses are only used if they are known to be verified
+	delete(c, "email_verified")
+	identity, err = authenticate(oidc, issuer.Token(c))
+	require.NoError(t, err)
+	require.Empty(t, identity.Email)
+}
+
+func TestOIDCUserClaim(t *testing.T) {
+	issuer := testutil.NewOIDCIssuer(t)
+	defer issuer.Close()
+
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{Issuer: issuer.URL, Audience: "plotq", UserClaim: "preferred_username"})
+	require.NoError(t, err)
+
+	identity, err := authenticate(oidc, issuer.Token(claims(issuer)))
+	require.NoError(t, err)
+	require.Equal(t, "alice", identity.User)
+
+	// the subject is used if the claim is missing
+	c := claims(issuer)
+	delete(c, "preferred_username")
+	identity, err = authenticate(oidc, issuer.Token(c))
+	require.NoError(t, err)
+	require.Equal(t, "1234", identity.User)
+}
+
+func TestOIDCInvalidTokens(t *testing.T) {
+	issuer, oidc := newOIDC(t)
+	defer issuer.Close()
+
+	for name, modify := range map[string]func(map[string]interface{}){
+		"wrong issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"wrong audience": func(c map[string]interface{}) { c["aud"] = "other" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"not yet valid":  func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"no expiry":      func(c map[string]interface{}) { delete(c, "exp") },
+	} {
+		c := claims(issuer)
+		modify(c)
+		_, err := authenticate(oidc, issuer.Token(c))
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials, name)
+	}
+
+	// tampered claims
+	token := issuer.Token(claims(issuer))
+	other := issuer.Token(map[string]interface{}{"iss": issuer.URL})
+	_, err := authenticate(oidc, token[:len(token)-10]+other[len(other)-10:])
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	// tokens that are no JWTs are left to other authenticators
+	_, err = authenticate(oidc, "static-token")
+	require.ErrorIs(t, err, auth.ErrNoCredentials)
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	issuer, oidc := newOIDC(t)
+	defer issuer.Close()
+
+	// keys are refetched for tokens signed by a new key
+	issuer.RotateKey()
+	identity, err := authenticate(oidc, issuer.Token(claims(issuer)))
+	require.NoError(t, err)
+	require.Equal(t, "1234", identity.User)
+
+	// keys the issuer does not publish are rejected
+	other := testutil.NewOIDCIssuer(t)
+	defer other.Close()
+
+	_, err = authenticate(oidc, other.Token(claims(issuer)))
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestOIDCIssuerMismatch(t *testing.T) {
+	issuer := testutil.NewOIDCIssuer(t)
+	defer issuer.Close()
+
+	_, err := auth.NewOIDC(auth.OIDCConfig{Issuer: issuer.URL + "/", Audience: "plotq"})
+	require.ErrorContains(t, err, "issuer did not match")
+}
+
+func TestOIDCTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	start := time.Now()
+	_, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:   server.URL,
+		Audience: "plotq",
+		Client:   &http.Client{Timeout: 50 * time.Millisecond},
+	})
+	require.ErrorContains(t, err, "could not discover issuer")
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type tokens struct {
+	identities map[string]Identity
+}
+
+// tokens implements Authenticator
+var _ Authenticator = &tokens{}
+
+// NewTokens returns an authenticator that accepts the given static API tokens
+// as bearer tokens.
+func NewTokens(identities map[string]Identity) *tokens {
+	return &tokens{identities: identities}
+}
+
+// LoadTokens returns an authenticator that accepts the static API tokens listed
+// in the given file. Every line has the format `user:token[:role,...]`, empty
+// lines and lines starting with # are ignored. The user's email address may
+// follow the roles, e.g. `alice:secret::alice@example.com`.
+func LoadTokens(path string) (*tokens, error) {
+	identities := map[string]Identity{}
+
+	err := readLines(path, func(fields []string) error {
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("expected user:token[:roles]")
+		}
+
+		identity := Identity{User: fields[0]}
+		if len(fields) > 2 {
+			identity.Roles = parseRoles(fields[2])
+		}
+
+		if len(fields) > 3 {
+			email, err := parseEmail(fields[3])
+			if err != nil {
+				return err
+			}
+			identity.Email = email
+		}
+
+		identities[fields[1]] = identity
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTokens(identities), nil
+}
+
+// Authenticate implements Authenticator.
+func (t *tokens) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	for known, identity := range t.identities {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			identity := identity
+			return &identity, nil
+		}
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// bearerToken returns the bearer token of the request, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// readLines calls fn with the colon-separated fields of every line in the given
+// file, skipping empty lines and comments.
+func readLines(path string, fn func(fields []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := fn(strings.Split(line, ":")); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	return nil
+}
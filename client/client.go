@@ -0,0 +1,409 @@
+// Package client is a Go client for the v1 API of plotq. It reuses the types
+// of package api/v1 and covers every operation of the API's OpenAPI spec,
+// which is kept at api/v1/openapi.json. The client's tests fail if the spec
+// served by the handler changes without the file or the client being updated;
+// run "go test ./client -update" to rewrite the file.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+// Paths of the API operations.
+const (
+	pathJobs              = "/v1/jobs"
+	pathJob               = "/v1/jobs/{id}"
+	pathJobSVG            = "/v1/jobs/{id}/svg"
+	pathJobHPGL           = "/v1/jobs/{id}/hpgl"
+	pathJobRestore        = "/v1/jobs/{id}/restore"
+	pathJobResubmit       = "/v1/jobs/{id}/resubmit"
+	pathPlotters          = "/v1/plotters"
+	pathQueue             = "/v1/queue"
+	pathQueuePause        = "/v1/queue/pause"
+	pathQueueResume       = "/v1/queue/resume"
+	pathWebhookDeliveries = "/v1/webhooks/deliveries"
+	pathEvents            = "/v1/events"
+)
+
+// operation is an operation of the API called by the client.
+type operation struct {
+	method string
+	path   string
+}
+
+// operations are all operations of the OpenAPI spec called by the client.
+var operations = []operation{
+	{http.MethodGet, pathJobs},
+	{http.MethodPost, pathJobs},
+	{http.MethodGet, pathJob},
+	{http.MethodDelete, pathJob},
+	{http.MethodPost, pathJobRestore},
+	{http.MethodPost, pathJobResubmit},
+	{http.MethodGet, pathJobSVG},
+	{http.MethodGet, pathJobHPGL},
+	{http.MethodGet, pathPlotters},
+	{http.MethodGet, pathQueue},
+	{http.MethodPost, pathQueuePause},
+	{http.MethodPost, pathQueueResume},
+	{http.MethodGet, pathWebhookDeliveries},
+}
+
+// Client calls the v1 API of a plotq server.
+type Client struct {
+	server   string
+	token    string
+	user     string
+	password string
+	http     *http.Client
+}
+
+// Option is an option for the client.
+type Option func(*Client)
+
+// WithToken authenticates requests with the given API or OpenID Connect token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithBasicAuth authenticates requests with the given user and password.
+func WithBasicAuth(user, password string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// WithHTTPClient sends requests with the given HTTP client instead of
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// New returns a client for the plotq server at the given URL, e.g.
+// http://localhost:8080.
+func New(server string, opts ...Option) *Client {
+	c := &Client{
+		server: strings.TrimSuffix(server, "/"),
+		http:   http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Error is returned for requests the server did not respond to with success.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Status     string `json:"status"`
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded with %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns whether err reports a job that does not exist.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Submit submits a job request to plot the SVG read from svg. The request's
+// SVG field is ignored, filename is the name the file is uploaded with.
+func (c *Client) Submit(ctx context.Context, request v1.JobRequest, svg io.Reader, filename string) (*v1.Job, error) {
+	// the form is streamed to avoid holding large drawings in memory
+	body, w := io.Pipe()
+	form := multipart.NewWriter(w)
+
+	go func() {
+		w.CloseWithError(writeForm(form, request, svg, filename))
+	}()
+
+	job := &v1.Job{}
+	err := c.do(ctx, http.MethodPost, pathJobs, form.FormDataContentType(), body, job)
+	body.Close()
+	return job, err
+}
+
+// writeForm writes the multipart form of the job request.
+func writeForm(form *multipart.Writer, request v1.JobRequest, svg io.Reader, filename string) error {
+	fields := [][2]string{
+		{"user", request.User},
+		{"plotter", request.Plotter},
+		{"device", string(request.Device)},
+		{"pagesize", string(request.Pagesize)},
+		{"orientation", string(request.Orientation)},
+		{"notify", request.Notify},
+	}
+	if request.Velocity > 0 {
+		fields = append(fields, [2]string{"velocity", strconv.Itoa(int(request.Velocity))})
+	}
+	if request.Priority != 0 {
+		fields = append(fields, [2]string{"priority", strconv.Itoa(request.Priority)})
+	}
+
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		if err := form.WriteField(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+
+	part, err := form.CreateFormFile("svg", filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(part, svg); err != nil {
+		return fmt.Errorf("could not read SVG: %w", err)
+	}
+
+	return form.Close()
+}
+
+// Jobs returns a page of the jobs matching the query. Pass the returned
+// list's Next as the query's Cursor to get the following page.
+func (c *Client) Jobs(ctx context.Context, query v1.JobQuery) (*v1.JobList, error) {
+	path := pathJobs
+	if params := jobParams(query).Encode(); params != "" {
+		path += "?" + params
+	}
+
+	list := &v1.JobList{}
+	return list, c.do(ctx, http.MethodGet, path, "", nil, list)
+}
+
+// jobParams returns the query parameters of the query's non-zero fields.
+func jobParams(query v1.JobQuery) url.Values {
+	params := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	set("status", string(query.Status))
+	set("user", query.User)
+	set("plotter", query.Plotter)
+	if !query.SubmittedAfter.IsZero() {
+		set("submittedAfter", query.SubmittedAfter.Format(time.RFC3339Nano))
+	}
+	if !query.SubmittedBefore.IsZero() {
+		set("submittedBefore", query.SubmittedBefore.Format(time.RFC3339Nano))
+	}
+	set("q", query.Search)
+	set("sort", string(query.Sort))
+	if query.Limit > 0 {
+		set("limit", strconv.Itoa(query.Limit))
+	}
+	set("cursor", query.Cursor)
+
+	return params
+}
+
+// Job returns the job with the given ID.
+func (c *Client) Job(ctx context.Context, id string) (*v1.Job, error) {
+	job := &v1.Job{}
+	return job, c.do(ctx, http.MethodGet, jobPath(pathJob, id), "", nil, job)
+}
+
+// Cancel cancels the job with the given ID and returns the canceled job.
+func (c *Client) Cancel(ctx context.Context, id string) (*v1.Job, error) {
+	job := &v1.Job{}
+	return job, c.do(ctx, http.MethodDelete, jobPath(pathJob, id), "", nil, job)
+}
+
+// Restore puts the canceled job with the given ID back into the queue and
+// returns the restored job.
+func (c *Client) Restore(ctx context.Context, id string) (*v1.Job, error) {
+	job := &v1.Job{}
+	return job, c.do(ctx, http.MethodPost, jobPath(pathJobRestore, id), "", nil, job)
+}
+
+// Resubmit submits a new job plotting the SVG of the job with the given ID,
+// with the settings of the previous job overridden by those set in request.
+func (c *Client) Resubmit(ctx context.Context, id string, request v1.ResubmitRequest) (*v1.Job, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &v1.Job{}
+	return job, c.do(ctx, http.MethodPost, jobPath(pathJobResubmit, id), "application/json", bytes.NewReader(body), job)
+}
+
+// SVG writes the SVG file submitted with the job to w.
+func (c *Client) SVG(ctx context.Context, id string, w io.Writer) error {
+	return c.download(ctx, jobPath(pathJobSVG, id), w)
+}
+
+// HPGL writes the HPGL the job has been converted to to w.
+func (c *Client) HPGL(ctx context.Context, id string, w io.Writer) error {
+	return c.download(ctx, jobPath(pathJobHPGL, id), w)
+}
+
+// Plotters returns the plotters with pending or processing jobs and those the
+// server has connected to.
+func (c *Client) Plotters(ctx context.Context) ([]v1.Plotter, error) {
+	plotters := []v1.Plotter{}
+	return plotters, c.do(ctx, http.MethodGet, pathPlotters, "", nil, &plotters)
+}
+
+// Queue returns the status of the queue.
+func (c *Client) Queue(ctx context.Context) (*v1.QueueStatus, error) {
+	status := &v1.QueueStatus{}
+	return status, c.do(ctx, http.MethodGet, pathQueue, "", nil, status)
+}
+
+// PauseQueue stops the server from processing queued jobs.
+func (c *Client) PauseQueue(ctx context.Context) (*v1.QueueStatus, error) {
+	status := &v1.QueueStatus{}
+	return status, c.do(ctx, http.MethodPost, pathQueuePause, "", nil, status)
+}
+
+// ResumeQueue continues processing queued jobs after PauseQueue.
+func (c *Client) ResumeQueue(ctx context.Context) (*v1.QueueStatus, error) {
+	status := &v1.QueueStatus{}
+	return status, c.do(ctx, http.MethodPost, pathQueueResume, "", nil, status)
+}
+
+// WebhookDeliveries returns the log of webhook deliveries.
+func (c *Client) WebhookDeliveries(ctx context.Context) ([]v1.WebhookDelivery, error) {
+	deliveries := []v1.WebhookDelivery{}
+	return deliveries, c.do(ctx, http.MethodGet, pathWebhookDeliveries, "", nil, &deliveries)
+}
+
+// Events calls fn for every event of the given types, or of all types if none
+// are given, until ctx is done or fn returns an error. Events are streamed by
+// the server from the time of the call.
+func (c *Client) Events(ctx context.Context, types []v1.EventType, fn func(v1.Event) error) error {
+	path := pathEvents
+	if len(types) > 0 {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = string(t)
+		}
+		path += "?types=" + url.QueryEscape(strings.Join(names, ","))
+	}
+
+	resp, err := c.send(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		event := v1.Event{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("event stream closed by server")
+}
+
+// jobPath returns the path of the operation for the job with the given ID.
+func jobPath(path, id string) string {
+	return strings.Replace(path, "{id}", url.PathEscape(id), 1)
+}
+
+// download writes the response body of a GET request to w.
+func (c *Client) download(ctx context.Context, path string, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// do sends the request and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	resp, err := c.send(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// send sends the request and returns the response if it succeeded.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return nil, apiErr
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,304 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/swaggest/rest/web"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/client"
+	fakeconverter "github.com/st3v/plotq/converter/fake"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/filestore"
+	"github.com/st3v/plotq/handler"
+	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/spooler"
+)
+
+const svg = `<svg xmlns="http://www.w3.org/2000/svg"/>`
+
+type deliveries []v1.WebhookDelivery
+
+func (d deliveries) Deliveries() []v1.WebhookDelivery {
+	return d
+}
+
+// newService returns a service with all routes enabled that accepts the
+// tokens "alice" and "root", an admin.
+func newService(t *testing.T) (*events.Bus, *web.Service) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { q.Close() })
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	bus := events.NewBus()
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, store, c.Spy, spooler.WithEventBus(bus))
+
+	tokens := auth.NewTokens(map[string]auth.Identity{
+		"alice": {User: "alice"},
+		"root":  {User: "root", Roles: []auth.Role{auth.RoleAdmin}},
+	})
+
+	return bus, handler.New(s,
+		handler.WithAuthenticators(tokens),
+		handler.WithEventBus(bus),
+		handler.WithWebhookDeliveries(deliveries{}),
+	)
+}
+
+// newServer starts a server and returns its URL and event bus.
+func newServer(t *testing.T) (string, *events.Bus) {
+	bus, service := newService(t)
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+	return server.URL, bus
+}
+
+func submit(t *testing.T, c *client.Client) *v1.Job {
+	job, err := c.Submit(context.Background(), v1.JobRequest{
+		Plotter:  "hp7550:1337",
+		Device:   v1.DeviceHP7550,
+		Pagesize: v1.PagesizeA4,
+		Velocity: 20,
+	}, strings.NewReader(svg), "drawing.svg")
+	require.NoError(t, err)
+	return job
+}
+
+func TestJobs(t *testing.T) {
+	url, _ := newServer(t)
+	c := client.New(url, client.WithToken("alice"))
+	ctx := context.Background()
+
+	job := submit(t, c)
+	require.Equal(t, "alice", job.User)
+	require.Equal(t, uint8(20), job.Settings.Velocity)
+	require.Equal(t, v1.JobStatusPending, job.Status)
+
+	require.Equal(t, "drawing.svg", job.Filename)
+
+	list, err := c.Jobs(ctx, v1.JobQuery{})
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 1)
+	require.Equal(t, job.ID, list.Jobs[0].ID)
+
+	found, err := c.Job(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, job.SVGHash, found.SVGHash)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.SVG(ctx, job.ID, buf))
+	require.Equal(t, svg, buf.String())
+
+	// the job has not been converted yet
+	err = c.HPGL(ctx, job.ID, &bytes.Buffer{})
+	require.True(t, client.IsNotFound(err), err)
+
+	plotters, err := c.Plotters(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []v1.Plotter{{Address: "hp7550:1337", Status: v1.PlotterStatusUnknown, Pending: 1}}, plotters)
+
+	canceled, err := c.Cancel(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusCanceled, canceled.Status)
+
+	restored, err := c.Restore(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusPending, restored.Status)
+
+	var apiErr *client.Error
+	_, err = c.Restore(ctx, job.ID)
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusConflict, apiErr.StatusCode)
+
+	_, err = c.Restore(ctx, "unknown")
+	require.True(t, client.IsNotFound(err), err)
+
+	_, err = c.Job(ctx, "unknown")
+	require.True(t, client.IsNotFound(err), err)
+}
+
+func TestListJobs(t *testing.T) {
+	url, _ := newServer(t)
+	c := client.New(url, client.WithToken("alice"))
+	ctx := context.Background()
+
+	first := submit(t, c)
+	second := submit(t, c)
+
+	query := v1.JobQuery{
+		Status:         v1.JobStatusPending,
+		User:           "alice",
+		Plotter:        "hp7550:1337",
+		SubmittedAfter: first.SubmittedAt.Add(-time.Second),
+		Search:         "DRAWING",
+		Sort:           v1.JobSortSubmittedAtDesc,
+		Limit:          1,
+	}
+
+	list, err := c.Jobs(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 1)
+	require.Equal(t, second.ID, list.Jobs[0].ID)
+	require.NotEmpty(t, list.Next)
+
+	query.Cursor = list.Next
+	list, err = c.Jobs(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 1)
+	require.Equal(t, first.ID, list.Jobs[0].ID)
+	require.Empty(t, list.Next)
+
+	list, err = c.Jobs(ctx, v1.JobQuery{User: "bob"})
+	require.NoError(t, err)
+	require.Empty(t, list.Jobs)
+
+	_, err = c.Jobs(ctx, v1.JobQuery{Cursor: "invalid"})
+	apiErr := &client.Error{}
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestSubmitPriority(t *testing.T) {
+	url, _ := newServer(t)
+	request := v1.JobRequest{
+		Plotter:  "hp7550:1337",
+		Device:   v1.DeviceHP7550,
+		Pagesize: v1.PagesizeA4,
+		Priority: 2,
+	}
+
+	// only admins can set a priority
+	c := client.New(url, client.WithToken("alice"))
+	_, err := c.Submit(context.Background(), request, strings.NewReader(svg), "urgent.svg")
+	require.ErrorContains(t, err, "403")
+
+	c = client.New(url, client.WithToken("root"))
+	job, err := c.Submit(context.Background(), request, strings.NewReader(svg), "urgent.svg")
+	require.NoError(t, err)
+	require.Equal(t, 2, job.Priority)
+}
+
+func TestResubmit(t *testing.T) {
+	url, _ := newServer(t)
+	c := client.New(url, client.WithToken("alice"))
+	ctx := context.Background()
+
+	parent := submit(t, c)
+
+	job, err := c.Resubmit(ctx, parent.ID, v1.ResubmitRequest{
+		Pagesize: v1.PagesizeA3,
+		Velocity: 10,
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, parent.ID, job.ID)
+	require.Equal(t, parent.ID, job.Parent)
+	require.Equal(t, parent.SVGHash, job.SVGHash)
+	require.Equal(t, "drawing.svg", job.Filename)
+	require.Equal(t, v1.JobSettings{
+		Device:      parent.Settings.Device,
+		Pagesize:    v1.PagesizeA3,
+		Orientation: parent.Settings.Orientation,
+		Velocity:    10,
+	}, job.Settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.SVG(ctx, job.ID, buf))
+	require.Equal(t, svg, buf.String())
+
+	_, err = c.Resubmit(ctx, "unknown", v1.ResubmitRequest{})
+	require.True(t, client.IsNotFound(err), err)
+}
+
+func TestSubmitUnauthenticated(t *testing.T) {
+	url, _ := newServer(t)
+	c := client.New(url, client.WithToken("mallory"))
+
+	_, err := c.Submit(context.Background(), v1.JobRequest{Plotter: "hp7550:1337"}, strings.NewReader(svg), "drawing.svg")
+	apiErr := &client.Error{}
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestQueue(t *testing.T) {
+	url, _ := newServer(t)
+	ctx := context.Background()
+
+	_, err := client.New(url, client.WithToken("alice")).PauseQueue(ctx)
+	require.ErrorContains(t, err, "403")
+
+	c := client.New(url, client.WithToken("root"))
+
+	status, err := c.PauseQueue(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Paused)
+
+	status, err = c.Queue(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Paused)
+
+	status, err = c.ResumeQueue(ctx)
+	require.NoError(t, err)
+	require.False(t, status.Paused)
+
+	deliveries, err := c.WebhookDeliveries(ctx)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+}
+
+func TestEvents(t *testing.T) {
+	url, bus := newServer(t)
+	c := client.New(url, client.WithToken("alice"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := make(chan v1.Event, 1)
+	done := make(chan error)
+	go func() {
+		done <- c.Events(ctx, []v1.EventType{v1.EventJobSubmitted}, func(event v1.Event) error {
+			received <- event
+			return errors.New("stop")
+		})
+	}()
+
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	job := submit(t, c)
+	event := <-received
+	require.Equal(t, v1.EventJobSubmitted, event.Type)
+	require.Equal(t, job.ID, event.Job.ID)
+	require.EqualError(t, <-done, "stop")
+}
+
+func TestError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status": "NOT_FOUND", "error": "job not found"}`))
+	}))
+	defer server.Close()
+
+	_, err := client.New(server.URL).Job(context.Background(), "job")
+	require.EqualError(t, err, "server responded with 401 Unauthorized")
+
+	_, err = client.New(server.URL, client.WithBasicAuth("alice", "secret")).Job(context.Background(), "job")
+	require.EqualError(t, err, "server responded with 404: job not found")
+	require.True(t, client.IsNotFound(err))
+}
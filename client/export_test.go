@@ -0,0 +1,10 @@
+package client
+
+// Operations returns the method and path of all operations called by the client.
+func Operations() [][2]string {
+	ops := make([][2]string, len(operations))
+	for i, op := range operations {
+		ops[i] = [2]string{op.method, op.path}
+	}
+	return ops
+}
@@ -0,0 +1,58 @@
+package client_test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/st3v/plotq/client"
+)
+
+var update = flag.Bool("update", false, "update the OpenAPI spec at "+specFile)
+
+const specFile = "../api/v1/openapi.json"
+
+// spec returns the OpenAPI spec served by the handler with all routes enabled.
+func spec(t *testing.T) []byte {
+	_, service := newService(t)
+
+	data, err := json.MarshalIndent(service.OpenAPI, "", "  ")
+	require.NoError(t, err)
+	return append(data, '\n')
+}
+
+func TestSpecUpToDate(t *testing.T) {
+	actual := spec(t)
+
+	if *update {
+		require.NoError(t, os.WriteFile(specFile, actual, 0o644))
+	}
+
+	expected, err := os.ReadFile(specFile)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(actual), "the API changed, run go test ./client -update and update the client")
+}
+
+func TestClientCoversSpec(t *testing.T) {
+	data, err := os.ReadFile(specFile)
+	require.NoError(t, err)
+
+	doc := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &doc))
+
+	specified := [][2]string{}
+	for path, methods := range doc.Paths {
+		for method := range methods {
+			specified = append(specified, [2]string{strings.ToUpper(method), path})
+		}
+	}
+
+	// every operation of the spec is called by the client and vice versa
+	require.ElementsMatch(t, specified, client.Operations())
+}
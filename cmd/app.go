@@ -2,52 +2,458 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/config"
 	"github.com/st3v/plotq/converter"
+	"github.com/st3v/plotq/events"
 	"github.com/st3v/plotq/filestore"
 	"github.com/st3v/plotq/handler"
+	"github.com/st3v/plotq/janitor"
 	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/logging"
+	"github.com/st3v/plotq/mqtt"
+	"github.com/st3v/plotq/notify"
+	"github.com/st3v/plotq/plotter"
 	"github.com/st3v/plotq/spooler"
+	"github.com/st3v/plotq/tracing"
+	"github.com/st3v/plotq/webhook"
 	"github.com/st3v/plotq/worker"
 )
 
-var (
-	queueDir  = filepath.Join("data", "queue")
-	uploadDir = filepath.Join("data", "upload")
-)
+// shutdownTimeout limits how long to wait for open requests on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+const usage = `Usage: plotq [command] [flags]
+
+Commands:
+  serve    run the service (default)
+  config   print the effective configuration
+  migrate  import the LevelDB job queue in data.queue into a new SQLite queue in data.sqlite
+
+Run "plotq <command> -h" to list all flags.
+`
 
 func main() {
-	queue, err := jobqueue.OpenLocal(queueDir)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	if command != "serve" && command != "config" && command != "migrate" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load("plotq "+command, args, os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+
+	if command == "config" {
+		if err := cfg.Redacted().Write(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "migrate" {
+		if err := migrate(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := serve(cfg); err != nil {
+		slog.Error("service failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// queue is a job queue that can be checked and closed.
+type queue interface {
+	jobqueue.Queue
+	Check() error
+	Close() error
+}
+
+// openQueue opens the job queue of the configured type.
+func openQueue(cfg config.Config) (queue, error) {
+	if cfg.Data.QueueType == config.QueueSQLite {
+		if err := os.MkdirAll(filepath.Dir(cfg.Data.SQLite), 0o755); err != nil {
+			return nil, err
+		}
+		return jobqueue.OpenSQLite(cfg.Data.SQLite)
+	}
+	return jobqueue.OpenLocal(cfg.Data.Queue)
+}
+
+// migrate imports the jobs of the LevelDB queue in the queue directory into a
+// new SQLite queue. The jobs are imported into a temporary file that only
+// replaces the configured file once all of them have been imported, so failed
+// imports can simply be run again.
+func migrate(cfg config.Config) error {
+	if _, err := os.Stat(cfg.Data.Queue); err != nil {
+		return fmt.Errorf("no queue to import: %w", err)
+	}
+
+	path := cfg.Data.SQLite
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("SQLite queue %s already exists", path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := removeSQLite(tmp); err != nil {
+		return err
+	}
+
+	n, err := importQueue(tmp, cfg.Data.Queue)
+	if err != nil {
+		removeSQLite(tmp)
+		return fmt.Errorf("failed to import jobs: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		removeSQLite(tmp)
+		return err
+	}
+
+	fmt.Printf("imported %d jobs into %s, set data.queueType to %s to use it\n", n, path, config.QueueSQLite)
+	return nil
+}
+
+// importQueue imports the jobs of the LevelDB queue in dir into a new SQLite
+// queue in the given file.
+func importQueue(path, dir string) (int, error) {
+	q, err := jobqueue.OpenSQLite(path)
 	if err != nil {
-		log.Fatal(fmt.Errorf("failed to create job queue: %w", err))
+		return 0, fmt.Errorf("failed to open SQLite queue: %w", err)
 	}
 
-	uploadStore, err := filestore.NewLocalStore(uploadDir)
+	n, err := jobqueue.Import(q, dir)
 	if err != nil {
-		log.Fatal(fmt.Errorf("failed to create upload file store: %w", err))
+		q.Close()
+		return 0, err
 	}
 
-	converter := converter.Vpype()
-	spool := spooler.NewSpooler(queue, uploadStore, converter.Convert)
-	handler := handler.New(spool)
+	return n, q.Close()
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// removeSQLite removes the SQLite database in the given file along with its
+// write-ahead log.
+func removeSQLite(path string) error {
+	for _, name := range []string{path, path + "-wal", path + "-shm"} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
 	}
+	return nil
+}
 
+// serve runs the service until it receives SIGTERM or SIGINT or the server
+// fails. The queue is closed and spans are flushed before errors are returned.
+func serve(cfg config.Config) error {
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		return fmt.Errorf("invalid log configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	// ctx is canceled once the worker has been drained and stops all other services
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go worker.Run(ctx, spool)
+	// the OTLP exporter is configured by the standard OTEL_* environment variables
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		shutdown, err := tracing.Setup(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to configure tracing: %w", err)
+		}
+		defer shutdown(context.Background())
+	}
+
+	queue, err := openQueue(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create job queue: %w", err)
+	}
+	defer queue.Close()
+
+	uploadStore, err := newUploadStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create upload file store: %w", err)
+	}
+
+	bus := events.NewBus()
+
+	plotterOpts := []plotter.ConnOption{plotter.WithTimeout(cfg.Plotter.Timeout)}
+	if cfg.Plotter.Bidirectional {
+		plotterOpts = append(plotterOpts, plotter.WithBidirectional())
+	}
+
+	converter := converter.Vpype(converter.VpypeCommand(cfg.Converter.VpypePath))
+	spool := spooler.NewSpooler(queue, uploadStore, converter.Convert,
+		spooler.WithEventBus(bus),
+		spooler.PlotterOptions(plotterOpts...),
+	)
+	prometheus.MustRegister(spool)
 
-	log.Printf("Starting service - http://localhost:%s/v1/docs\n", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%s", port), handler); err != nil {
-		log.Fatal(err)
+	authenticators, err := newAuthenticators(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure authentication: %w", err)
+	}
+
+	storeCheck := filestore.NewWritableCheck(uploadStore, filestore.DefaultProbeInterval)
+	handlerOpts := []handler.Option{
+		handler.WithAuthenticators(authenticators...),
+		handler.WithEventBus(bus),
+		handler.WithReadinessChecks(map[string]handler.Check{
+			"queue":     func(context.Context) error { return queue.Check() },
+			"store":     func(context.Context) error { return storeCheck.Check() },
+			"converter": func(context.Context) error { return converter.Check() },
+		}),
+	}
+
+	// services are stopped by canceling ctx
+	var services sync.WaitGroup
+	run := func(service func()) {
+		services.Add(1)
+		go func() {
+			defer services.Done()
+			service()
+		}()
+	}
+
+	if cfg.Webhooks.File != "" {
+		hooks, err := webhook.LoadHooks(cfg.Webhooks.File)
+		if err != nil {
+			return fmt.Errorf("failed to configure webhooks: %w", err)
+		}
+
+		dispatcher := webhook.NewDispatcher(hooks)
+		handlerOpts = append(handlerOpts, handler.WithWebhookDeliveries(dispatcher))
+		run(func() { dispatcher.Run(ctx, bus) })
+	}
+
+	if cfg.SMTP.Host != "" {
+		mailer, err := newMailer(cfg.SMTP)
+		if err != nil {
+			return fmt.Errorf("failed to configure email notifications: %w", err)
+		}
+		run(func() { mailer.Run(ctx, bus) })
+	}
+
+	if cfg.MQTT.Broker != "" {
+		bridge, err := mqtt.NewBridge(mqtt.Config{
+			Broker:   cfg.MQTT.Broker,
+			ClientID: cfg.MQTT.ClientID,
+			Username: cfg.MQTT.Username,
+			Password: cfg.MQTT.Password,
+			Prefix:   cfg.MQTT.Prefix,
+			Commands: cfg.MQTT.Commands,
+		}, spool)
+		if err != nil {
+			return fmt.Errorf("failed to configure MQTT: %w", err)
+		}
+
+		run(func() {
+			if err := bridge.Run(ctx, bus); err != nil {
+				slog.Error("MQTT bridge stopped", "error", err)
+			}
+		})
+	}
+
+	server := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: handler.New(spool, handlerOpts...),
+		// ends event streams on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	workerDone := make(chan struct{})
+	go func() {
+		worker.Run(workerCtx, spool, worker.WithEventBus(bus), worker.WithDrainTimeout(cfg.Spooler.DrainTimeout))
+		close(workerDone)
+	}()
+
+	policy := janitor.Policy{
+		MaxAge:        cfg.Retention.MaxAge,
+		MaxPerUser:    cfg.Retention.MaxPerUser,
+		KeepFailedFor: cfg.Retention.KeepFailedFor,
+	}
+	run(func() { janitor.Run(ctx, spool, policy, cfg.Retention.Interval) })
+
+	serverErr := make(chan error, 1)
+	go func() {
+		scheme := "http"
+		if cfg.TLS.CertFile != "" {
+			scheme = "https"
+		}
+		slog.Info("starting service", "listen", cfg.Listen, "docs", fmt.Sprintf("%s://%s/v1/docs", scheme, docsHost(cfg.Listen)))
+
+		var err error
+		if cfg.TLS.CertFile != "" {
+			err = server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("server stopped: %w", err)
+		}
+	}()
+
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// a failed server shuts down the service like a signal
+	var failed error
+	select {
+	case <-signals.Done():
+	case failed = <-serverErr:
+	}
+
+	// reject new job requests and let the current plot finish within the drain timeout
+	slog.Info("shutting down", "drain_timeout", cfg.Spooler.DrainTimeout)
+	spool.Drain()
+	stopWorker()
+	<-workerDone
+
+	cancel()
+	services.Wait()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down server", "error", err)
 	}
+
+	slog.Info("stopped")
+	return failed
+}
+
+// docsHost returns the host to link the docs at for the listen address.
+func docsHost(listen string) string {
+	if strings.HasPrefix(listen, ":") {
+		return "localhost" + listen
+	}
+	return listen
+}
+
+// newMailer returns a mailer sending notifications through the configured SMTP
+// host. Templates are read from the configured files.
+func newMailer(cfg config.SMTP) (*notify.Mailer, error) {
+	mailConfig := notify.MailConfig{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+	}
+
+	templates := []struct {
+		path string
+		tmpl *string
+	}{
+		{cfg.SubjectTemplate, &mailConfig.Subject},
+		{cfg.BodyTemplate, &mailConfig.Body},
+	}
+
+	for _, t := range templates {
+		if t.path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(t.path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read template: %w", err)
+		}
+		*t.tmpl = string(data)
+	}
+
+	return notify.NewMailer(mailConfig)
+}
+
+// newUploadStore returns an S3 file store if an S3 endpoint is configured and
+// a local file store otherwise.
+func newUploadStore(cfg config.Config) (filestore.Store, error) {
+	if cfg.S3.Endpoint == "" {
+		return filestore.NewLocalStore(cfg.Data.Upload)
+	}
+
+	return filestore.NewS3Store(filestore.S3Config{
+		Endpoint:        cfg.S3.Endpoint,
+		Region:          cfg.S3.Region,
+		Bucket:          cfg.S3.Bucket,
+		Prefix:          cfg.S3.Prefix,
+		AccessKeyID:     cfg.S3.AccessKeyID,
+		SecretAccessKey: cfg.S3.SecretAccessKey,
+		Timeout:         cfg.S3.Timeout,
+	})
+}
+
+// newAuthenticators returns the configured authenticators. Authentication is
+// disabled if none is configured.
+func newAuthenticators(cfg config.Config) ([]auth.Authenticator, error) {
+	authenticators := []auth.Authenticator{}
+
+	if path := cfg.Auth.TokensFile; path != "" {
+		tokens, err := auth.LoadTokens(path)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+
+	if path := cfg.Auth.HtpasswdFile; path != "" {
+		htpasswd, err := auth.LoadHtpasswd(path)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, htpasswd)
+	}
+
+	if oidcConfig := cfg.Auth.OIDC; oidcConfig.Issuer != "" {
+		roles := map[string]auth.Role{}
+		for group, role := range oidcConfig.Roles {
+			roles[group] = auth.Role(role)
+		}
+
+		oidc, err := auth.NewOIDC(auth.OIDCConfig{
+			Issuer:      oidcConfig.Issuer,
+			Audience:    oidcConfig.Audience,
+			UserClaim:   oidcConfig.UserClaim,
+			GroupsClaim: oidcConfig.GroupsClaim,
+			Roles:       roles,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, oidc)
+	}
+
+	return authenticators, nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultServer is the server used unless configured otherwise.
+	DefaultServer = "http://localhost:8080"
+
+	// EnvConfigFile, EnvServer and EnvToken are the environment variables
+	// overriding the configuration file.
+	EnvConfigFile = "PLOTQCTL_CONFIG"
+	EnvServer     = "PLOTQ_SERVER"
+	EnvToken      = "PLOTQ_TOKEN"
+)
+
+// config holds the connection settings, read from ~/.config/plotq/plotqctl.yaml
+// by default.
+type config struct {
+	Server string `yaml:"server"`
+	Token  string `yaml:"token"`
+}
+
+// defaultConfigFile returns the path of the configuration file in the user's
+// configuration directory.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "plotq", "plotqctl.yaml")
+}
+
+// loadConfig reads the configuration file at path and applies the environment.
+// A missing file is ignored unless it was named explicitly.
+func loadConfig(path string, explicit bool, getenv func(string) string) (config, error) {
+	c := config{Server: DefaultServer}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			// no configuration file
+		} else if err != nil {
+			return c, fmt.Errorf("could not read config file: %w", err)
+		} else if err := yaml.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	if s := getenv(EnvServer); s != "" {
+		c.Server = s
+	}
+	if s := getenv(EnvToken); s != "" {
+		c.Token = s
+	}
+
+	return c, nil
+}
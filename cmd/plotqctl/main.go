@@ -0,0 +1,328 @@
+// Command plotqctl is a command-line client for the plotq API.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/client"
+)
+
+const usage = `Usage: plotqctl <command> [flags] [args]
+
+Commands:
+  submit FILE    submit an SVG file to be plotted
+  resubmit ID    submit the SVG of a job again, with settings changed by flags
+  jobs           list jobs, filtered and sorted by flags, --watch to follow events
+  job ID         show a job
+  cancel ID      cancel a job
+  restore ID     restore a canceled job that is still queued
+  download ID    download the SVG or, with --hpgl, the converted HPGL of a job
+  plotters       list plotters
+
+The server URL and token are read from the configuration file
+~/.config/plotq/plotqctl.yaml ($PLOTQCTL_CONFIG) with the settings server and
+token, and can be overridden by $PLOTQ_SERVER and $PLOTQ_TOKEN or flags.
+
+Run "plotqctl <command> -h" to list the flags of a command.
+`
+
+// errUsage is returned for invalid invocations.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Getenv, os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// command is a subcommand of plotqctl.
+type command struct {
+	args  string
+	setup func(fs *flag.FlagSet) func(ctx context.Context, c *client.Client, p printer, args []string) error
+}
+
+var commands = map[string]command{
+	"submit":   {"FILE", submit},
+	"resubmit": {"ID", resubmit},
+	"jobs":     {"", jobs},
+	"job":      {"ID", job},
+	"cancel":   {"ID", cancel},
+	"restore":  {"ID", restore},
+	"download": {"ID", download},
+	"plotters": {"", plotters},
+}
+
+// run runs the command named by the first argument.
+func run(ctx context.Context, args []string, getenv func(string) string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		if len(args) == 0 {
+			return errUsage
+		}
+		return flag.ErrHelp
+	}
+
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", name, usage)
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("plotqctl "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: plotqctl %s [flags] %s\n\nFlags:\n", name, cmd.args)
+		fs.PrintDefaults()
+	}
+
+	configFile := fs.String("config", "", fmt.Sprintf("configuration file ($%s)", EnvConfigFile))
+	server := fs.String("server", "", fmt.Sprintf("URL of the plotq server ($%s)", EnvServer))
+	token := fs.String("token", "", fmt.Sprintf("API token ($%s)", EnvToken))
+	output := outputTable
+	fs.Func("o", "output format, table or json", func(s string) error {
+		if s != outputTable && s != outputJSON {
+			return fmt.Errorf("unknown output format %q", s)
+		}
+		output = s
+		return nil
+	})
+
+	action := cmd.setup(fs)
+
+	if err := fs.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return err
+	} else if err != nil {
+		return errUsage
+	}
+
+	if want := len(strings.Fields(cmd.args)); fs.NArg() != want {
+		fs.Usage()
+		return errUsage
+	}
+
+	path, explicit := *configFile, true
+	if path == "" {
+		path = getenv(EnvConfigFile)
+	}
+	if path == "" {
+		path, explicit = defaultConfigFile(), false
+	}
+
+	cfg, err := loadConfig(path, explicit, getenv)
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = *server
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+
+	return action(ctx, client.New(cfg.Server, client.WithToken(cfg.Token)), printer{w: stdout, format: output}, fs.Args())
+}
+
+func submit(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	request := v1.JobRequest{}
+	fs.StringVar(&request.Plotter, "plotter", "", "network address of the plotter (required)")
+	fs.StringVar((*string)(&request.Device), "device", "", fmt.Sprintf("device configuration %v (required)", v1.Device("").Enum()))
+	fs.StringVar((*string)(&request.Pagesize), "pagesize", "", fmt.Sprintf("pagesize of the plot %v (required)", v1.Pagesize("").Enum()))
+	fs.StringVar((*string)(&request.Orientation), "orientation", "", fmt.Sprintf("orientation of the plot %v", v1.Orientation("").Enum()))
+	fs.Func("velocity", "plotting velocity", func(s string) error {
+		var v uint8
+		if _, err := fmt.Sscan(s, &v); err != nil {
+			return errors.New("must be a number between 0 and 255")
+		}
+		request.Velocity = v
+		return nil
+	})
+	fs.IntVar(&request.Priority, "priority", 0, "jobs with a higher priority are processed first by queues supporting priorities, admins only")
+	fs.StringVar(&request.User, "user", os.Getenv("USER"), "name of the user, ignored by servers requiring authentication")
+	fs.StringVar(&request.Notify, "notify", "", "email address notified about the job")
+
+	return func(ctx context.Context, c *client.Client, p printer, args []string) error {
+		if request.Plotter == "" || request.Device == "" || request.Pagesize == "" {
+			return fmt.Errorf("%w: --plotter, --device and --pagesize are required", errUsage)
+		}
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		job, err := c.Submit(ctx, request, file, filepath.Base(args[0]))
+		if err != nil {
+			return err
+		}
+		return p.job(*job)
+	}
+}
+
+func resubmit(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	request := v1.ResubmitRequest{}
+	fs.StringVar(&request.Plotter, "plotter", "", "network address of the plotter")
+	fs.StringVar((*string)(&request.Device), "device", "", fmt.Sprintf("device configuration %v", v1.Device("").Enum()))
+	fs.StringVar((*string)(&request.Pagesize), "pagesize", "", fmt.Sprintf("pagesize of the plot %v", v1.Pagesize("").Enum()))
+	fs.StringVar((*string)(&request.Orientation), "orientation", "", fmt.Sprintf("orientation of the plot %v", v1.Orientation("").Enum()))
+	fs.Func("velocity", "plotting velocity", func(s string) error {
+		var v uint8
+		if _, err := fmt.Sscan(s, &v); err != nil {
+			return errors.New("must be a number between 0 and 255")
+		}
+		request.Velocity = v
+		return nil
+	})
+	fs.IntVar(&request.Priority, "priority", 0, "jobs with a higher priority are processed first by queues supporting priorities, admins only")
+	fs.StringVar(&request.User, "user", "", "name of the user, ignored by servers requiring authentication")
+	fs.StringVar(&request.Notify, "notify", "", "email address notified about the job")
+
+	return func(ctx context.Context, c *client.Client, p printer, args []string) error {
+		job, err := c.Resubmit(ctx, args[0], request)
+		if err != nil {
+			return err
+		}
+		return p.job(*job)
+	}
+}
+
+func jobs(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	query := v1.JobQuery{}
+	fs.StringVar((*string)(&query.Status), "status", "", fmt.Sprintf("only list jobs with the given status %v", v1.JobStatus("").Enum()))
+	fs.StringVar(&query.User, "user", "", "only list jobs of the given user")
+	fs.StringVar(&query.Plotter, "plotter", "", "only list jobs for the given plotter")
+	fs.StringVar(&query.Search, "q", "", "only list jobs whose ID or file name contains the given text")
+	fs.StringVar((*string)(&query.Sort), "sort", "", fmt.Sprintf("order of the jobs %v", v1.JobSort("").Enum()))
+	fs.IntVar(&query.Limit, "limit", 0, fmt.Sprintf("maximum number of jobs to list (default %d)", v1.DefaultListLimit))
+	all := fs.Bool("all", false, "list all matching jobs, requesting one page after another")
+	watch := fs.Bool("watch", false, "follow job events after listing the jobs")
+
+	return func(ctx context.Context, c *client.Client, p printer, _ []string) error {
+		jobs := []v1.Job{}
+		for {
+			list, err := c.Jobs(ctx, query)
+			if err != nil {
+				return err
+			}
+
+			jobs = append(jobs, list.Jobs...)
+			if !*all || list.Next == "" {
+				break
+			}
+			query.Cursor = list.Next
+		}
+
+		if err := p.jobs(jobs); err != nil {
+			return err
+		}
+
+		if !*watch {
+			return nil
+		}
+
+		types := []v1.EventType{}
+		for _, t := range v1.EventType("").Enum() {
+			if t := t.(v1.EventType); strings.HasPrefix(string(t), "job.") {
+				types = append(types, t)
+			}
+		}
+
+		return c.Events(ctx, types, p.event)
+	}
+}
+
+func job(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	return func(ctx context.Context, c *client.Client, p printer, args []string) error {
+		job, err := c.Job(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return p.job(*job)
+	}
+}
+
+func cancel(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	return func(ctx context.Context, c *client.Client, p printer, args []string) error {
+		job, err := c.Cancel(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return p.job(*job)
+	}
+}
+
+func restore(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	return func(ctx context.Context, c *client.Client, p printer, args []string) error {
+		job, err := c.Restore(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return p.job(*job)
+	}
+}
+
+func download(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	hpgl := fs.Bool("hpgl", false, "download the converted HPGL instead of the SVG")
+	out := fs.String("file", "", "file to write to, defaults to ID.svg or ID.hpgl, - for stdout")
+
+	return func(ctx context.Context, c *client.Client, p printer, args []string) error {
+		id, ext, get := args[0], "svg", c.SVG
+		if *hpgl {
+			ext, get = "hpgl", c.HPGL
+		}
+
+		path := *out
+		if path == "" {
+			path = id + "." + ext
+		}
+
+		if path == "-" {
+			return get(ctx, id, p.w)
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+
+		if err := get(ctx, id, file); err != nil {
+			file.Close()
+			os.Remove(path)
+			return err
+		}
+
+		return file.Close()
+	}
+}
+
+func plotters(fs *flag.FlagSet) func(context.Context, *client.Client, printer, []string) error {
+	return func(ctx context.Context, c *client.Client, p printer, _ []string) error {
+		plotters, err := c.Plotters(ctx)
+		if err != nil {
+			return err
+		}
+		return p.plotters(plotters)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/auth"
+	fakeconverter "github.com/st3v/plotq/converter/fake"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/filestore"
+	"github.com/st3v/plotq/handler"
+	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/spooler"
+)
+
+const svg = `<svg xmlns="http://www.w3.org/2000/svg"/>`
+
+// newServer starts a plotq server accepting the token "secret" for alice and
+// returns the path of a plotqctl config file pointing to it along with the
+// server's event bus.
+func newServer(t *testing.T) (string, *events.Bus) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { q.Close() })
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	bus := events.NewBus()
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, store, c.Spy, spooler.WithEventBus(bus))
+
+	tokens := auth.NewTokens(map[string]auth.Identity{"secret": {User: "alice"}})
+	server := httptest.NewServer(handler.New(s, handler.WithAuthenticators(tokens), handler.WithEventBus(bus)))
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "plotqctl.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server: "+server.URL+"\ntoken: secret\n"), 0o600))
+
+	return path, bus
+}
+
+// plotqctl runs plotqctl with the given arguments and returns its output.
+func plotqctl(t *testing.T, env map[string]string, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	err := run(context.Background(), args, func(key string) string { return env[key] }, stdout, io.Discard)
+	return stdout.String(), err
+}
+
+func submitSVG(t *testing.T, env map[string]string) v1.Job {
+	path := filepath.Join(t.TempDir(), "drawing.svg")
+	require.NoError(t, os.WriteFile(path, []byte(svg), 0o600))
+
+	out, err := plotqctl(t, env, "submit", "-o", "json", "--plotter", "hp7550:1337", "--device", "hp7550", "--pagesize", "a4", "--velocity", "20", path)
+	require.NoError(t, err)
+
+	job := v1.Job{}
+	require.NoError(t, json.Unmarshal([]byte(out), &job))
+	return job
+}
+
+func TestCommands(t *testing.T) {
+	path, _ := newServer(t)
+	env := map[string]string{EnvConfigFile: path}
+
+	job := submitSVG(t, env)
+	require.Equal(t, "alice", job.User)
+	require.Equal(t, uint8(20), job.Settings.Velocity)
+	require.Equal(t, v1.JobStatusPending, job.Status)
+
+	out, err := plotqctl(t, env, "jobs")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	require.Regexp(t, `^ID\s+USER\s+PLOTTER\s+STATUS`, lines[0])
+	require.Regexp(t, `^`+job.ID+`\s+alice\s+hp7550:1337\s+Pending`, lines[1])
+
+	out, err = plotqctl(t, env, "job", job.ID)
+	require.NoError(t, err)
+	require.Contains(t, out, "Pagesize:    a4")
+
+	out, err = plotqctl(t, env, "plotters", "-o", "json")
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"address": "hp7550:1337", "status": "Unknown", "pending": 1}]`, out)
+
+	path = filepath.Join(t.TempDir(), "preview.svg")
+	_, err = plotqctl(t, env, "download", "--file", path, job.ID)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, svg, string(data))
+
+	// the job has not been converted yet
+	_, err = plotqctl(t, env, "download", "--hpgl", "--file", "-", job.ID)
+	require.ErrorContains(t, err, "404")
+
+	out, err = plotqctl(t, env, "cancel", "-o", "json", job.ID)
+	require.NoError(t, err)
+	require.Contains(t, out, `"status": "Canceled"`)
+
+	out, err = plotqctl(t, env, "restore", "-o", "json", job.ID)
+	require.NoError(t, err)
+	require.Contains(t, out, `"status": "Pending"`)
+
+	out, err = plotqctl(t, env, "resubmit", "--pagesize", "a3", "--velocity", "10", job.ID)
+	require.NoError(t, err)
+	require.Contains(t, out, "Pagesize:    a3")
+	require.Contains(t, out, "Velocity:    10")
+	require.Contains(t, out, "Parent:      "+job.ID)
+}
+
+func TestListJobs(t *testing.T) {
+	path, _ := newServer(t)
+	env := map[string]string{EnvConfigFile: path}
+
+	first := submitSVG(t, env)
+	second := submitSVG(t, env)
+
+	out, err := plotqctl(t, env, "jobs", "--limit", "1", "--sort", "-submittedAt")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	require.Regexp(t, `^`+second.ID, lines[1])
+
+	out, err = plotqctl(t, env, "jobs", "--limit", "1", "--all", "--status", "Pending", "--user", "alice", "--plotter", "hp7550:1337", "--q", "drawing")
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	require.Regexp(t, `^`+first.ID, lines[1])
+	require.Regexp(t, `^`+second.ID, lines[2])
+
+	out, err = plotqctl(t, env, "jobs", "--user", "bob")
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 1)
+
+	_, err = plotqctl(t, env, "jobs", "--sort", "size")
+	require.ErrorContains(t, err, "400")
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path, _ := newServer(t)
+
+	// the environment overrides the config file
+	_, err := plotqctl(t, map[string]string{EnvConfigFile: path, EnvToken: "invalid"}, "jobs")
+	require.ErrorContains(t, err, "401")
+
+	// flags override the environment
+	_, err = plotqctl(t, map[string]string{EnvConfigFile: path, EnvToken: "invalid"}, "jobs", "--token", "secret")
+	require.NoError(t, err)
+
+	_, err = plotqctl(t, nil, "jobs", "--config", filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorContains(t, err, "could not read config file")
+}
+
+func TestUsage(t *testing.T) {
+	_, err := plotqctl(t, nil)
+	require.ErrorIs(t, err, errUsage)
+
+	_, err = plotqctl(t, nil, "print")
+	require.ErrorIs(t, err, errUsage)
+
+	_, err = plotqctl(t, nil, "job")
+	require.ErrorIs(t, err, errUsage)
+
+	_, err = plotqctl(t, nil, "jobs", "-o", "yaml")
+	require.ErrorIs(t, err, errUsage)
+}
+
+func TestWatchJobs(t *testing.T) {
+	path, bus := newServer(t)
+	env := map[string]string{EnvConfigFile: path}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- run(ctx, []string{"jobs", "--watch"}, func(key string) string { return env[key] }, w, io.Discard)
+		w.Close()
+	}()
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	require.Regexp(t, `^ID\s+USER`, <-lines)
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	job := submitSVG(t, env)
+	require.Regexp(t, string(v1.EventJobSubmitted)+`\s+`+job.ID+`\s+hp7550:1337\s+Pending`, <-lines)
+
+	cancel()
+	require.NoError(t, <-done)
+}
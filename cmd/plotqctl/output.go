@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printer writes API objects as a table or as JSON.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// json writes v as indented JSON.
+func (p printer) json(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// table writes the rows aligned in columns below the header.
+func (p printer) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+var jobHeader = []string{"ID", "USER", "PLOTTER", "STATUS", "SUBMITTED", "ERROR"}
+
+func jobRow(job v1.Job) []string {
+	return []string{job.ID, job.User, job.Plotter, string(job.Status), timestamp(job.SubmittedAt), job.Error}
+}
+
+func (p printer) jobs(jobs []v1.Job) error {
+	if p.format == outputJSON {
+		return p.json(jobs)
+	}
+
+	rows := make([][]string, 0, len(jobs))
+	for _, job := range jobs {
+		rows = append(rows, jobRow(job))
+	}
+	return p.table(jobHeader, rows)
+}
+
+// job writes the details of a single job.
+func (p printer) job(job v1.Job) error {
+	if p.format == outputJSON {
+		return p.json(job)
+	}
+
+	rows := [][]string{
+		{"ID:", job.ID},
+		{"User:", job.User},
+		{"Plotter:", job.Plotter},
+		{"Status:", string(job.Status)},
+		{"Device:", string(job.Settings.Device)},
+		{"Pagesize:", string(job.Settings.Pagesize)},
+		{"Orientation:", string(job.Settings.Orientation)},
+		{"Velocity:", fmt.Sprint(job.Settings.Velocity)},
+		{"Submitted:", timestamp(job.SubmittedAt)},
+	}
+	if job.StartedAt != nil {
+		rows = append(rows, []string{"Started:", timestamp(*job.StartedAt)})
+	}
+	if job.FinishedAt != nil {
+		rows = append(rows, []string{"Finished:", timestamp(*job.FinishedAt)})
+	}
+	if job.Parent != "" {
+		rows = append(rows, []string{"Parent:", job.Parent})
+	}
+	if job.Error != "" {
+		rows = append(rows, []string{"Error:", job.Error})
+	}
+	for _, e := range job.PlotterErrors {
+		rows = append(rows, []string{"Plotter error:", e})
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 1, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	return tw.Flush()
+}
+
+func (p printer) plotters(plotters []v1.Plotter) error {
+	if p.format == outputJSON {
+		return p.json(plotters)
+	}
+
+	rows := make([][]string, 0, len(plotters))
+	for _, plotter := range plotters {
+		rows = append(rows, []string{plotter.Address, string(plotter.Status), fmt.Sprint(plotter.Pending), plotter.Processing})
+	}
+	return p.table([]string{"ADDRESS", "STATUS", "PENDING", "PROCESSING"}, rows)
+}
+
+// event writes the event as a single line so a stream of events can be followed.
+func (p printer) event(event v1.Event) error {
+	if p.format == outputJSON {
+		return json.NewEncoder(p.w).Encode(event)
+	}
+
+	line := fmt.Sprintf("%s  %-16s", timestamp(event.Time), event.Type)
+	switch {
+	case event.Progress != nil && event.Job != nil:
+		line += fmt.Sprintf("  %s  %d/%d bytes", event.Job.ID, event.Progress.Sent, event.Progress.Total)
+	case event.Job != nil:
+		line += fmt.Sprintf("  %s  %s  %s", event.Job.ID, event.Job.Plotter, event.Job.Status)
+	case event.Plotter != "":
+		line += "  " + event.Plotter
+	}
+
+	_, err := fmt.Fprintln(p.w, line)
+	return err
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.DateTime)
+}
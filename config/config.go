@@ -0,0 +1,356 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/filestore"
+	"github.com/st3v/plotq/janitor"
+	"github.com/st3v/plotq/logging"
+	"github.com/st3v/plotq/mqtt"
+	"github.com/st3v/plotq/notify"
+	"github.com/st3v/plotq/spooler"
+	"github.com/st3v/plotq/worker"
+)
+
+// ConverterVpype is the converter running vpype, currently the only one.
+const ConverterVpype = "vpype"
+
+// Implementations of the job queue.
+const (
+	QueueLevelDB = "leveldb"
+	QueueSQLite  = "sqlite"
+)
+
+// redacted replaces secrets when printing the configuration.
+const redacted = "REDACTED"
+
+// Config is the configuration of plotq. Every setting can be set in the
+// configuration file, by the environment variable named in its env tag and by
+// the command-line flag named after its path in the file, e.g. --plotter.timeout.
+type Config struct {
+	Listen    string    `yaml:"listen" env:"LISTEN_ADDR" usage:"address to listen on"`
+	TLS       TLS       `yaml:"tls"`
+	Log       Log       `yaml:"log"`
+	Data      Data      `yaml:"data"`
+	S3        S3        `yaml:"s3"`
+	Converter Converter `yaml:"converter"`
+	Plotter   Plotter   `yaml:"plotter"`
+	Spooler   Spooler   `yaml:"spooler"`
+	Retention Retention `yaml:"retention"`
+	Auth      Auth      `yaml:"auth"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+	SMTP      SMTP      `yaml:"smtp"`
+	MQTT      MQTT      `yaml:"mqtt"`
+}
+
+// TLS enables HTTPS if both files are set.
+type TLS struct {
+	CertFile string `yaml:"certFile" env:"TLS_CERT_FILE" usage:"PEM-encoded certificate to serve HTTPS with"`
+	KeyFile  string `yaml:"keyFile" env:"TLS_KEY_FILE" usage:"PEM-encoded private key of the certificate"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"log level: debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"log format: text or json"`
+}
+
+type Data struct {
+	Queue     string `yaml:"queue" env:"QUEUE_DIR" usage:"directory of the job queue"`
+	QueueType string `yaml:"queueType" env:"QUEUE_TYPE" usage:"implementation of the job queue: leveldb or sqlite, which supports priorities"`
+	SQLite    string `yaml:"sqlite" env:"SQLITE_FILE" usage:"database file of the sqlite job queue, outside of the queue directory"`
+	Upload    string `yaml:"upload" env:"UPLOAD_DIR" usage:"directory of uploaded and converted files unless S3 is used"`
+}
+
+// S3 stores uploaded and converted files in a bucket if Endpoint is set.
+type S3 struct {
+	Endpoint        string        `yaml:"endpoint" env:"S3_ENDPOINT" usage:"S3 endpoint, e.g. https://s3.eu-central-1.amazonaws.com"`
+	Region          string        `yaml:"region" env:"S3_REGION" usage:"S3 region"`
+	Bucket          string        `yaml:"bucket" env:"S3_BUCKET" usage:"S3 bucket"`
+	Prefix          string        `yaml:"prefix" env:"S3_PREFIX" usage:"prefix of all object names"`
+	AccessKeyID     string        `yaml:"accessKeyID" env:"S3_ACCESS_KEY_ID" usage:"S3 access key ID"`
+	SecretAccessKey string        `yaml:"secretAccessKey" env:"S3_SECRET_ACCESS_KEY" usage:"S3 secret access key" secret:"true"`
+	Timeout         time.Duration `yaml:"timeout" env:"S3_TIMEOUT" usage:"timeout for connecting to S3 and waiting for responses"`
+}
+
+type Converter struct {
+	Type      string `yaml:"type" env:"CONVERTER" usage:"converter turning SVG into HPGL: vpype"`
+	VpypePath string `yaml:"vpypePath" env:"VPYPE_PATH" usage:"path of the vpype command"`
+}
+
+type Plotter struct {
+	Timeout       time.Duration `yaml:"timeout" env:"PLOTTER_TIMEOUT" usage:"timeout for reads and writes to plotters"`
+	Bidirectional bool          `yaml:"bidirectional" env:"PLOTTER_BIDIRECTIONAL" usage:"query plotters for errors, requires a bidirectional transport"`
+}
+
+type Spooler struct {
+	DrainTimeout time.Duration `yaml:"drainTimeout" env:"DRAIN_TIMEOUT" usage:"time given to the current plot to finish on shutdown"`
+}
+
+// Retention defines how long finished jobs are kept, see janitor.Policy.
+type Retention struct {
+	MaxAge        time.Duration `yaml:"maxAge" env:"RETENTION_MAX_AGE" usage:"time after which finished jobs are removed, 0 to keep them"`
+	MaxPerUser    int           `yaml:"maxPerUser" env:"RETENTION_MAX_PER_USER" usage:"finished jobs kept per user, 0 for no limit"`
+	KeepFailedFor time.Duration `yaml:"keepFailedFor" env:"RETENTION_KEEP_FAILED_FOR" usage:"time after which failed jobs are removed, 0 to apply maxAge"`
+	Interval      time.Duration `yaml:"interval" env:"RETENTION_INTERVAL" usage:"interval between removals of expired jobs"`
+}
+
+// Auth enables authentication if any of the authenticators is configured.
+type Auth struct {
+	TokensFile   string `yaml:"tokensFile" env:"AUTH_TOKENS_FILE" usage:"file with API tokens"`
+	HtpasswdFile string `yaml:"htpasswdFile" env:"AUTH_HTPASSWD_FILE" usage:"htpasswd file with users and bcrypt hashes"`
+	OIDC         OIDC   `yaml:"oidc"`
+}
+
+type OIDC struct {
+	Issuer      string            `yaml:"issuer" env:"OIDC_ISSUER" usage:"OpenID Connect issuer accepting its ID tokens"`
+	Audience    string            `yaml:"audience" env:"OIDC_AUDIENCE" usage:"expected audience of ID tokens"`
+	UserClaim   string            `yaml:"userClaim" env:"OIDC_USER_CLAIM" usage:"claim holding the unique user name (default sub)"`
+	GroupsClaim string            `yaml:"groupsClaim" env:"OIDC_GROUPS_CLAIM" usage:"claim holding the user's groups"`
+	Roles       map[string]string `yaml:"roles" env:"OIDC_ROLES" usage:"roles by group, e.g. plotq-admins=admin"`
+}
+
+type Webhooks struct {
+	File string `yaml:"file" env:"WEBHOOKS_FILE" usage:"JSON file with webhooks"`
+}
+
+// SMTP enables email notifications if Host is set.
+type SMTP struct {
+	Host            string `yaml:"host" env:"SMTP_HOST" usage:"SMTP server sending notifications"`
+	Port            int    `yaml:"port" env:"SMTP_PORT" usage:"SMTP port"`
+	Username        string `yaml:"username" env:"SMTP_USERNAME" usage:"SMTP user"`
+	Password        string `yaml:"password" env:"SMTP_PASSWORD" usage:"SMTP password" secret:"true"`
+	From            string `yaml:"from" env:"SMTP_FROM" usage:"sender address of notifications"`
+	SubjectTemplate string `yaml:"subjectTemplate" env:"MAIL_SUBJECT_TEMPLATE" usage:"file with the template of the subject line"`
+	BodyTemplate    string `yaml:"bodyTemplate" env:"MAIL_BODY_TEMPLATE" usage:"file with the template of the message body"`
+}
+
+// MQTT enables the MQTT bridge if Broker is set.
+type MQTT struct {
+	Broker   string `yaml:"broker" env:"MQTT_BROKER" usage:"MQTT broker, e.g. tcp://mqtt:1883"`
+	ClientID string `yaml:"clientID" env:"MQTT_CLIENT_ID" usage:"MQTT client ID"`
+	Username string `yaml:"username" env:"MQTT_USERNAME" usage:"MQTT user"`
+	Password string `yaml:"password" env:"MQTT_PASSWORD" usage:"MQTT password" secret:"true"`
+	Prefix   string `yaml:"prefix" env:"MQTT_PREFIX" usage:"prefix of all MQTT topics"`
+	Commands bool   `yaml:"commands" env:"MQTT_COMMANDS" usage:"accept commands on the MQTT command topic"`
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		Listen: ":8080",
+		Log: Log{
+			Level:  "info",
+			Format: logging.FormatText,
+		},
+		Data: Data{
+			Queue:     filepath.Join("data", "queue"),
+			QueueType: QueueLevelDB,
+			SQLite:    filepath.Join("data", "jobs.db"),
+			Upload:    filepath.Join("data", "upload"),
+		},
+		Converter: Converter{
+			Type:      ConverterVpype,
+			VpypePath: "vpype",
+		},
+		S3: S3{
+			Timeout: filestore.DefaultS3Timeout,
+		},
+		Plotter: Plotter{
+			Timeout: spooler.DefaultTimeout,
+		},
+		Spooler: Spooler{
+			DrainTimeout: worker.DefaultDrainTimeout,
+		},
+		Retention: Retention{
+			MaxAge:        janitor.DefaultPolicy.MaxAge,
+			MaxPerUser:    janitor.DefaultPolicy.MaxPerUser,
+			KeepFailedFor: janitor.DefaultPolicy.KeepFailedFor,
+			Interval:      janitor.DefaultInterval,
+		},
+		SMTP: SMTP{
+			Port: notify.DefaultPort,
+		},
+		MQTT: MQTT{
+			ClientID: mqtt.DefaultClientID,
+			Prefix:   mqtt.DefaultPrefix,
+		},
+	}
+}
+
+// Validate returns an error describing every invalid setting.
+func (c Config) Validate() error {
+	errs := []error{}
+	invalid := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Listen == "" {
+		invalid("listen", "no address specified")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		invalid("tls", "both certFile and keyFile are required")
+	}
+
+	if _, err := logging.New(io.Discard, c.Log.Level, c.Log.Format); err != nil {
+		invalid("log", "%s", err)
+	}
+
+	if c.Data.Queue == "" {
+		invalid("data.queue", "no directory specified")
+	}
+
+	if c.Data.QueueType != QueueLevelDB && c.Data.QueueType != QueueSQLite {
+		invalid("data.queueType", "unknown queue type %q", c.Data.QueueType)
+	}
+
+	// the LevelDB queue owns its directory, its jobs are imported from there
+	if c.Data.SQLite == "" {
+		invalid("data.sqlite", "no file specified")
+	} else if inside(c.Data.Queue, c.Data.SQLite) {
+		invalid("data.sqlite", "must not be inside data.queue")
+	}
+
+	if c.S3.Endpoint == "" && c.Data.Upload == "" {
+		invalid("data.upload", "no directory specified")
+	}
+
+	if c.S3.Endpoint != "" {
+		if err := validURL(c.S3.Endpoint); err != nil {
+			invalid("s3.endpoint", "%s", err)
+		}
+		if c.S3.Bucket == "" {
+			invalid("s3.bucket", "no bucket specified")
+		}
+	}
+
+	if c.Converter.Type != ConverterVpype {
+		invalid("converter.type", "unknown converter %q", c.Converter.Type)
+	}
+
+	if c.Converter.Type == ConverterVpype && c.Converter.VpypePath == "" {
+		invalid("converter.vpypePath", "no command specified")
+	}
+
+	positive := map[string]time.Duration{
+		"s3.timeout":         c.S3.Timeout,
+		"plotter.timeout":    c.Plotter.Timeout,
+		"retention.interval": c.Retention.Interval,
+	}
+	for path, d := range positive {
+		if d <= 0 {
+			invalid(path, "must be positive")
+		}
+	}
+
+	nonNegative := map[string]time.Duration{
+		"spooler.drainTimeout":    c.Spooler.DrainTimeout,
+		"retention.maxAge":        c.Retention.MaxAge,
+		"retention.keepFailedFor": c.Retention.KeepFailedFor,
+	}
+	for path, d := range nonNegative {
+		if d < 0 {
+			invalid(path, "must not be negative")
+		}
+	}
+
+	if c.Retention.MaxPerUser < 0 {
+		invalid("retention.maxPerUser", "must not be negative")
+	}
+
+	for group, role := range c.Auth.OIDC.Roles {
+		if auth.Role(role) != auth.RoleAdmin {
+			invalid("auth.oidc.roles", "unknown role %q for group %q", role, group)
+		}
+	}
+
+	if c.SMTP.Port <= 0 || c.SMTP.Port > 65535 {
+		invalid("smtp.port", "invalid port %d", c.SMTP.Port)
+	}
+
+	if c.SMTP.Host != "" && c.SMTP.From == "" {
+		invalid("smtp.from", "no sender specified")
+	}
+
+	if c.MQTT.Broker != "" {
+		if err := validURL(c.MQTT.Broker); err != nil {
+			invalid("mqtt.broker", "%s", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the configuration with all secrets replaced.
+func (c Config) Redacted() Config {
+	for _, f := range fields(&c) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(redacted)
+		}
+	}
+	return c
+}
+
+// Write writes the configuration in the format of the configuration file.
+func (c Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// validURL returns an error if s is not an absolute URL.
+func validURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", s)
+	}
+	return nil
+}
+
+// inside returns true if path is dir or lies within it.
+func inside(dir, path string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// splitMap parses a comma-separated list of key=value pairs.
+func splitMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pair %q, expected key=value", pair)
+		}
+		m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return m, nil
+}
@@ -0,0 +1,185 @@
+package config_test
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/st3v/plotq/config"
+)
+
+// env returns a getenv function for the given variables.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "plotq.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestDefault(t *testing.T) {
+	cfg, err := config.Load("plotq", nil, env(nil), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, config.Default(), cfg)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+listen: ":9000"
+plotter:
+  timeout: 10s
+  bidirectional: true
+spooler:
+  drainTimeout: 2s
+auth:
+  oidc:
+    issuer: https://issuer.example.com
+    roles:
+      plotq-admins: admin
+`)
+
+	cfg, err := config.Load("plotq", []string{"--spooler.drainTimeout", "3s"}, env(map[string]string{
+		config.EnvConfigFile: path,
+		"PLOTTER_TIMEOUT":    "20s",
+		"DRAIN_TIMEOUT":      "5s",
+		"QUEUE_DIR":          "/var/lib/plotq/queue",
+	}), io.Discard)
+	require.NoError(t, err)
+
+	// file overrides defaults
+	require.Equal(t, ":9000", cfg.Listen)
+	require.True(t, cfg.Plotter.Bidirectional)
+	require.Equal(t, map[string]string{"plotq-admins": "admin"}, cfg.Auth.OIDC.Roles)
+
+	// environment overrides file
+	require.Equal(t, 20*time.Second, cfg.Plotter.Timeout)
+	require.Equal(t, "/var/lib/plotq/queue", cfg.Data.Queue)
+
+	// flags override environment
+	require.Equal(t, 3*time.Second, cfg.Spooler.DrainTimeout)
+
+	// untouched settings keep their defaults
+	require.Equal(t, config.Default().Retention, cfg.Retention)
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	path := writeFile(t, `listen: "127.0.0.1:8080"`)
+
+	cfg, err := config.Load("plotq", []string{"--config", path}, env(nil), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:8080", cfg.Listen)
+}
+
+func TestLoadBoolFlag(t *testing.T) {
+	cfg, err := config.Load("plotq", []string{"--plotter.bidirectional", "--mqtt.commands=false"}, env(map[string]string{"MQTT_COMMANDS": "true"}), io.Discard)
+	require.NoError(t, err)
+	require.True(t, cfg.Plotter.Bidirectional)
+	require.False(t, cfg.MQTT.Commands)
+}
+
+func TestLoadPort(t *testing.T) {
+	cfg, err := config.Load("plotq", nil, env(map[string]string{"PORT": "3000"}), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, ":3000", cfg.Listen)
+
+	cfg, err = config.Load("plotq", nil, env(map[string]string{"PORT": "3000", "LISTEN_ADDR": ":4000"}), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, ":4000", cfg.Listen)
+}
+
+func TestLoadMap(t *testing.T) {
+	cfg, err := config.Load("plotq", nil, env(map[string]string{"OIDC_ROLES": "plotq-admins=admin, staff = admin"}), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"plotq-admins": "admin", "staff": "admin"}, cfg.Auth.OIDC.Roles)
+
+	_, err = config.Load("plotq", nil, env(map[string]string{"OIDC_ROLES": "admin"}), io.Discard)
+	require.ErrorContains(t, err, "OIDC_ROLES")
+}
+
+func TestLoadInvalid(t *testing.T) {
+	_, err := config.Load("plotq", []string{"--plotter.timeout", "soon"}, env(nil), io.Discard)
+	require.ErrorContains(t, err, "plotter.timeout")
+
+	_, err = config.Load("plotq", []string{"--config", writeFile(t, "plotter:\n  timeot: 1s\n")}, env(nil), io.Discard)
+	require.ErrorContains(t, err, "timeot")
+
+	_, err = config.Load("plotq", []string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, env(nil), io.Discard)
+	require.ErrorContains(t, err, "could not read config file")
+
+	_, err = config.Load("plotq", []string{"serve"}, env(nil), io.Discard)
+	require.ErrorContains(t, err, "unexpected arguments")
+
+	_, err = config.Load("plotq", []string{"-h"}, env(nil), io.Discard)
+	require.ErrorIs(t, err, flag.ErrHelp)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	cfg.Listen = ""
+	cfg.TLS.CertFile = "cert.pem"
+	cfg.Log.Format = "xml"
+	cfg.Data.QueueType = "redis"
+	cfg.Data.SQLite = filepath.Join(cfg.Data.Queue, "jobs.db")
+	cfg.Converter.Type = "inkscape"
+	cfg.Plotter.Timeout = 0
+	cfg.Retention.MaxPerUser = -1
+	cfg.S3.Endpoint = "s3.example.com"
+	cfg.SMTP.Host = "smtp.example.com"
+	cfg.Auth.OIDC.Roles = map[string]string{"staff": "root"}
+
+	err := cfg.Validate()
+	for _, path := range []string{
+		"listen",
+		"tls",
+		"log",
+		"data.queueType",
+		"data.sqlite",
+		"converter.type",
+		"plotter.timeout",
+		"retention.maxPerUser",
+		"s3.endpoint",
+		"s3.bucket",
+		"smtp.from",
+		"auth.oidc.roles",
+	} {
+		require.ErrorContains(t, err, path+":")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := config.Default()
+	cfg.SMTP.Password = "hunter2"
+	cfg.S3.SecretAccessKey = "secret"
+
+	redacted := cfg.Redacted()
+	require.Equal(t, "REDACTED", redacted.SMTP.Password)
+	require.Equal(t, "REDACTED", redacted.S3.SecretAccessKey)
+	require.Empty(t, redacted.MQTT.Password)
+
+	// the original is unchanged
+	require.Equal(t, "hunter2", cfg.SMTP.Password)
+}
+
+func TestWriteRoundtrip(t *testing.T) {
+	cfg := config.Default()
+	cfg.Plotter.Timeout = 90 * time.Second
+	cfg.Auth.OIDC.Roles = map[string]string{"plotq-admins": "admin"}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, cfg.Write(buf))
+	require.Contains(t, buf.String(), "timeout: 1m30s")
+
+	loaded, err := config.Load("plotq", []string{"--config", writeFile(t, buf.String())}, env(nil), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, cfg, loaded)
+}
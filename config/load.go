@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvConfigFile is the environment variable naming the configuration file.
+const EnvConfigFile = "PLOTQ_CONFIG"
+
+// field is a setting of the configuration.
+type field struct {
+	path   string // path in the configuration file, also the name of the flag
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// Load returns the configuration made up of the defaults, the configuration
+// file, environment variables and the command-line flags in args, each
+// overriding the former. The configuration file is named by the --config flag
+// or PLOTQ_CONFIG. The configuration is validated before it is returned.
+//
+// Load returns flag.ErrHelp if args contain -h or --help.
+func Load(name string, args []string, getenv func(string) string, output io.Writer) (Config, error) {
+	c := Default()
+	settings := fields(&c)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	file := fs.String("config", getenv(EnvConfigFile), fmt.Sprintf("configuration file ($%s)", EnvConfigFile))
+
+	// flags are applied after the file and the environment
+	flags := map[string]string{}
+	for _, f := range settings {
+		f := f
+		usage := f.usage
+		if f.env != "" {
+			usage = fmt.Sprintf("%s ($%s)", usage, f.env)
+		}
+		// boolean flags can be set without a value, e.g. --mqtt.commands
+		register := fs.Func
+		if f.value.Kind() == reflect.Bool {
+			register = fs.BoolFunc
+		}
+
+		register(f.path, usage, func(s string) error {
+			flags[f.path] = s
+			return set(f.value, s)
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *file != "" {
+		if err := c.readFile(*file); err != nil {
+			return c, err
+		}
+	}
+
+	// PORT is honored for compatibility with earlier versions
+	if port := getenv("PORT"); port != "" && getenv("LISTEN_ADDR") == "" {
+		c.Listen = ":" + port
+	}
+
+	for _, f := range settings {
+		if f.env == "" {
+			continue
+		}
+
+		if s := getenv(f.env); s != "" {
+			if err := set(f.value, s); err != nil {
+				return c, fmt.Errorf("invalid value of %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range settings {
+		if s, ok := flags[f.path]; ok {
+			set(f.value, s)
+		}
+	}
+
+	return c, c.Validate()
+}
+
+// readFile reads the YAML configuration file. Unknown settings are rejected.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// fields returns the settings of the configuration in order of declaration.
+func fields(c *Config) []field {
+	return appendFields(nil, "", reflect.ValueOf(c).Elem())
+}
+
+func appendFields(fields []field, prefix string, v reflect.Value) []field {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		path := prefix + sf.Tag.Get("yaml")
+
+		if sf.Type.Kind() == reflect.Struct {
+			fields = appendFields(fields, path+".", v.Field(i))
+			continue
+		}
+
+		fields = append(fields, field{
+			path:   path,
+			env:    sf.Tag.Get("env"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+// set parses s into the value.
+func set(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case map[string]string:
+		m, err := splitMap(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package converter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	conversionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "plotq",
+		Subsystem: "converter",
+		Name:      "conversion_duration_seconds",
+		Help:      "Time it took to convert SVG to HPGL.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	vpypeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "plotq",
+		Subsystem: "converter",
+		Name:      "vpype_failures_total",
+		Help:      "Conversions that failed because vpype could not be run or exited with an error.",
+	})
+)
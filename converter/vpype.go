@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type vpype struct {
@@ -41,6 +42,14 @@ func Vpype(opts ...VpypeOption) *vpype {
 	return v
 }
 
+// Check returns an error if the vpype command cannot be found.
+func (v *vpype) Check() error {
+	if _, err := exec.LookPath(v.cmd.Path); err != nil {
+		return fmt.Errorf("vpype not available: %w", err)
+	}
+	return nil
+}
+
 // vpypeWriter is a writer that converts svg to hpgl
 type vpypeWriter struct {
 	svg    io.Reader
@@ -76,6 +85,11 @@ func (w *vpypeWriter) WriteTo(out io.Writer) (int64, error) {
 
 	w.cmd.Args = append(w.cmd.Args, commandArgs(tmp.Name(), w.config)...)
 
+	start := time.Now()
+	defer func() {
+		conversionDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	stdoutPipe, err := w.cmd.StdoutPipe()
 	if err != nil {
 		return 0, fmt.Errorf("could not get stdout pipe: %w", err)
@@ -87,6 +101,7 @@ func (w *vpypeWriter) WriteTo(out io.Writer) (int64, error) {
 	}
 
 	if err := w.cmd.Start(); err != nil {
+		vpypeFailures.Inc()
 		return 0, fmt.Errorf("could not start command: %w", err)
 	}
 
@@ -101,6 +116,7 @@ func (w *vpypeWriter) WriteTo(out io.Writer) (int64, error) {
 	}
 
 	if w.cmd.Wait() != nil {
+		vpypeFailures.Inc()
 		// vpype prints out long tracebacks on stderr and only the last line is the actual error
 		s := bufio.NewScanner(bytes.NewReader(stderr))
 		for s.Scan() {
@@ -128,3 +128,8 @@ func TestVpypeConvertInvalidCommand(t *testing.T) {
 	_, err := w.WriteTo(out)
 	require.ErrorContains(t, err, expected)
 }
+
+func TestVpypeCheck(t *testing.T) {
+	require.NoError(t, converter.Vpype(converter.VpypeCommand("sh")).Check())
+	require.ErrorContains(t, converter.Vpype(converter.VpypeCommand("invalid")).Check(), "vpype not available")
+}
@@ -0,0 +1,74 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+// DefaultBuffer is the default number of events buffered per subscriber.
+const DefaultBuffer = 64
+
+// Bus distributes events to all subscribers. Publishing never blocks, events are
+// dropped for subscribers that do not keep up.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[chan v1.Event]struct{}
+}
+
+// NewBus returns a new event bus.
+func NewBus() *Bus {
+	return &Bus{subs: map[chan v1.Event]struct{}{}}
+}
+
+// Publish sends the event to all subscribers. The event's time is set if missing.
+// Publishing to a nil bus is a no-op.
+func (b *Bus) Publish(event v1.Event) {
+	if b == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		select {
+		case sub <- event:
+		default:
+			slog.Warn("dropped event for slow subscriber", "event", event.Type)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all events published from now on and a
+// function to cancel the subscription, which closes the channel.
+func (b *Bus) Subscribe(buffer int) (<-chan v1.Event, func()) {
+	sub := make(chan v1.Event, buffer)
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+			close(sub)
+		})
+	}
+}
+
+// Subscribers returns the number of current subscribers.
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
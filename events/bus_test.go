@@ -0,0 +1,63 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+)
+
+func TestPublish(t *testing.T) {
+	bus := events.NewBus()
+
+	first, cancelFirst := bus.Subscribe(1)
+	defer cancelFirst()
+
+	second, cancelSecond := bus.Subscribe(1)
+	defer cancelSecond()
+
+	bus.Publish(v1.Event{Type: v1.EventPlotterOnline, Plotter: "plotter:1337"})
+
+	for _, sub := range []<-chan v1.Event{first, second} {
+		event := <-sub
+		require.Equal(t, v1.EventPlotterOnline, event.Type)
+		require.Equal(t, "plotter:1337", event.Plotter)
+		require.False(t, event.Time.IsZero())
+	}
+}
+
+func TestPublishDropsForSlowSubscribers(t *testing.T) {
+	bus := events.NewBus()
+
+	sub, cancel := bus.Subscribe(1)
+	defer cancel()
+
+	bus.Publish(v1.Event{Type: v1.EventJobStarted})
+	bus.Publish(v1.Event{Type: v1.EventJobSucceeded})
+
+	require.Equal(t, v1.EventJobStarted, (<-sub).Type)
+	require.Empty(t, sub)
+}
+
+func TestCancelSubscription(t *testing.T) {
+	bus := events.NewBus()
+
+	sub, cancel := bus.Subscribe(1)
+	require.Equal(t, 1, bus.Subscribers())
+
+	cancel()
+	cancel()
+	require.Zero(t, bus.Subscribers())
+
+	_, ok := <-sub
+	require.False(t, ok)
+
+	bus.Publish(v1.Event{Type: v1.EventJobStarted})
+}
+
+func TestPublishToNilBus(t *testing.T) {
+	var bus *events.Bus
+	bus.Publish(v1.Event{Type: v1.EventJobStarted})
+}
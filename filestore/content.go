@@ -0,0 +1,204 @@
+package filestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// refsSuffix is appended to a blob's hash to name the file holding its reference count.
+const refsSuffix = ".refs"
+
+var (
+	// ErrChecksumMismatch is returned when a blob's content does not match its hash.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrBlobNotFound is returned when a blob has no references.
+	ErrBlobNotFound = errors.New("blob not found")
+)
+
+// ContentStore stores blobs in an underlying Store by their SHA-256 hash. Storing
+// the same content twice adds a reference instead of a second copy, the blob is
+// deleted once its last reference has been released.
+type ContentStore struct {
+	store Store
+	mu    sync.Mutex // guards reference counts
+}
+
+// NewContentStore returns a new content-addressed store backed by the given store.
+func NewContentStore(store Store) *ContentStore {
+	return &ContentStore{store: store}
+}
+
+// Put stores the content of src and adds a reference to it. It returns the
+// hex-encoded SHA-256 hash that identifies the blob.
+func (c *ContentStore) Put(src io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "plotq-blob-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		return "", 0, fmt.Errorf("could not copy from src to temporary file: %w", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refs, err := c.refs(sum)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if refs == 0 {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return "", 0, fmt.Errorf("could not rewind temporary file: %w", err)
+		}
+
+		written, err := c.store.Put(sum, tmp)
+		if err != nil {
+			return "", 0, err
+		}
+
+		if written != size {
+			return "", 0, errors.New("size mismatch")
+		}
+	}
+
+	return sum, size, c.setRefs(sum, refs+1)
+}
+
+// Get returns a ReadCloser for the blob with the given hash. The blob is copied
+// to a temporary file and verified before it is returned, so corrupt content
+// is never handed out. It returns ErrChecksumMismatch if the content is corrupt.
+func (c *ContentStore) Get(sum string) (io.ReadCloser, error) {
+	file, err := c.store.Get(sum)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp("", "plotq-blob-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %w", err)
+	}
+	verified := tempFile{tmp}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), file); err != nil {
+		verified.Close()
+		return nil, fmt.Errorf("could not read blob %s: %w", sum, err)
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != sum {
+		verified.Close()
+		return nil, fmt.Errorf("blob %s: %w", sum, ErrChecksumMismatch)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		verified.Close()
+		return nil, fmt.Errorf("could not rewind temporary file: %w", err)
+	}
+
+	return verified, nil
+}
+
+// Stat returns information about the blob with the given hash.
+func (c *ContentStore) Stat(sum string) (FileInfo, error) {
+	return c.store.Stat(sum)
+}
+
+// Retain adds a reference to the existing blob with the given hash.
+func (c *ContentStore) Retain(sum string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refs, err := c.refs(sum)
+	if err != nil {
+		return err
+	}
+
+	if refs == 0 {
+		return ErrBlobNotFound
+	}
+
+	return c.setRefs(sum, refs+1)
+}
+
+// Release removes a reference to the blob with the given hash and deletes the
+// blob once it is no longer referenced.
+func (c *ContentStore) Release(sum string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refs, err := c.refs(sum)
+	if err != nil {
+		return err
+	}
+
+	if refs > 1 {
+		return c.setRefs(sum, refs-1)
+	}
+
+	if err := c.store.Delete(sum); err != nil {
+		return err
+	}
+
+	return c.store.Delete(sum + refsSuffix)
+}
+
+// refs returns the number of references to the blob with the given hash.
+func (c *ContentStore) refs(sum string) (int, error) {
+	file, err := c.store.Get(sum + refsSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("could not read references of %s: %w", sum, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0, fmt.Errorf("could not read references of %s: %w", sum, err)
+	}
+
+	refs, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid references of %s: %w", sum, err)
+	}
+
+	return refs, nil
+}
+
+// setRefs sets the number of references to the blob with the given hash.
+func (c *ContentStore) setRefs(sum string, refs int) error {
+	_, err := c.store.Put(sum+refsSuffix, strings.NewReader(strconv.Itoa(refs)))
+	if err != nil {
+		return fmt.Errorf("could not write references of %s: %w", sum, err)
+	}
+	return nil
+}
+
+// tempFile is a temporary file that is removed once it is closed.
+type tempFile struct {
+	*os.File
+}
+
+// Close implements io.Closer.
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
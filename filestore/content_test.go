@@ -0,0 +1,87 @@
+package filestore_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/st3v/plotq/filestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	contents := "bar"
+	sum := sha256.Sum256([]byte(contents))
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+	store := filestore.NewContentStore(local)
+
+	hash, n, err := store.Put(strings.NewReader(contents))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(contents)), n)
+	require.Equal(t, hex.EncodeToString(sum[:]), hash)
+	require.FileExists(t, filepath.Join(dir, hash))
+
+	reader, err := store.Get(hash)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	actualContents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, contents, string(actualContents))
+}
+
+func TestContentDeduplication(t *testing.T) {
+	dir := t.TempDir()
+	contents := "bar"
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+	store := filestore.NewContentStore(local)
+
+	first, _, err := store.Put(strings.NewReader(contents))
+	require.NoError(t, err)
+
+	second, _, err := store.Put(strings.NewReader(contents))
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+
+	err = store.Retain(first)
+	require.NoError(t, err)
+
+	// blob is kept until the last reference has been released
+	for i := 0; i < 3; i++ {
+		require.FileExists(t, filepath.Join(dir, first))
+		err = store.Release(first)
+		require.NoError(t, err)
+	}
+
+	require.NoFileExists(t, filepath.Join(dir, first))
+
+	err = store.Retain(first)
+	require.ErrorIs(t, err, filestore.ErrBlobNotFound)
+}
+
+func TestContentChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+	store := filestore.NewContentStore(local)
+
+	hash, _, err := store.Put(strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, hash), []byte("baz"), 0644)
+	require.NoError(t, err)
+
+	// corrupt blobs are not handed out at all
+	_, err = store.Get(hash)
+	require.ErrorIs(t, err, filestore.ErrChecksumMismatch)
+}
@@ -1,12 +1,18 @@
 package filestore
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// tmpPrefix is the prefix of temporary files written by Put.
+const tmpPrefix = ".tmp-"
+
 type local struct {
 	dir string
 }
@@ -24,26 +30,156 @@ func NewLocalStore(dataDir string) (*local, error) {
 	return &local{dir: dataDir}, nil
 }
 
-// Put writes the content of src to a file with the given name
+// Put writes the content of src to a file with the given name. The file is
+// written to a temporary file first and only renamed once complete.
 func (l *local) Put(name string, src io.Reader) (int64, error) {
-	path := filepath.Join(l.dir, name)
+	path, err := l.path(name)
+	if err != nil {
+		return 0, err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("could not create directory %s: %w", dir, err)
+	}
 
-	dst, err := os.Create(path)
+	tmp, err := os.CreateTemp(dir, tmpPrefix+"*")
 	if err != nil {
-		return 0, fmt.Errorf("could not create file %s: %w", path, err)
+		return 0, fmt.Errorf("could not create temporary file in %s: %w", dir, err)
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	written, err := io.Copy(dst, src)
+	written, err := io.Copy(tmp, src)
 	if err != nil {
 		return 0, fmt.Errorf("could not copy from from src to file %w", err)
 	}
-	dst.Close()
+
+	if err := tmp.Sync(); err != nil {
+		return 0, fmt.Errorf("could not sync file %s: %w", tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("could not close file %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("could not rename file to %s: %w", path, err)
+	}
+
+	if err := syncDir(dir); err != nil {
+		return 0, err
+	}
 
 	return written, nil
 }
 
 // Get returns a ReadCloser for the file with the given name
 func (l *local) Get(name string) (io.ReadCloser, error) {
-	path := filepath.Join(l.dir, name)
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+
 	return os.Open(path)
 }
+
+// Stat returns information about the file with the given name
+func (l *local) Stat(name string) (FileInfo, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if info.IsDir() {
+		return FileInfo{}, fmt.Errorf("%s is a directory: %w", name, fs.ErrNotExist)
+	}
+
+	return FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List returns information about all files whose name starts with the given prefix
+func (l *local) List(prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), tmpPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list files in %s: %w", l.dir, err)
+	}
+
+	return files, nil
+}
+
+// Delete removes the file with the given name. Deleting a missing file is not an error.
+func (l *local) Delete(name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not delete file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// path returns the path of the file with the given name. Names that are absolute
+// or would escape the data directory are rejected.
+func (l *local) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+
+	if name == "" || clean == "." || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" ||
+		clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	return filepath.Join(l.dir, clean), nil
+}
+
+// syncDir flushes the directory entry of a renamed file to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory %s: %w", dir, err)
+	}
+
+	return nil
+}
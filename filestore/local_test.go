@@ -1,13 +1,18 @@
 package filestore_test
 
 import (
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/st3v/plotq/filestore"
+	fakefilestore "github.com/st3v/plotq/filestore/fake"
 	"github.com/stretchr/testify/require"
 )
 
@@ -80,3 +85,149 @@ func TestLocalRoundtrip(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, contents, string(actualContents))
 }
+
+func TestLocalDelete(t *testing.T) {
+	dir := t.TempDir()
+	name := "foo"
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+
+	_, err = local.Put(name, strings.NewReader("bar"))
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(dir, name))
+
+	err = local.Delete(name)
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(dir, name))
+
+	// deleting a missing file is not an error
+	err = local.Delete(name)
+	require.NoError(t, err)
+}
+
+func TestLocalRejectsEscapingNames(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := filestore.NewLocalStore(filepath.Join(dir, "store"))
+	require.NoError(t, err)
+
+	for _, name := range []string{"", ".", "..", "../foo", "foo/../../bar", "/etc/passwd"} {
+		_, err = local.Put(name, strings.NewReader("bar"))
+		require.ErrorIs(t, err, filestore.ErrInvalidName, name)
+
+		_, err = local.Get(name)
+		require.ErrorIs(t, err, filestore.ErrInvalidName, name)
+
+		_, err = local.Stat(name)
+		require.ErrorIs(t, err, filestore.ErrInvalidName, name)
+
+		err = local.Delete(name)
+		require.ErrorIs(t, err, filestore.ErrInvalidName, name)
+	}
+
+	require.NoFileExists(t, filepath.Join(dir, "foo"))
+}
+
+func TestLocalPutFailure(t *testing.T) {
+	dir := t.TempDir()
+	name := "foo"
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+
+	_, err = local.Put(name, strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	// a failed write neither leaves a partial file behind nor replaces the existing one
+	src := io.MultiReader(strings.NewReader("baz"), iotest.ErrReader(errors.New("boom")))
+	_, err = local.Put(name, src)
+	require.ErrorContains(t, err, "boom")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	actualContents, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(actualContents))
+}
+
+func TestLocalStat(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+
+	_, err = local.Put("foo", strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	info, err := local.Stat("foo")
+	require.NoError(t, err)
+	require.Equal(t, "foo", info.Name)
+	require.Equal(t, int64(3), info.Size)
+	require.WithinDuration(t, time.Now(), info.ModTime, time.Minute)
+
+	_, err = local.Stat("bar")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestLocalList(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+
+	for _, name := range []string{"foo", "foobar", "bar", "foo/baz"} {
+		_, err = local.Put(name+".svg", strings.NewReader(name))
+		require.NoError(t, err)
+	}
+
+	files, err := local.List("foo")
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	require.ElementsMatch(t, []string{"foo.svg", "foobar.svg", "foo/baz.svg"}, names)
+
+	files, err = local.List("")
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := filestore.NewLocalStore(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, filestore.CheckWritable(local))
+
+	// the probe is removed again
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestWritableCheck(t *testing.T) {
+	store := &fakefilestore.Store{}
+	check := filestore.NewWritableCheck(store, time.Hour)
+
+	// successful probes are reused within the interval
+	require.NoError(t, check.Check())
+	require.NoError(t, check.Check())
+	require.Equal(t, 1, store.PutCallCount())
+
+	// failed probes are not
+	check = filestore.NewWritableCheck(store, time.Hour)
+	store.PutReturns(0, errors.New("disk full"))
+	require.ErrorContains(t, check.Check(), "disk full")
+	require.ErrorContains(t, check.Check(), "disk full")
+	require.Equal(t, 3, store.PutCallCount())
+
+	store.PutReturns(2, nil)
+	require.NoError(t, check.Check())
+	require.Equal(t, 4, store.PutCallCount())
+}
@@ -0,0 +1,367 @@
+package filestore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultS3Region is used if no region is configured.
+	defaultS3Region = "us-east-1"
+
+	// amzDateFormat is the format of timestamps used for signing requests.
+	amzDateFormat = "20060102T150405Z"
+
+	// DefaultS3Timeout is used if no timeout is configured.
+	DefaultS3Timeout = 30 * time.Second
+)
+
+// S3Config is the configuration for an S3-compatible object store.
+type S3Config struct {
+	// Endpoint is the base URL of the object store, e.g. http://minio:9000.
+	Endpoint string
+
+	// Region is the region of the bucket. Defaults to us-east-1.
+	Region string
+
+	// Bucket is the name of the bucket to store files in.
+	Bucket string
+
+	// Prefix is prepended to all object keys.
+	Prefix string
+
+	// AccessKeyID and SecretAccessKey are the credentials used to sign requests.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Timeout limits how long connecting to the object store and waiting for
+	// the response to a request may take. Reading response bodies is not
+	// limited since objects are streamed to plotters for as long as a plot
+	// takes. Defaults to DefaultS3Timeout.
+	Timeout time.Duration
+}
+
+type s3 struct {
+	endpoint *url.URL
+	config   S3Config
+	client   *http.Client
+}
+
+// s3 implements Store
+var _ Store = &s3{}
+
+// NewS3Store returns a new file store backed by an S3-compatible object store
+// using path-style requests.
+func NewS3Store(config S3Config) (*s3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %w", config.Endpoint, err)
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %s: scheme and host required", config.Endpoint)
+	}
+
+	if config.Bucket == "" {
+		return nil, errors.New("no bucket specified")
+	}
+
+	if config.Region == "" {
+		config.Region = defaultS3Region
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultS3Timeout
+	}
+
+	return &s3{
+		endpoint: endpoint,
+		config:   config,
+		client:   newS3Client(config.Timeout),
+	}, nil
+}
+
+// newS3Client returns an HTTP client that gives up on object stores that do
+// not respond within the given timeout.
+func newS3Client(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+
+	return &http.Client{Transport: transport}
+}
+
+// Put uploads the content of src to an object with the given name
+func (s *s3) Put(name string, src io.Reader) (int64, error) {
+	// the payload has to be hashed and its length known before sending it
+	tmp, err := os.CreateTemp("", "plotq-s3-*")
+	if err != nil {
+		return 0, fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		return 0, fmt.Errorf("could not copy from src to temporary file: %w", err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("could not rewind temporary file: %w", err)
+	}
+
+	req, err := s.newRequest(http.MethodPut, name, tmp, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = written
+
+	resp, err := s.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not put object %s: %w", name, err)
+	}
+	resp.Body.Close()
+
+	return written, nil
+}
+
+// Get returns a ReadCloser for the object with the given name
+func (s *s3) Get(name string) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, name, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not get object %s: %w", name, err)
+	}
+
+	return resp.Body, nil
+}
+
+// Stat returns information about the object with the given name
+func (s *s3) Stat(name string) (FileInfo, error) {
+	req, err := s.newRequest(http.MethodHead, name, nil, emptyPayloadHash)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("could not stat object %s: %w", name, err)
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return FileInfo{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// List returns information about all objects whose name starts with the given prefix
+func (s *s3) List(prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", s.config.Prefix+prefix)
+
+	for {
+		req, err := s.newBucketRequest(query)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not list objects: %w", err)
+		}
+
+		result := struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode object list: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			files = append(files, FileInfo{
+				Name:    strings.TrimPrefix(obj.Key, s.config.Prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			})
+		}
+
+		if !result.IsTruncated {
+			return files, nil
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Delete removes the object with the given name. Deleting a missing object is not an error.
+func (s *s3) Delete(name string) error {
+	req, err := s.newRequest(http.MethodDelete, name, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not delete object %s: %w", name, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// newRequest returns a signed request for the object with the given name.
+func (s *s3) newRequest(method, name string, body io.Reader, payloadHash string) (*http.Request, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket + "/" + s.config.Prefix + name
+
+	return s.signedRequest(method, u, body, payloadHash)
+}
+
+// newBucketRequest returns a signed GET request for the bucket with the given query.
+func (s *s3) newBucketRequest(query url.Values) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	return s.signedRequest(http.MethodGet, u, nil, emptyPayloadHash)
+}
+
+// signedRequest returns a signed request for the given URL.
+func (s *s3) signedRequest(method string, u url.URL, body io.Reader, payloadHash string) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	sign(req, s.config, payloadHash, time.Now())
+
+	return req, nil
+}
+
+// do sends the request and translates error responses. Missing objects are
+// reported as fs.ErrNotExist.
+func (s *s3) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+
+	s3Err := struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}{}
+	if err := xml.NewDecoder(resp.Body).Decode(&s3Err); err != nil || s3Err.Code == "" {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil, fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+}
+
+// emptyPayloadHash is the hex-encoded SHA-256 hash of an empty payload.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign signs the request using AWS Signature Version 4. The host and all headers
+// set on the request are signed.
+func sign(req *http.Request, config S3Config, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := strings.Join([]string{amzDate[:8], config.Region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
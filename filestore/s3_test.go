@@ -0,0 +1,180 @@
+package filestore_test
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/st3v/plotq/filestore"
+	"github.com/st3v/plotq/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newS3Store(t *testing.T) (*testutil.S3Server, filestore.Store) {
+	server := testutil.NewS3Server(t, "plotq", "minio")
+
+	store, err := filestore.NewS3Store(filestore.S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "plotq",
+		Prefix:          "upload/",
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio123",
+	})
+	require.NoError(t, err)
+
+	return server, store
+}
+
+func TestS3Put(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	n, err := store.Put("foo", strings.NewReader("bar"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	actual, ok := server.Object("upload/foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", string(actual))
+}
+
+func TestS3Roundtrip(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	_, err := store.Put("foo", strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	reader, err := store.Get("foo")
+	require.NoError(t, err)
+	defer reader.Close()
+
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(actual))
+}
+
+func TestS3GetMissing(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	_, err := store.Get("foo")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestS3Delete(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	_, err := store.Put("foo", strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	err = store.Delete("foo")
+	require.NoError(t, err)
+
+	_, ok := server.Object("upload/foo")
+	require.False(t, ok)
+
+	// deleting a missing object is not an error
+	err = store.Delete("foo")
+	require.NoError(t, err)
+}
+
+func TestS3AccessDenied(t *testing.T) {
+	server, _ := newS3Store(t)
+	defer server.Close()
+
+	store, err := filestore.NewS3Store(filestore.S3Config{
+		Endpoint:    server.URL,
+		Bucket:      "plotq",
+		AccessKeyID: "invalid",
+	})
+	require.NoError(t, err)
+
+	_, err = store.Put("foo", strings.NewReader("bar"))
+	require.ErrorContains(t, err, "AccessDenied")
+}
+
+func TestS3ContentStore(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	blobs := filestore.NewContentStore(store)
+
+	hash, _, err := blobs.Put(strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	reader, err := blobs.Get(hash)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(actual))
+
+	err = blobs.Release(hash)
+	require.NoError(t, err)
+
+	_, ok := server.Object("upload/" + hash)
+	require.False(t, ok)
+}
+
+func TestS3Stat(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	_, err := store.Put("foo", strings.NewReader("bar"))
+	require.NoError(t, err)
+
+	info, err := store.Stat("foo")
+	require.NoError(t, err)
+	require.Equal(t, "foo", info.Name)
+	require.Equal(t, int64(3), info.Size)
+	require.WithinDuration(t, time.Now(), info.ModTime, time.Minute)
+
+	_, err = store.Stat("bar")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestS3List(t *testing.T) {
+	server, store := newS3Store(t)
+	defer server.Close()
+
+	for _, name := range []string{"foo", "foobar", "bar"} {
+		_, err := store.Put(name, strings.NewReader(name))
+		require.NoError(t, err)
+	}
+
+	files, err := store.List("foo")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "foo", files[0].Name)
+	require.Equal(t, int64(3), files[0].Size)
+	require.Equal(t, "foobar", files[1].Name)
+	require.Equal(t, int64(6), files[1].Size)
+}
+
+func TestS3Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	store, err := filestore.NewS3Store(filestore.S3Config{
+		Endpoint: server.URL,
+		Bucket:   "plotq",
+		Timeout:  50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = store.Get("foo")
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second)
+}
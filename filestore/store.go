@@ -2,7 +2,17 @@ package filestore
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 
-import "io"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidName is returned for file names that are not valid within a store.
+var ErrInvalidName = errors.New("invalid file name")
 
 // Store is an interface for a file store
 //
@@ -10,4 +20,69 @@ import "io"
 type Store interface {
 	Put(name string, src io.Reader) (written int64, err error)
 	Get(name string) (file io.ReadCloser, err error)
+	Stat(name string) (info FileInfo, err error)
+	List(prefix string) (files []FileInfo, err error)
+	Delete(name string) error
+}
+
+// FileInfo describes a file in a store.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// probeName is the name of the file written by CheckWritable.
+const probeName = ".probe"
+
+// CheckWritable returns an error if files cannot be written to the store.
+func CheckWritable(store Store) error {
+	if _, err := store.Put(probeName, strings.NewReader("ok")); err != nil {
+		return fmt.Errorf("could not write probe: %w", err)
+	}
+
+	if err := store.Delete(probeName); err != nil {
+		return fmt.Errorf("could not delete probe: %w", err)
+	}
+
+	return nil
+}
+
+// DefaultProbeInterval is the default interval between two probes written by
+// a WritableCheck.
+const DefaultProbeInterval = time.Minute
+
+// WritableCheck reports whether files can be written to a store like
+// CheckWritable, but writes a probe at most once per interval after a
+// successful one. Failed probes are repeated on the next check.
+type WritableCheck struct {
+	store    Store
+	interval time.Duration
+
+	mu     sync.Mutex
+	probed time.Time // time of the last successful probe
+}
+
+// NewWritableCheck returns a new check of the given store probing at most once
+// per interval.
+func NewWritableCheck(store Store, interval time.Duration) *WritableCheck {
+	return &WritableCheck{store: store, interval: interval}
+}
+
+// Check returns an error if files could not be written to the store.
+func (c *WritableCheck) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.probed.IsZero() && time.Since(c.probed) < c.interval {
+		return nil
+	}
+
+	if err := CheckWritable(c.store); err != nil {
+		c.probed = time.Time{}
+		return err
+	}
+
+	c.probed = time.Now()
+	return nil
 }
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/logging"
+)
+
+// keepAliveInterval is the interval at which idle event streams are kept alive.
+var keepAliveInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// streamEvents returns a handler streaming events from the bus as Server-Sent
+// Events. The types query parameter restricts the stream to a comma-separated
+// list of event types. Authenticated users other than admins only receive
+// events of their own jobs.
+func streamEvents(bus *events.Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		sub, cancel := bus.Subscribe(events.DefaultBuffer)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		wanted, visible := eventTypes(r), eventOwner(r)
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case event := <-sub:
+				if !wanted(event.Type) || !visible(event) {
+					continue
+				}
+
+				data, err := json.Marshal(event)
+				if err != nil {
+					logging.FromContext(r.Context()).Error("failed to encode event", "event", event.Type, "error", err)
+					continue
+				}
+
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// streamEventsWS returns a handler streaming events from the bus over a
+// WebSocket. It supports the same types query parameter and filtering as
+// streamEvents.
+func streamEventsWS(bus *events.Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub, cancel := bus.Subscribe(events.DefaultBuffer)
+		defer cancel()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader already replied with an error
+			return
+		}
+		defer conn.Close()
+
+		// the client is not expected to send anything, reading detects when it goes away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		wanted, visible := eventTypes(r), eventOwner(r)
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-keepAlive.C:
+				deadline := time.Now().Add(keepAliveInterval)
+				if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					return
+				}
+			case event := <-sub:
+				if !wanted(event.Type) || !visible(event) {
+					continue
+				}
+
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// eventTypes returns a function reporting whether events of a type were
+// requested by the types query parameter. All types are requested if it is missing.
+func eventTypes(r *http.Request) func(v1.EventType) bool {
+	param := r.URL.Query().Get("types")
+	if param == "" {
+		return func(v1.EventType) bool { return true }
+	}
+
+	types := map[v1.EventType]bool{}
+	for _, t := range strings.Split(param, ",") {
+		types[v1.EventType(strings.TrimSpace(t))] = true
+	}
+
+	return func(t v1.EventType) bool { return types[t] }
+}
+
+// eventOwner returns a function reporting whether an event may be streamed to
+// the authenticated user. Job events of other users are only streamed to
+// admins, events not referring to a job are streamed to everyone.
+func eventOwner(r *http.Request) func(v1.Event) bool {
+	identity, ok := auth.FromContext(r.Context())
+	if !ok || identity.IsAdmin() {
+		return func(v1.Event) bool { return true }
+	}
+
+	return func(e v1.Event) bool { return e.Job == nil || e.Job.User == identity.User }
+}
@@ -0,0 +1,117 @@
+package handler_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/handler"
+	"github.com/st3v/plotq/testutil"
+)
+
+func TestStreamEvents(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(handler.New(&spooler{}, handler.WithEventBus(bus)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/events?types=job.succeeded")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	job := testutil.RandJob()
+	bus.Publish(v1.Event{Type: v1.EventJobStarted, Job: &job})
+	bus.Publish(v1.Event{Type: v1.EventJobSucceeded, Job: &job})
+
+	reader := bufio.NewReader(resp.Body)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "event: job.succeeded\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(line, "data: "))
+
+	event := v1.Event{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+	require.Equal(t, v1.EventJobSucceeded, event.Type)
+	require.Equal(t, job.ID, event.Job.ID)
+}
+
+func TestStreamEventsWebSocket(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(handler.New(&spooler{}, handler.WithEventBus(bus)))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/events/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	bus.Publish(v1.Event{Type: v1.EventPlotterOffline, Plotter: "hp7550:1337"})
+
+	event := v1.Event{}
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, v1.EventPlotterOffline, event.Type)
+	require.Equal(t, "hp7550:1337", event.Plotter)
+}
+
+func TestStreamEventsAuthRequired(t *testing.T) {
+	tokens := auth.NewTokens(map[string]auth.Identity{"alice": {User: "alice"}})
+	h := handler.New(&spooler{}, handler.WithAuthenticators(tokens), handler.WithEventBus(events.NewBus()))
+
+	rec := request(t, h, http.MethodGet, "/v1/events", "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestStreamEventsOwnJobs(t *testing.T) {
+	tokens := auth.NewTokens(map[string]auth.Identity{
+		"alice": {User: "alice"},
+		"root":  {User: "root", Roles: []auth.Role{auth.RoleAdmin}},
+	})
+	bus := events.NewBus()
+	server := httptest.NewServer(handler.New(&spooler{}, handler.WithAuthenticators(tokens), handler.WithEventBus(bus)))
+	defer server.Close()
+
+	dial := func(token string) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/events/ws"
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+		require.NoError(t, err)
+		return conn
+	}
+
+	alice, root := dial("alice"), dial("root")
+	defer alice.Close()
+	defer root.Close()
+
+	other, own := testutil.RandJob(), testutil.RandJob()
+	other.User, own.User = "bob", "alice"
+	bus.Publish(v1.Event{Type: v1.EventJobSubmitted, Job: &other})
+	bus.Publish(v1.Event{Type: v1.EventJobSubmitted, Job: &own})
+	bus.Publish(v1.Event{Type: v1.EventPlotterOffline, Plotter: "hp7550:1337"})
+
+	// users only receive events of their own jobs
+	event := v1.Event{}
+	require.NoError(t, alice.ReadJSON(&event))
+	require.Equal(t, own.ID, event.Job.ID)
+	require.NoError(t, alice.ReadJSON(&event))
+	require.Equal(t, v1.EventPlotterOffline, event.Type)
+
+	// admins receive all events
+	for _, id := range []string{other.ID, own.ID} {
+		require.NoError(t, root.ReadJSON(&event))
+		require.Equal(t, id, event.Job.ID)
+	}
+}
@@ -4,68 +4,270 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/swaggest/rest/nethttp"
 	"github.com/swaggest/rest/web"
 	"github.com/swaggest/swgui/v4emb"
 	"github.com/swaggest/usecase"
 	"github.com/swaggest/usecase/status"
 
 	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/logging"
+	spoolerpkg "github.com/st3v/plotq/spooler"
+	"github.com/st3v/plotq/tracing"
+	"github.com/st3v/plotq/ui"
 )
 
 const (
 	tagJobs     = "Jobs"
 	tagRequests = "JobRequests"
+	tagWebhooks = "Webhooks"
+	tagQueue    = "Queue"
+	tagPlotters = "Plotters"
 )
 
 type Spooler interface {
-	SubmitRequest(request *v1.JobRequest) (*v1.Job, error)
+	SubmitRequest(ctx context.Context, request *v1.JobRequest) (*v1.Job, error)
+	ResubmitJob(ctx context.Context, id string, request *v1.ResubmitRequest) (*v1.Job, error)
 	GetJob(id string) (*v1.Job, error)
-	GetJobs() ([]v1.Job, error)
+	ListJobs(query v1.JobQuery) (*v1.JobList, error)
 	DeleteJob(id string) (*v1.Job, error)
+	RestoreJob(id string) (*v1.Job, error)
+	GetSVG(job v1.Job) (io.ReadCloser, error)
+	GetHPGL(job v1.Job) (io.ReadCloser, error)
+	GetPlotters() ([]v1.Plotter, error)
+	Pause()
+	Resume()
+	Paused() bool
+	Draining() bool
 }
 
-func New(spooler Spooler) *web.Service {
+// Option is an option for the handler.
+type Option func(*options)
+
+// WithAuthenticators requires requests to the API to be authenticated by one of
+// the given authenticators.
+func WithAuthenticators(authenticators ...auth.Authenticator) Option {
+	return func(o *options) {
+		o.authenticators = append(o.authenticators, authenticators...)
+	}
+}
+
+// WithEventBus streams events from the given bus at /v1/events.
+func WithEventBus(bus *events.Bus) Option {
+	return func(o *options) {
+		o.events = bus
+	}
+}
+
+// WithWebhookDeliveries serves the webhook delivery log at /v1/webhooks/deliveries.
+func WithWebhookDeliveries(deliveries WebhookDeliveries) Option {
+	return func(o *options) {
+		o.deliveries = deliveries
+	}
+}
+
+// WithReadinessChecks adds named checks that must succeed for /readyz to
+// report the service as ready.
+func WithReadinessChecks(checks map[string]Check) Option {
+	return func(o *options) {
+		for name, check := range checks {
+			o.checks[name] = check
+		}
+	}
+}
+
+type WebhookDeliveries interface {
+	Deliveries() []v1.WebhookDelivery
+}
+
+type options struct {
+	authenticators []auth.Authenticator
+	events         *events.Bus
+	deliveries     WebhookDeliveries
+	checks         map[string]Check
+}
+
+func New(spooler Spooler, opts ...Option) *web.Service {
+	o := &options{checks: map[string]Check{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	service := web.DefaultService()
+	service.Use(tracing.Middleware, logging.Middleware)
 
 	service.OpenAPI.Info.Title = "PlotterQueue API"
 	service.OpenAPI.Info.WithDescription("Send job requests to HPGL plotters.")
 	service.OpenAPI.Info.Version = "v1"
 
-	service.Get("/v1/jobs", getJobs(spooler))
-	service.Get("/v1/jobs/{id}", getJobByID(spooler))
-	service.Post("/v1/jobs", postRequest(spooler))
-	service.Delete("/v1/jobs/{id}", deleteJobByID(spooler))
+	var api chi.Router = service.Wrapper
+	if len(o.authenticators) > 0 {
+		api = service.With(
+			auth.Middleware(o.authenticators...),
+			nethttp.HTTPBasicSecurityMiddleware(service.OpenAPICollector, "basicAuth", "User and password."),
+			nethttp.HTTPBearerSecurityMiddleware(service.OpenAPICollector, "bearerAuth", "API token or OpenID Connect ID token.", ""),
+		)
+	}
+
+	api.Method(http.MethodGet, "/v1/jobs", nethttp.NewHandler(getJobs(spooler)))
+	api.Method(http.MethodGet, "/v1/jobs/{id}", nethttp.NewHandler(getJobByID(spooler)))
+	api.Method(http.MethodPost, "/v1/jobs", nethttp.NewHandler(postRequest(spooler)))
+	api.Method(http.MethodDelete, "/v1/jobs/{id}", nethttp.NewHandler(deleteJobByID(spooler)))
+	api.Method(http.MethodPost, "/v1/jobs/{id}/restore", nethttp.NewHandler(restoreJobByID(spooler)))
+	api.Method(http.MethodPost, "/v1/jobs/{id}/resubmit", nethttp.NewHandler(resubmitJobByID(spooler)))
+	api.Method(http.MethodGet, "/v1/jobs/{id}/svg", nethttp.NewHandler(getJobSVG(spooler),
+		nethttp.SuccessfulResponseContentType("image/svg+xml")))
+	api.Method(http.MethodGet, "/v1/jobs/{id}/hpgl", nethttp.NewHandler(getJobHPGL(spooler),
+		nethttp.SuccessfulResponseContentType("application/vnd.hp-hpgl")))
+	api.Method(http.MethodGet, "/v1/plotters", nethttp.NewHandler(getPlotters(spooler)))
+	api.Method(http.MethodGet, "/v1/queue", nethttp.NewHandler(getQueueStatus(spooler)))
+	api.Method(http.MethodPost, "/v1/queue/pause", nethttp.NewHandler(setQueuePaused(spooler, true)))
+	api.Method(http.MethodPost, "/v1/queue/resume", nethttp.NewHandler(setQueuePaused(spooler, false)))
+
+	if o.events != nil {
+		api.Method(http.MethodGet, "/v1/events", streamEvents(o.events))
+		api.Method(http.MethodGet, "/v1/events/ws", streamEventsWS(o.events))
+	}
+
+	if o.deliveries != nil {
+		api.Method(http.MethodGet, "/v1/webhooks/deliveries", nethttp.NewHandler(getWebhookDeliveries(o.deliveries)))
+	}
+
 	service.Docs("/v1/docs", v4emb.New)
 
+	// metrics and probes are served without authentication
+	service.Wrapper.Method(http.MethodGet, "/metrics", promhttp.Handler())
+	service.Wrapper.Method(http.MethodGet, "/healthz", http.HandlerFunc(healthy))
+	service.Wrapper.Method(http.MethodGet, "/readyz", ready(spooler, o.checks))
+
+	// the web UI is public, it authenticates its calls to the API
+	toUI := http.RedirectHandler("/ui/", http.StatusFound)
+	service.Wrapper.Method(http.MethodGet, "/", toUI)
+	service.Wrapper.Method(http.MethodGet, "/ui", toUI)
+	service.Wrapper.Method(http.MethodGet, "/ui/*", ui.Handler("/ui/"))
+
 	return service
 }
 
 func getJobs(spooler Spooler) usecase.Interactor {
-	u := usecase.NewInteractor(func(ctx context.Context, _ struct{}, output *[]v1.Job) error {
-		var err error
-		*output, err = spooler.GetJobs()
-		return err
+	u := usecase.NewInteractor(func(ctx context.Context, input v1.JobQuery, output *v1.JobList) error {
+		list, err := spooler.ListJobs(input)
+		if errors.Is(err, jobqueue.ErrInvalidCursor) {
+			return status.Wrap(err, status.InvalidArgument)
+		} else if err != nil {
+			return err
+		}
+
+		*output = *list
+		return nil
 	})
 
 	u.SetTags(tagJobs)
+	u.SetDescription("Lists the jobs matching the query, one page at a time. Pass the returned next cursor with the same query to get the following page.")
+	u.SetExpectedErrors(status.InvalidArgument)
 
 	return u
 }
 
 func postRequest(spooler Spooler) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input v1.JobRequest, output *v1.Job) error {
-		job, err := spooler.SubmitRequest(&input)
+		if spooler.Draining() {
+			return status.Wrap(errors.New("service is shutting down"), status.Unavailable)
+		}
+
+		// authenticated users always submit in their own name
+		if identity, ok := auth.FromContext(ctx); ok {
+			input.User = identity.User
+
+			if err := checkPriority(identity, input.Priority); err != nil {
+				return err
+			}
+
+			notify, err := notifyAddress(identity, input.Notify)
+			if err != nil {
+				return err
+			}
+			input.Notify = notify
+		}
+
+		job, err := spooler.SubmitRequest(ctx, &input)
 		if err != nil {
 			return fmt.Errorf("failed to not submit request: %w", err)
 		}
 
+		logging.FromContext(ctx).Info("accepted job request", "job", job.ID, "plotter", job.Plotter, "user", job.User)
+
+		*output = *job
+		return nil
+	})
+
+	u.SetTags(tagRequests)
+	u.SetExpectedErrors(status.AlreadyExists, status.PermissionDenied, status.Unavailable)
+
+	return u
+}
+
+func resubmitJobByID(spooler Spooler) usecase.Interactor {
+	type resubmitInput struct {
+		ID string `path:"id" required:"true" example:"hp7550-5fbbd6p8"`
+		v1.ResubmitRequest
+	}
+
+	u := usecase.NewInteractor(func(ctx context.Context, input resubmitInput, output *v1.Job) error {
+		if spooler.Draining() {
+			return status.Wrap(errors.New("service is shutting down"), status.Unavailable)
+		}
+
+		if err := authorize(ctx, spooler, input.ID); err != nil {
+			return err
+		}
+
+		// authenticated users always submit in their own name
+		request := input.ResubmitRequest
+		if identity, ok := auth.FromContext(ctx); ok {
+			request.User = identity.User
+
+			if err := checkPriority(identity, request.Priority); err != nil {
+				return err
+			}
+
+			notify, err := notifyAddress(identity, request.Notify)
+			if err != nil {
+				return err
+			}
+			request.Notify = notify
+		}
+
+		job, err := spooler.ResubmitJob(ctx, input.ID, &request)
+		if errors.Is(err, spoolerpkg.ErrSVGNotFound) {
+			return status.Wrap(err, status.NotFound)
+		} else if err != nil {
+			return fmt.Errorf("failed to resubmit job: %w", err)
+		}
+
+		if job == nil {
+			return status.Wrap(errors.New("job not found"), status.NotFound)
+		}
+
+		logging.FromContext(ctx).Info("resubmitted job", "job", job.ID, "parent", job.Parent, "plotter", job.Plotter, "user", job.User)
+
 		*output = *job
 		return nil
 	})
 
 	u.SetTags(tagRequests)
-	u.SetExpectedErrors(status.AlreadyExists)
+	u.SetDescription("Submits a new job plotting the SVG of a previous job, optionally with different settings.")
+	u.SetExpectedErrors(status.NotFound, status.PermissionDenied, status.Unavailable)
 
 	return u
 }
@@ -77,12 +279,16 @@ func getJobByID(spooler Spooler) usecase.Interactor {
 
 	u := usecase.NewInteractor(func(ctx context.Context, input idInput, output *v1.Job) error {
 		job, err := spooler.GetJob(input.ID)
-		if err == nil && job == nil {
+		if err != nil {
+			return err
+		}
+
+		if job == nil {
 			return status.Wrap(errors.New("job not found"), status.NotFound)
 		}
 
 		*output = *job
-		return err
+		return nil
 	})
 
 	u.SetTags(tagJobs)
@@ -97,17 +303,237 @@ func deleteJobByID(spooler Spooler) usecase.Interactor {
 	}
 
 	u := usecase.NewInteractor(func(ctx context.Context, input idInput, output *v1.Job) error {
+		if err := authorize(ctx, spooler, input.ID); err != nil {
+			return err
+		}
+
 		job, err := spooler.DeleteJob(input.ID)
 		if err == nil && job == nil {
 			return status.Wrap(errors.New("job not found"), status.NotFound)
 		}
 
+		if err == nil {
+			logging.FromContext(ctx).Info("canceled job", "job", job.ID, "plotter", job.Plotter, "user", job.User)
+		}
+
 		*output = *job
 		return err
 	})
 
 	u.SetTags(tagJobs)
-	u.SetExpectedErrors(status.NotFound)
+	u.SetExpectedErrors(status.NotFound, status.PermissionDenied)
+
+	return u
+}
+
+func restoreJobByID(spooler Spooler) usecase.Interactor {
+	type idInput struct {
+		ID string `path:"id" required:"true" example:"hp7550-5fbbd6p8"`
+	}
+
+	u := usecase.NewInteractor(func(ctx context.Context, input idInput, output *v1.Job) error {
+		if err := authorize(ctx, spooler, input.ID); err != nil {
+			return err
+		}
+
+		job, err := spooler.RestoreJob(input.ID)
+		if errors.Is(err, jobqueue.ErrNotCanceled) {
+			return status.Wrap(err, status.Aborted)
+		} else if err != nil {
+			return err
+		}
+
+		if job == nil {
+			return status.Wrap(errors.New("job is no longer queued"), status.NotFound)
+		}
+
+		logging.FromContext(ctx).Info("restored job", "job", job.ID, "plotter", job.Plotter, "user", job.User)
+
+		*output = *job
+		return nil
+	})
+
+	u.SetTags(tagJobs)
+	u.SetDescription("Puts a canceled job that has not left the queue yet back into the pending state.")
+	u.SetExpectedErrors(status.NotFound, status.PermissionDenied, status.Aborted)
+
+	return u
+}
+
+// fileOutput streams a file of a job.
+type fileOutput struct {
+	ContentDisposition string `header:"Content-Disposition" description:"Suggested file name."`
+	usecase.OutputWithEmbeddedWriter
+}
+
+func getJobSVG(spooler Spooler) usecase.Interactor {
+	return getJobFile(spooler, "svg", "Returns the SVG file submitted with the job.", spooler.GetSVG)
+}
+
+func getJobHPGL(spooler Spooler) usecase.Interactor {
+	return getJobFile(spooler, "hpgl", "Returns the HPGL the job has been converted to.", spooler.GetHPGL)
+}
+
+// getJobFile returns an interactor streaming the file opened by open for the
+// job with the given ID to its owner or an admin.
+func getJobFile(spooler Spooler, ext, description string, open func(v1.Job) (io.ReadCloser, error)) usecase.Interactor {
+	type idInput struct {
+		ID string `path:"id" required:"true" example:"hp7550-5fbbd6p8"`
+	}
+
+	u := usecase.NewInteractor(func(ctx context.Context, input idInput, output *fileOutput) error {
+		if err := authorize(ctx, spooler, input.ID); err != nil {
+			return err
+		}
+
+		job, err := spooler.GetJob(input.ID)
+		if err != nil {
+			return err
+		}
+
+		if job == nil {
+			return status.Wrap(errors.New("job not found"), status.NotFound)
+		}
+
+		file, err := open(*job)
+		if errors.Is(err, spoolerpkg.ErrNotConverted) {
+			return status.Wrap(err, status.NotFound)
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		output.ContentDisposition = fmt.Sprintf("attachment; filename=%q", job.ID+"."+ext)
+		_, err = io.Copy(output, file)
+		return err
+	})
+
+	u.SetTags(tagJobs)
+	u.SetDescription(description)
+	u.SetExpectedErrors(status.NotFound, status.PermissionDenied)
+
+	return u
+}
+
+func getPlotters(spooler Spooler) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, _ struct{}, output *[]v1.Plotter) error {
+		var err error
+		*output, err = spooler.GetPlotters()
+		return err
+	})
+
+	u.SetTags(tagPlotters)
+
+	return u
+}
+
+func getQueueStatus(spooler Spooler) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, _ struct{}, output *v1.QueueStatus) error {
+		output.Paused = spooler.Paused()
+		return nil
+	})
+
+	u.SetTags(tagQueue)
+
+	return u
+}
+
+func setQueuePaused(spooler Spooler, paused bool) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, _ struct{}, output *v1.QueueStatus) error {
+		if err := requireAdmin(ctx); err != nil {
+			return err
+		}
+
+		if paused {
+			spooler.Pause()
+		} else {
+			spooler.Resume()
+		}
+
+		output.Paused = spooler.Paused()
+		return nil
+	})
+
+	u.SetTags(tagQueue)
+	if paused {
+		u.SetDescription("Stops processing queued jobs. The job currently being processed is not affected. The paused state is not persisted, the queue is resumed when the service restarts.")
+	} else {
+		u.SetDescription("Continues processing queued jobs.")
+	}
+	u.SetExpectedErrors(status.PermissionDenied)
+
+	return u
+}
+
+func getWebhookDeliveries(deliveries WebhookDeliveries) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, _ struct{}, output *[]v1.WebhookDelivery) error {
+		// deliveries reveal jobs of all users
+		if err := requireAdmin(ctx); err != nil {
+			return err
+		}
+
+		*output = deliveries.Deliveries()
+		return nil
+	})
+
+	u.SetTags(tagWebhooks)
+	u.SetExpectedErrors(status.PermissionDenied)
 
 	return u
 }
+
+// requireAdmin checks that the authenticated user, if any, is an admin.
+func requireAdmin(ctx context.Context) error {
+	if identity, ok := auth.FromContext(ctx); ok && !identity.IsAdmin() {
+		return status.Wrap(errors.New("admin role required"), status.PermissionDenied)
+	}
+	return nil
+}
+
+// checkPriority checks that only admins submit jobs with a priority other than
+// the default.
+func checkPriority(identity *auth.Identity, priority int) error {
+	if priority != 0 && !identity.IsAdmin() {
+		return status.Wrap(errors.New("admin role required to set a priority"), status.PermissionDenied)
+	}
+	return nil
+}
+
+// notifyAddress returns the address authenticated users are notified at. They
+// may only be notified at their own address, which is used by default.
+func notifyAddress(identity *auth.Identity, requested string) (string, error) {
+	if requested == "" {
+		return identity.Email, nil
+	}
+
+	addr, err := mail.ParseAddress(requested)
+	if err != nil || identity.Email == "" || !strings.EqualFold(addr.Address, identity.Email) {
+		return "", status.Wrap(errors.New("notifications can only be sent to your own address"), status.PermissionDenied)
+	}
+
+	return identity.Email, nil
+}
+
+// authorize checks that the authenticated user, if any, owns the job with the
+// given ID or is an admin.
+func authorize(ctx context.Context, spooler Spooler, id string) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok || identity.IsAdmin() {
+		return nil
+	}
+
+	job, err := spooler.GetJob(id)
+	if err != nil {
+		return err
+	}
+
+	if job == nil {
+		return status.Wrap(errors.New("job not found"), status.NotFound)
+	}
+
+	if job.User != identity.User {
+		return status.Wrap(errors.New("job belongs to another user"), status.PermissionDenied)
+	}
+
+	return nil
+}
@@ -0,0 +1,571 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/auth"
+	"github.com/st3v/plotq/handler"
+	"github.com/st3v/plotq/jobqueue"
+	spoolerpkg "github.com/st3v/plotq/spooler"
+	"github.com/st3v/plotq/testutil"
+)
+
+type spooler struct {
+	jobs     map[string]v1.Job
+	queries  []v1.JobQuery
+	requests []v1.JobRequest
+	resubmit []v1.ResubmitRequest
+	canceled []string
+	restored []string
+	paused   bool
+	draining bool
+	traces   []trace.TraceID
+}
+
+func (s *spooler) SubmitRequest(ctx context.Context, request *v1.JobRequest) (*v1.Job, error) {
+	s.requests = append(s.requests, *request)
+	s.traces = append(s.traces, trace.SpanContextFromContext(ctx).TraceID())
+	job := testutil.RandJob()
+	job.User = request.User
+	return &job, nil
+}
+
+func (s *spooler) ResubmitJob(ctx context.Context, id string, request *v1.ResubmitRequest) (*v1.Job, error) {
+	parent, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if parent.SVG == "" {
+		return nil, spoolerpkg.ErrSVGNotFound
+	}
+
+	s.resubmit = append(s.resubmit, *request)
+	job := testutil.RandPendingJob()
+	job.User = request.User
+	job.Parent = parent.ID
+	job.Settings.Velocity = request.Velocity
+	return &job, nil
+}
+
+func (s *spooler) GetJob(id string) (*v1.Job, error) {
+	if id == "unreadable" {
+		return nil, errors.New("failed to read job")
+	}
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (s *spooler) ListJobs(query v1.JobQuery) (*v1.JobList, error) {
+	s.queries = append(s.queries, query)
+	if query.Cursor == "invalid" {
+		return nil, jobqueue.ErrInvalidCursor
+	}
+
+	list := &v1.JobList{Jobs: []v1.Job{}, Next: "next"}
+	for _, job := range s.jobs {
+		list.Jobs = append(list.Jobs, job)
+	}
+	return list, nil
+}
+
+func (s *spooler) DeleteJob(id string) (*v1.Job, error) {
+	s.canceled = append(s.canceled, id)
+	return s.GetJob(id)
+}
+
+func (s *spooler) RestoreJob(id string) (*v1.Job, error) {
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if job.Status != v1.JobStatusCanceled {
+		return nil, jobqueue.ErrNotCanceled
+	}
+
+	s.restored = append(s.restored, id)
+	job.Status = v1.JobStatusPending
+	s.jobs[id] = job
+	return &job, nil
+}
+
+func (s *spooler) GetSVG(job v1.Job) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewBufferString("<svg/>")), nil
+}
+
+func (s *spooler) GetHPGL(job v1.Job) (io.ReadCloser, error) {
+	if job.HPGL == "" {
+		return nil, spoolerpkg.ErrNotConverted
+	}
+	return io.NopCloser(bytes.NewBufferString("IN;PU;")), nil
+}
+
+func (s *spooler) GetPlotters() ([]v1.Plotter, error) {
+	return []v1.Plotter{{Address: "hp7550:1337", Status: v1.PlotterStatusOnline, Pending: 1}}, nil
+}
+
+func (s *spooler) Pause() {
+	s.paused = true
+}
+
+func (s *spooler) Resume() {
+	s.paused = false
+}
+
+func (s *spooler) Paused() bool {
+	return s.paused
+}
+
+func (s *spooler) Draining() bool {
+	return s.draining
+}
+
+func newAuthService(t *testing.T) (*spooler, http.Handler) {
+	job := testutil.RandJob()
+	job.ID = "job"
+	job.User = "alice"
+
+	s := &spooler{jobs: map[string]v1.Job{job.ID: job}}
+
+	tokens := auth.NewTokens(map[string]auth.Identity{
+		"alice": {User: "alice", Email: "alice@example.com"},
+		"bob":   {User: "bob"},
+		"root":  {User: "root", Roles: []auth.Role{auth.RoleAdmin}},
+	})
+
+	return s, handler.New(s, handler.WithAuthenticators(tokens))
+}
+
+func request(t *testing.T, h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAuthRequired(t *testing.T) {
+	_, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodGet, "/v1/jobs", "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = request(t, h, http.MethodGet, "/v1/jobs", "invalid")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = request(t, h, http.MethodGet, "/v1/jobs", "bob")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// docs and metrics remain public
+	rec = request(t, h, http.MethodGet, "/v1/docs/openapi.json", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "bearerAuth")
+
+	rec = request(t, h, http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "go_goroutines")
+}
+
+func TestListJobs(t *testing.T) {
+	s, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodGet, "/v1/jobs?status=Pending&user=alice&plotter=hp7550:1337&submittedAfter=2024-01-02T15:04:05Z&q=drawing&sort=-user&limit=10&cursor=abc", "bob")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	list := v1.JobList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Jobs, 1)
+	require.Equal(t, "next", list.Next)
+
+	require.Len(t, s.queries, 1)
+	query := s.queries[0]
+	require.Equal(t, v1.JobStatusPending, query.Status)
+	require.Equal(t, "alice", query.User)
+	require.Equal(t, "hp7550:1337", query.Plotter)
+	require.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), query.SubmittedAfter.UTC())
+	require.True(t, query.SubmittedBefore.IsZero())
+	require.Equal(t, "drawing", query.Search)
+	require.Equal(t, v1.JobSortUserDesc, query.Sort)
+	require.Equal(t, 10, query.Limit)
+	require.Equal(t, "abc", query.Cursor)
+
+	// defaults
+	rec = request(t, h, http.MethodGet, "/v1/jobs", "bob")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, v1.JobSortSubmittedAt, s.queries[1].Sort)
+	require.Equal(t, v1.DefaultListLimit, s.queries[1].Limit)
+
+	for _, query := range []string{"cursor=invalid", "sort=size", "limit=0", "limit=1001", "status=Done"} {
+		rec = request(t, h, http.MethodGet, "/v1/jobs?"+query, "bob")
+		require.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	_, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodGet, "/v1/jobs/job", "bob")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"id":"job"`)
+
+	rec = request(t, h, http.MethodGet, "/v1/jobs/missing", "bob")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = request(t, h, http.MethodGet, "/v1/jobs/unreadable", "bob")
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestCancelOwnJobOnly(t *testing.T) {
+	s, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodDelete, "/v1/jobs/job", "bob")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, s.canceled)
+
+	rec = request(t, h, http.MethodDelete, "/v1/jobs/missing", "bob")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Empty(t, s.canceled)
+
+	rec = request(t, h, http.MethodDelete, "/v1/jobs/job", "alice")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []string{"job"}, s.canceled)
+
+	rec = request(t, h, http.MethodDelete, "/v1/jobs/job", "root")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []string{"job", "job"}, s.canceled)
+}
+
+func TestRestoreOwnJobOnly(t *testing.T) {
+	s, h := newAuthService(t)
+
+	job := s.jobs["job"]
+	job.Status = v1.JobStatusCanceled
+	s.jobs["job"] = job
+
+	rec := request(t, h, http.MethodPost, "/v1/jobs/job/restore", "bob")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, s.restored)
+
+	rec = request(t, h, http.MethodPost, "/v1/jobs/missing/restore", "root")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = request(t, h, http.MethodPost, "/v1/jobs/job/restore", "alice")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []string{"job"}, s.restored)
+	require.Contains(t, rec.Body.String(), `"status":"Pending"`)
+
+	// only canceled jobs can be restored
+	rec = request(t, h, http.MethodPost, "/v1/jobs/job/restore", "root")
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, []string{"job"}, s.restored)
+}
+
+// submitRequest returns a job request submitted in the name of the given user.
+func submitRequest(t *testing.T, user string) *http.Request {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	require.NoError(t, form.WriteField("user", user))
+	require.NoError(t, form.WriteField("plotter", "hp7550:1337"))
+	require.NoError(t, form.WriteField("device", "hp7550"))
+	require.NoError(t, form.WriteField("pagesize", "a4"))
+	svg, err := form.CreateFormFile("svg", "plot.svg")
+	require.NoError(t, err)
+	_, err = svg.Write([]byte("<svg/>"))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestSubmitAsAuthenticatedUser(t *testing.T) {
+	s, h := newAuthService(t)
+
+	req := submitRequest(t, "mallory")
+	req.Header.Set("Authorization", "Bearer alice")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	require.Len(t, s.requests, 1)
+	require.Equal(t, "alice", s.requests[0].User)
+	require.Equal(t, "alice@example.com", s.requests[0].Notify)
+
+	// the request is submitted within the caller's trace
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.traces[0].String())
+
+	job := v1.Job{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	require.Equal(t, "alice", job.User)
+}
+
+func TestResubmitOwnJobOnly(t *testing.T) {
+	s, h := newAuthService(t)
+
+	resubmit := func(id, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs/"+id+"/resubmit", strings.NewReader(`{"user":"mallory","velocity":20}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := resubmit("job", "bob")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, s.resubmit)
+
+	rec = resubmit("missing", "root")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = resubmit("job", "alice")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// authenticated users always resubmit in their own name
+	require.Len(t, s.resubmit, 1)
+	require.Equal(t, "alice", s.resubmit[0].User)
+	require.Equal(t, "alice@example.com", s.resubmit[0].Notify)
+	require.Equal(t, uint8(20), s.resubmit[0].Velocity)
+
+	job := v1.Job{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	require.Equal(t, "job", job.Parent)
+	require.Equal(t, uint8(20), job.Settings.Velocity)
+
+	rec = resubmit("job", "root")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "root", s.resubmit[1].User)
+
+	// jobs whose SVG has been removed cannot be resubmitted
+	orphan := s.jobs["job"]
+	orphan.ID, orphan.SVG = "orphan", ""
+	s.jobs[orphan.ID] = orphan
+
+	rec = resubmit("orphan", "alice")
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+}
+
+func TestNotifyOwnAddressOnly(t *testing.T) {
+	s, h := newAuthService(t)
+
+	resubmit := func(notify string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs/job/resubmit", strings.NewReader(`{"notify":"`+notify+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := resubmit("victim@example.com")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, s.resubmit)
+
+	rec = resubmit("Alice <ALICE@example.com>")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "alice@example.com", s.resubmit[0].Notify)
+
+	// notification addresses are not exposed
+	require.NotContains(t, rec.Body.String(), "notify")
+}
+
+func TestPriorityAdminOnly(t *testing.T) {
+	s, h := newAuthService(t)
+
+	resubmit := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs/job/resubmit", strings.NewReader(`{"priority":5}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := resubmit("alice")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, s.resubmit)
+
+	rec = resubmit("root")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, 5, s.resubmit[0].Priority)
+}
+
+type deliveries []v1.WebhookDelivery
+
+func (d deliveries) Deliveries() []v1.WebhookDelivery {
+	return d
+}
+
+func TestWebhookDeliveriesAdminOnly(t *testing.T) {
+	tokens := auth.NewTokens(map[string]auth.Identity{
+		"bob":  {User: "bob"},
+		"root": {User: "root", Roles: []auth.Role{auth.RoleAdmin}},
+	})
+	log := deliveries{{ID: "delivery", URL: "http://example.com", Event: v1.EventJobFailed, Attempt: 1}}
+	h := handler.New(&spooler{}, handler.WithAuthenticators(tokens), handler.WithWebhookDeliveries(log))
+
+	rec := request(t, h, http.MethodGet, "/v1/webhooks/deliveries", "bob")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = request(t, h, http.MethodGet, "/v1/webhooks/deliveries", "root")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	result := []v1.WebhookDelivery{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, []v1.WebhookDelivery(log), result)
+}
+
+func TestPauseQueueAdminOnly(t *testing.T) {
+	s, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodPost, "/v1/queue/pause", "bob")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.False(t, s.paused)
+
+	rec = request(t, h, http.MethodPost, "/v1/queue/pause", "root")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, s.paused)
+
+	rec = request(t, h, http.MethodGet, "/v1/queue", "bob")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"paused": true}`, rec.Body.String())
+
+	rec = request(t, h, http.MethodPost, "/v1/queue/resume", "root")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.False(t, s.paused)
+}
+
+func TestReadiness(t *testing.T) {
+	s := &spooler{}
+	var storeErr error
+	h := handler.New(s,
+		handler.WithAuthenticators(auth.NewTokens(map[string]auth.Identity{})),
+		handler.WithReadinessChecks(map[string]handler.Check{
+			"store": func(context.Context) error { return storeErr },
+		}),
+	)
+
+	// probes are public
+	rec := request(t, h, http.MethodGet, "/healthz", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	readiness := func(code int) v1.Readiness {
+		rec := request(t, h, http.MethodGet, "/readyz", "")
+		require.Equal(t, code, rec.Code, rec.Body.String())
+
+		r := v1.Readiness{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &r))
+		return r
+	}
+
+	r := readiness(http.StatusOK)
+	require.True(t, r.Ready)
+	require.Equal(t, map[string]string{"spooler": "ok", "store": "ok"}, r.Checks)
+
+	storeErr = errors.New("read-only file system")
+	r = readiness(http.StatusServiceUnavailable)
+	require.False(t, r.Ready)
+	require.Equal(t, "read-only file system", r.Checks["store"])
+
+	storeErr = nil
+	s.draining = true
+	r = readiness(http.StatusServiceUnavailable)
+	require.Equal(t, "shutting down", r.Checks["spooler"])
+}
+
+func TestSubmitWhileDraining(t *testing.T) {
+	s := &spooler{draining: true}
+	h := handler.New(s)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, submitRequest(t, "alice"))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+	require.Empty(t, s.requests)
+}
+
+func TestDownloadJobFiles(t *testing.T) {
+	s, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodGet, "/v1/jobs/job/svg", "alice")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="job.svg"`, rec.Header().Get("Content-Disposition"))
+	require.Equal(t, "<svg/>", rec.Body.String())
+
+	// HPGL is only available once the job has been converted
+	rec = request(t, h, http.MethodGet, "/v1/jobs/job/hpgl", "alice")
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+
+	job := s.jobs["job"]
+	job.HPGL = "hash"
+	s.jobs["job"] = job
+
+	rec = request(t, h, http.MethodGet, "/v1/jobs/job/hpgl", "alice")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "IN;PU;", rec.Body.String())
+
+	// files of other users are only available to admins
+	for _, file := range []string{"svg", "hpgl"} {
+		rec = request(t, h, http.MethodGet, "/v1/jobs/job/"+file, "bob")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+		rec = request(t, h, http.MethodGet, "/v1/jobs/job/"+file, "root")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec = request(t, h, http.MethodGet, "/v1/jobs/unknown/svg", "bob")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetPlotters(t *testing.T) {
+	_, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodGet, "/v1/plotters", "alice")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.JSONEq(t, `[{"address": "hp7550:1337", "status": "Online", "pending": 1}]`, rec.Body.String())
+}
+
+func TestWebUI(t *testing.T) {
+	_, h := newAuthService(t)
+
+	rec := request(t, h, http.MethodGet, "/", "")
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "/ui/", rec.Header().Get("Location"))
+
+	// the UI is public, the API it calls is not
+	rec = request(t, h, http.MethodGet, "/ui/", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `<script src="app.js">`)
+
+	rec = request(t, h, http.MethodGet, "/ui/app.js", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+// checkTimeout limits how long a single readiness check may take.
+const checkTimeout = 5 * time.Second
+
+// Check reports whether a dependency of the service is ready.
+type Check func(ctx context.Context) error
+
+// healthy serves liveness probes. It succeeds as long as the process serves requests.
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// ready serves readiness probes. It runs all checks concurrently and responds
+// with 503 Service Unavailable if any of them fails or the spooler is draining.
+func ready(spooler Spooler, checks map[string]Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all := map[string]Check{
+			"spooler": func(context.Context) error {
+				if spooler.Draining() {
+					return errors.New("shutting down")
+				}
+				return nil
+			},
+		}
+		for name, check := range checks {
+			all[name] = check
+		}
+
+		readiness := v1.Readiness{Ready: true, Checks: map[string]string{}}
+
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for name, check := range all {
+			wg.Add(1)
+			go func(name string, check Check) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+				defer cancel()
+
+				result := "ok"
+				err := check(ctx)
+				if err != nil {
+					result = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				readiness.Checks[name] = result
+				readiness.Ready = readiness.Ready && err == nil
+			}(name, check)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !readiness.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(readiness)
+	}
+}
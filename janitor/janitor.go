@@ -0,0 +1,138 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/logging"
+)
+
+// DefaultInterval is the default interval between two janitor runs.
+const DefaultInterval = time.Hour
+
+// DefaultPolicy is the default retention policy.
+var DefaultPolicy = Policy{
+	MaxAge:        30 * 24 * time.Hour,
+	MaxPerUser:    100,
+	KeepFailedFor: 90 * 24 * time.Hour,
+}
+
+// Policy defines how long finished jobs are retained. Zero values disable the
+// respective limit.
+type Policy struct {
+	// MaxAge is the time after which finished jobs are removed.
+	MaxAge time.Duration
+
+	// MaxPerUser is the number of finished jobs retained per user. Older jobs are removed first.
+	MaxPerUser int
+
+	// KeepFailedFor is the time after which failed jobs are removed. It takes
+	// precedence over MaxAge and MaxPerUser so failures can be inspected.
+	KeepFailedFor time.Duration
+}
+
+type Spooler interface {
+	GetJobs() ([]v1.Job, error)
+	RemoveJob(job v1.Job) error
+}
+
+// Run removes expired jobs every interval until the context is done.
+func Run(ctx context.Context, spooler Spooler, policy Policy, interval time.Duration) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+			clean(spooler, policy)
+		}
+	}
+}
+
+// clean removes all jobs that expired according to the policy.
+func clean(spooler Spooler, policy Policy) {
+	jobs, err := spooler.GetJobs()
+	if err != nil {
+		slog.Error("failed to get jobs", "error", err)
+		return
+	}
+
+	for _, job := range Expired(jobs, policy, time.Now()) {
+		err := spooler.RemoveJob(job)
+		if errors.Is(err, jobqueue.ErrJobQueued) {
+			// canceled jobs are removed once they have left the queue
+			continue
+		} else if err != nil {
+			logging.Job(job).Error("failed to remove expired job", "error", err)
+			continue
+		}
+		logging.Job(job).Info("removed expired job")
+	}
+}
+
+// Expired returns the finished jobs that should be removed at the given time
+// according to the policy.
+func Expired(jobs []v1.Job, policy Policy, now time.Time) []v1.Job {
+	expired := []v1.Job{}
+	perUser := map[string][]v1.Job{}
+
+	for _, job := range jobs {
+		if !finished(job) {
+			continue
+		}
+
+		if job.Status == v1.JobStatusFailed && policy.KeepFailedFor > 0 {
+			if now.Sub(finishedAt(job)) > policy.KeepFailedFor {
+				expired = append(expired, job)
+			}
+			continue
+		}
+
+		if policy.MaxAge > 0 && now.Sub(finishedAt(job)) > policy.MaxAge {
+			expired = append(expired, job)
+			continue
+		}
+
+		perUser[job.User] = append(perUser[job.User], job)
+	}
+
+	if policy.MaxPerUser <= 0 {
+		return expired
+	}
+
+	for _, jobs := range perUser {
+		if len(jobs) <= policy.MaxPerUser {
+			continue
+		}
+
+		// newest first
+		sort.SliceStable(jobs, func(i, j int) bool {
+			return finishedAt(jobs[i]).After(finishedAt(jobs[j]))
+		})
+
+		expired = append(expired, jobs[policy.MaxPerUser:]...)
+	}
+
+	return expired
+}
+
+// finished returns whether the job has reached a final status.
+func finished(job v1.Job) bool {
+	switch job.Status {
+	case v1.JobStatusSucceeded, v1.JobStatusFailed, v1.JobStatusCanceled:
+		return true
+	}
+	return false
+}
+
+// finishedAt returns the time the job finished or, if unknown, when it was submitted.
+func finishedAt(job v1.Job) time.Time {
+	if job.FinishedAt != nil {
+		return *job.FinishedAt
+	}
+	return job.SubmittedAt
+}
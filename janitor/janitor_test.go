@@ -0,0 +1,172 @@
+package janitor_test
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/filestore"
+	"github.com/st3v/plotq/janitor"
+	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/spooler"
+	"github.com/st3v/plotq/testutil"
+)
+
+func job(user string, status v1.JobStatus, age time.Duration, now time.Time) v1.Job {
+	job := testutil.RandJob()
+	job.User = user
+	job.Status = status
+	job.SubmittedAt = now.Add(-age - time.Minute)
+	finished := now.Add(-age)
+	job.FinishedAt = &finished
+	return job
+}
+
+func ids(jobs []v1.Job) []string {
+	res := []string{}
+	for _, job := range jobs {
+		res = append(res, job.ID)
+	}
+	return res
+}
+
+func TestExpiredMaxAge(t *testing.T) {
+	now := time.Now()
+	policy := janitor.Policy{MaxAge: 24 * time.Hour}
+
+	old := job("foo", v1.JobStatusSucceeded, 48*time.Hour, now)
+	canceled := job("foo", v1.JobStatusCanceled, 48*time.Hour, now)
+	recent := job("foo", v1.JobStatusSucceeded, time.Hour, now)
+	pending := job("foo", v1.JobStatusPending, 48*time.Hour, now)
+	processing := job("foo", v1.JobStatusProcessing, 48*time.Hour, now)
+
+	expired := janitor.Expired([]v1.Job{old, canceled, recent, pending, processing}, policy, now)
+	require.ElementsMatch(t, []string{old.ID, canceled.ID}, ids(expired))
+}
+
+func TestExpiredMaxPerUser(t *testing.T) {
+	now := time.Now()
+	policy := janitor.Policy{MaxPerUser: 2}
+
+	jobs := []v1.Job{
+		job("foo", v1.JobStatusSucceeded, 3*time.Hour, now),
+		job("foo", v1.JobStatusSucceeded, time.Hour, now),
+		job("foo", v1.JobStatusSucceeded, 4*time.Hour, now),
+		job("foo", v1.JobStatusSucceeded, 2*time.Hour, now),
+		job("bar", v1.JobStatusSucceeded, 5*time.Hour, now),
+	}
+
+	expired := janitor.Expired(jobs, policy, now)
+	require.ElementsMatch(t, []string{jobs[0].ID, jobs[2].ID}, ids(expired))
+}
+
+func TestExpiredKeepFailedFor(t *testing.T) {
+	now := time.Now()
+	policy := janitor.Policy{MaxAge: time.Hour, MaxPerUser: 1, KeepFailedFor: 24 * time.Hour}
+
+	failed := job("foo", v1.JobStatusFailed, 2*time.Hour, now)
+	oldFailed := job("foo", v1.JobStatusFailed, 48*time.Hour, now)
+	succeeded := job("foo", v1.JobStatusSucceeded, 30*time.Minute, now)
+
+	expired := janitor.Expired([]v1.Job{failed, oldFailed, succeeded}, policy, now)
+	require.ElementsMatch(t, []string{oldFailed.ID}, ids(expired))
+}
+
+func TestExpiredDisabled(t *testing.T) {
+	now := time.Now()
+
+	jobs := []v1.Job{
+		job("foo", v1.JobStatusSucceeded, 48*time.Hour, now),
+		job("foo", v1.JobStatusFailed, 48*time.Hour, now),
+	}
+
+	expired := janitor.Expired(jobs, janitor.Policy{}, now)
+	require.Empty(t, expired)
+}
+
+func TestRun(t *testing.T) {
+	queue, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer queue.Close()
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	blobs := filestore.NewContentStore(store)
+
+	now := time.Now()
+	policy := janitor.Policy{MaxAge: time.Hour}
+
+	// finished jobs that expired, one of them submitted before the content store
+	expired := job("alice", v1.JobStatusSucceeded, 2*time.Hour, now)
+	expired.SVGHash, _, err = blobs.Put(strings.NewReader("<svg>expired</svg>"))
+	require.NoError(t, err)
+	expired.HPGL, _, err = blobs.Put(strings.NewReader("IN;PU;"))
+	require.NoError(t, err)
+
+	legacy := job("alice", v1.JobStatusFailed, 2*time.Hour, now)
+	legacy.SVG = "legacy.svg"
+	_, err = store.Put(legacy.SVG, strings.NewReader("<svg>legacy</svg>"))
+	require.NoError(t, err)
+
+	// a job that has not expired yet
+	recent := job("alice", v1.JobStatusSucceeded, time.Minute, now)
+	recent.SVGHash, _, err = blobs.Put(strings.NewReader("<svg>recent</svg>"))
+	require.NoError(t, err)
+
+	for _, j := range []v1.Job{expired, legacy, recent} {
+		require.NoError(t, queue.Enqueue(&j))
+		_, err := queue.Dequeue()
+		require.NoError(t, err)
+	}
+
+	// a canceled job that expired but is still queued
+	canceled := job("bob", v1.JobStatusCanceled, 2*time.Hour, now)
+	canceled.SVGHash, _, err = blobs.Put(strings.NewReader("<svg>canceled</svg>"))
+	require.NoError(t, err)
+	require.NoError(t, queue.Enqueue(&canceled))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- janitor.Run(ctx, spooler.NewSpooler(queue, store, nil), policy, time.Millisecond)
+	}()
+
+	require.Eventually(t, func() bool {
+		job, err := queue.Get(legacy.ID)
+		require.NoError(t, err)
+		return job == nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// expired jobs and their files are gone
+	for _, id := range []string{expired.ID, legacy.ID} {
+		job, err := queue.Get(id)
+		require.NoError(t, err)
+		require.Nil(t, job)
+	}
+
+	for _, name := range []string{expired.SVGHash, expired.HPGL, legacy.SVG} {
+		_, err := store.Stat(name)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	}
+
+	// all other jobs and their files are kept
+	for _, j := range []v1.Job{recent, canceled} {
+		job, err := queue.Get(j.ID)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		_, err = store.Stat(j.SVGHash)
+		require.NoError(t, err)
+	}
+
+	// the canceled job is still queued
+	require.ErrorIs(t, queue.Delete(canceled.ID), jobqueue.ErrJobQueued)
+}
@@ -0,0 +1,69 @@
+package jobqueue
+
+import (
+	"errors"
+	"fmt"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+// ErrNotEmpty is returned when importing jobs into a queue that already holds jobs.
+var ErrNotEmpty = errors.New("queue not empty")
+
+// Import copies the jobs of the local queue in dataDir, including queues written
+// by goque, to the given empty queue. Queued jobs are enqueued in order, all
+// other jobs are added to the job history. It returns the number of jobs copied.
+func Import(dst Queue, dataDir string) (int, error) {
+	existing, err := dst.GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(existing) > 0 {
+		return 0, ErrNotEmpty
+	}
+
+	src, err := OpenLocal(dataDir)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+
+	n := 0
+
+	iter := src.db.NewIterator(itemRange, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		job := &v1.Job{}
+		if err := decodeJob(iter.Value(), job); err != nil {
+			return n, fmt.Errorf("failed to decode job: %w", err)
+		}
+
+		if err := dst.Enqueue(job); err != nil {
+			return n, fmt.Errorf("failed to import job %s: %w", job.ID, err)
+		}
+		n++
+	}
+
+	if err := iter.Error(); err != nil {
+		return n, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	history, err := src.getHistory()
+	if err != nil {
+		return n, err
+	}
+
+	for i := range history {
+		if err := dst.Update(&history[i]); err != nil {
+			return n, fmt.Errorf("failed to import job %s: %w", history[i].ID, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,549 @@
+package jobqueue
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+// indexVersion is stored once the index has been built from existing jobs.
+// Indexes of other versions are rebuilt.
+const indexVersion = "3"
+
+// ErrInvalidCursor is returned for cursors that do not belong to the query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Key prefixes of the index within the queue database. Entries hold the
+// indexed fields of a job by ID. Index keys are made up of the field, its
+// value, the submission time and the job ID, so jobs with the same value are
+// ordered by submission time. Separators within values are escaped and values
+// are terminated so that no value's keys are prefixed by those of another.
+const (
+	prefixEntry   = "e\x00"
+	prefixIndex   = "i\x00"
+	keyVersion    = "version"
+	keyClean      = "clean" // set while the queue is closed with the index in sync
+	fieldAll      = "submittedAt"
+	fieldStatus   = "status"
+	fieldUser     = "user"
+	fieldPlotter  = "plotter"
+	fieldHPGL     = "hpgl"
+	separator     = "\x00"
+	escaped       = "\x00\xff" // separator within a value
+	terminator    = "\x00\x01" // end of a value, sorts before escaped separators
+	timestampSize = 8
+)
+
+// entry is the indexed part of a job.
+type entry struct {
+	Status      v1.JobStatus `json:"status"`
+	User        string       `json:"user"`
+	Plotter     string       `json:"plotter"`
+	Filename    string       `json:"filename,omitempty"`
+	Conversion  string       `json:"conversion,omitempty"`
+	SubmittedAt int64        `json:"submittedAt"`
+}
+
+func newEntry(job *v1.Job) entry {
+	return entry{
+		Status:      job.Status,
+		User:        job.User,
+		Plotter:     job.Plotter,
+		Filename:    job.Filename,
+		Conversion:  jobConversion(job),
+		SubmittedAt: job.SubmittedAt.UnixNano(),
+	}
+}
+
+// keys returns the index keys of the job with the given ID.
+func (e entry) keys(id string) [][]byte {
+	keys := [][]byte{
+		indexKey(fieldAll, "", e.SubmittedAt, id),
+		indexKey(fieldStatus, string(e.Status), e.SubmittedAt, id),
+		indexKey(fieldUser, e.User, e.SubmittedAt, id),
+		indexKey(fieldPlotter, e.Plotter, e.SubmittedAt, id),
+	}
+
+	// only converted jobs are indexed by their conversion
+	if e.Conversion != "" {
+		keys = append(keys, indexKey(fieldHPGL, e.Conversion, e.SubmittedAt, id))
+	}
+	return keys
+}
+
+// matches returns whether the job with the given ID and entry matches the query.
+func (e entry) matches(id string, q v1.JobQuery) bool {
+	switch {
+	case q.Status != "" && e.Status != q.Status,
+		q.User != "" && e.User != q.User,
+		q.Plotter != "" && e.Plotter != q.Plotter,
+		!q.SubmittedAfter.IsZero() && e.SubmittedAt < q.SubmittedAfter.UnixNano(),
+		!q.SubmittedBefore.IsZero() && e.SubmittedAt >= q.SubmittedBefore.UnixNano():
+		return false
+	}
+
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		return strings.Contains(strings.ToLower(id), search) || strings.Contains(strings.ToLower(e.Filename), search)
+	}
+
+	return true
+}
+
+// value returns the value of the given field.
+func (e entry) value(field string) string {
+	switch field {
+	case fieldStatus:
+		return string(e.Status)
+	case fieldUser:
+		return e.User
+	case fieldPlotter:
+		return e.Plotter
+	}
+	return ""
+}
+
+// valuePrefix returns the prefix of the index keys of the field with the given value.
+func valuePrefix(field, value string) []byte {
+	return []byte(prefixIndex + field + separator + strings.ReplaceAll(value, separator, escaped) + terminator)
+}
+
+func indexKey(field, value string, submittedAt int64, id string) []byte {
+	key := valuePrefix(field, value)
+	key = binary.BigEndian.AppendUint64(key, uint64(submittedAt))
+	return append(key, id...)
+}
+
+// index maintains secondary indexes of all jobs, queued or not. Updates are
+// added to batches so they are written along with the jobs.
+type index struct {
+	db *leveldb.DB
+}
+
+// built returns whether the current version of the index has been built from
+// existing jobs.
+func (x *index) built() (bool, error) {
+	version, err := x.db.Get([]byte(keyVersion), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return false, nil
+	}
+	return string(version) == indexVersion, err
+}
+
+// build removes any previous index, indexes the given jobs and marks the index
+// as built.
+func (x *index) build(jobs []v1.Job) error {
+	for _, prefix := range []string{prefixEntry, prefixIndex} {
+		batch := new(leveldb.Batch)
+
+		iter := x.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for iter.Next() {
+			batch.Delete(iter.Key())
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+
+		if err := x.db.Write(batch, nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range jobs {
+		batch := new(leveldb.Batch)
+		if err := x.put(batch, &jobs[i]); err != nil {
+			return err
+		}
+
+		if err := x.db.Write(batch, nil); err != nil {
+			return err
+		}
+	}
+	return x.db.Put([]byte(keyVersion), []byte(indexVersion), nil)
+}
+
+// put adds indexing the job to the batch, replacing the keys of its previous
+// version.
+func (x *index) put(batch *leveldb.Batch, job *v1.Job) error {
+	if err := x.remove(batch, job.ID); err != nil {
+		return err
+	}
+
+	e := newEntry(job)
+	value, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode index entry: %w", err)
+	}
+
+	batch.Put([]byte(prefixEntry+job.ID), value)
+	for _, key := range e.keys(job.ID) {
+		batch.Put(key, nil)
+	}
+	return nil
+}
+
+// remove adds the deletion of the job's entry and index keys to the batch.
+func (x *index) remove(batch *leveldb.Batch, id string) error {
+	e, err := x.entry(id)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	batch.Delete([]byte(prefixEntry + id))
+	for _, key := range e.keys(id) {
+		batch.Delete(key)
+	}
+	return nil
+}
+
+func (x *index) entry(id string) (entry, error) {
+	e := entry{}
+
+	value, err := x.db.Get([]byte(prefixEntry+id), nil)
+	if err != nil {
+		return e, err
+	}
+
+	if err := json.Unmarshal(value, &e); err != nil {
+		return e, fmt.Errorf("failed to decode index entry: %w", err)
+	}
+	return e, nil
+}
+
+// converted returns the IDs of the jobs with the given conversion, most
+// recently submitted first.
+func (x *index) converted(conversion string) ([]string, error) {
+	iter := x.db.NewIterator(util.BytesPrefix(valuePrefix(fieldHPGL, conversion)), nil)
+	defer iter.Release()
+
+	ids := []string{}
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		ids = append(ids, idOf(iter.Key(), fieldHPGL))
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	return ids, nil
+}
+
+// query returns the IDs of the jobs matching the query in order along with the
+// cursor of the following page, if any. Cursors are keys of the index of the
+// sort field.
+//
+// Jobs are read from the index of the most selective field the query filters
+// by, limited to the submission time range of the query. If that index is not
+// in the order of the sort field, e.g. for the jobs of a user sorted by
+// plotter, all jobs read are matched and sorted before the page is taken.
+// Queries that filter by neither field nor time, e.g. searches by name, read
+// the whole index of the sort field, as do searches that match few jobs.
+func (x *index) query(q v1.JobQuery) ([]string, string, error) {
+	field, desc := q.Sort.Field()
+	switch field {
+	case fieldAll, fieldStatus, fieldUser, fieldPlotter:
+	default:
+		return nil, "", fmt.Errorf("unknown sort field %q", field)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = v1.DefaultListLimit
+	}
+
+	cursor, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	s, err := x.plan(field, q)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ids []string
+	var next []byte
+	if s.sorted {
+		ids, next, err = x.readPage(s, q, cursor, desc, limit)
+	} else {
+		ids, next, err = x.sortPage(s, q, cursor, desc, limit)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return ids, base64.RawURLEncoding.EncodeToString(next), nil
+}
+
+// scan describes how the jobs matching a query are read from the index.
+type scan struct {
+	field    string      // field of the index that is read
+	rng      *util.Range // range of index keys that is read
+	prefix   []byte      // prefix of all keys in range
+	sort     string      // field the jobs are sorted by
+	sortKeys []byte      // prefix of all cursors of the query
+	sorted   bool        // whether the keys in range are in the order of the sort field
+	filtered bool        // whether the jobs in range still have to be matched
+}
+
+// plan returns how to read the jobs matching the query sorted by the given
+// field from the index.
+func (x *index) plan(field string, q v1.JobQuery) (scan, error) {
+	equal := map[string]string{}
+	for f, v := range map[string]string{
+		fieldStatus:  string(q.Status),
+		fieldUser:    q.User,
+		fieldPlotter: q.Plotter,
+	} {
+		if v != "" {
+			equal[f] = v
+		}
+	}
+	timed := !q.SubmittedAfter.IsZero() || !q.SubmittedBefore.IsZero()
+
+	s := scan{sort: field, sortKeys: valuePrefix(field, equal[field])}
+	if field != fieldAll && equal[field] == "" {
+		s.sortKeys = []byte(prefixIndex + field + separator)
+	}
+
+	// without filters all values of the sort field are read in order
+	if len(equal) == 0 && field != fieldAll && !timed {
+		s.field, s.prefix, s.rng = field, s.sortKeys, util.BytesPrefix(s.sortKeys)
+		s.sorted, s.filtered = true, q.Search != ""
+		return s, nil
+	}
+
+	s.field = fieldAll
+	if len(equal) > 0 {
+		selective, err := x.mostSelective(equal, q)
+		if err != nil {
+			return s, err
+		}
+		s.field = selective
+	}
+
+	s.prefix = valuePrefix(s.field, equal[s.field])
+	s.rng = timeRange(s.prefix, q)
+
+	// keys with the same value of the sort field are ordered by submission time
+	s.sorted = field == fieldAll || equal[field] != ""
+	s.filtered = q.Search != "" || len(equal) > 1
+	return s, nil
+}
+
+// mostSelective returns the field of the given values that the fewest jobs
+// submitted within the time range of the query match.
+func (x *index) mostSelective(equal map[string]string, q v1.JobQuery) (string, error) {
+	selective, min := "", -1
+	for _, field := range []string{fieldPlotter, fieldUser, fieldStatus} {
+		value, ok := equal[field]
+		if !ok {
+			continue
+		}
+
+		n, err := x.count(timeRange(valuePrefix(field, value), q), min)
+		if err != nil {
+			return "", err
+		}
+
+		if min < 0 || n < min {
+			selective, min = field, n
+		}
+	}
+	return selective, nil
+}
+
+// count returns the number of keys in the given range, counting no further
+// than max unless it is negative.
+func (x *index) count(rng *util.Range, max int) (int, error) {
+	iter := x.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	n := 0
+	for iter.Next() && n != max {
+		n++
+	}
+
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("failed to read index: %w", err)
+	}
+	return n, nil
+}
+
+// readPage reads a page of jobs from a range of keys that are in the order of
+// the sort field, starting after the cursor.
+func (x *index) readPage(s scan, q v1.JobQuery, cursor []byte, desc bool, limit int) ([]string, []byte, error) {
+	// keys of the sort field and the field read only differ in their prefix
+	if len(cursor) > 0 {
+		if !bytes.HasPrefix(cursor, s.sortKeys) {
+			return nil, nil, ErrInvalidCursor
+		}
+
+		cursor = append(append([]byte{}, s.prefix...), cursor[len(s.sortKeys):]...)
+		if !inRange(s.rng, cursor) {
+			return nil, nil, ErrInvalidCursor
+		}
+	}
+
+	iter := x.db.NewIterator(s.rng, nil)
+	defer iter.Release()
+
+	ids := []string{}
+	var next, last []byte
+	for ok := seek(iter, cursor, desc); ok; ok = step(iter, desc) {
+		id := idOf(iter.Key(), s.field)
+
+		if s.filtered {
+			matches, err := x.matches(id, q)
+			if err != nil {
+				return nil, nil, err
+			} else if !matches {
+				continue
+			}
+		}
+
+		// a further match means there is another page
+		if len(ids) == limit {
+			next = append(append([]byte{}, s.sortKeys...), last[len(s.prefix):]...)
+			break
+		}
+
+		ids = append(ids, id)
+		last = append(last[:0], iter.Key()...)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	return ids, next, nil
+}
+
+// sortPage reads all matching jobs in range, sorts them by the sort field and
+// returns the page following the cursor.
+func (x *index) sortPage(s scan, q v1.JobQuery, cursor []byte, desc bool, limit int) ([]string, []byte, error) {
+	if len(cursor) > 0 && !bytes.HasPrefix(cursor, s.sortKeys) {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	iter := x.db.NewIterator(s.rng, nil)
+	defer iter.Release()
+
+	keys := [][]byte{}
+	for iter.Next() {
+		id := idOf(iter.Key(), s.field)
+
+		e, err := x.entry(id)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if !e.matches(id, q) {
+			continue
+		}
+
+		key := indexKey(s.sort, e.value(s.sort), e.SubmittedAt, id)
+		if len(cursor) > 0 && (desc && bytes.Compare(key, cursor) >= 0 || !desc && bytes.Compare(key, cursor) <= 0) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return (bytes.Compare(keys[i], keys[j]) < 0) != desc
+	})
+
+	var next []byte
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = idOf(key, s.sort)
+	}
+	return ids, next, nil
+}
+
+// matches returns whether the indexed job with the given ID matches the query.
+func (x *index) matches(id string, q v1.JobQuery) (bool, error) {
+	e, err := x.entry(id)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return e.matches(id, q), nil
+}
+
+// timeRange returns the range of the index keys with the given value prefix
+// that were submitted within the time range of the query.
+func timeRange(prefix []byte, q v1.JobQuery) *util.Range {
+	rng := util.BytesPrefix(prefix)
+	if !q.SubmittedAfter.IsZero() {
+		rng.Start = binary.BigEndian.AppendUint64(append([]byte{}, prefix...), uint64(q.SubmittedAfter.UnixNano()))
+	}
+	if !q.SubmittedBefore.IsZero() {
+		rng.Limit = binary.BigEndian.AppendUint64(append([]byte{}, prefix...), uint64(q.SubmittedBefore.UnixNano()))
+	}
+	return rng
+}
+
+// idOf returns the job ID of an index key of the given field.
+func idOf(key []byte, field string) string {
+	rest := key[len(prefixIndex)+len(field)+len(separator):]
+	value := bytes.Index(rest, []byte(terminator))
+	return string(rest[value+len(terminator)+timestampSize:])
+}
+
+func inRange(rng *util.Range, key []byte) bool {
+	return bytes.Compare(key, rng.Start) >= 0 && (rng.Limit == nil || bytes.Compare(key, rng.Limit) < 0)
+}
+
+// seek positions the iterator at the first key after the cursor in the given order.
+func seek(iter iterator.Iterator, cursor []byte, desc bool) bool {
+	switch {
+	case len(cursor) == 0 && desc:
+		return iter.Last()
+	case len(cursor) == 0:
+		return iter.First()
+	case desc:
+		if !iter.Seek(cursor) {
+			return iter.Last()
+		}
+		return iter.Prev()
+	}
+
+	ok := iter.Seek(cursor)
+	if ok && bytes.Equal(iter.Key(), cursor) {
+		return iter.Next()
+	}
+	return ok
+}
+
+func step(iter iterator.Iterator, desc bool) bool {
+	if desc {
+		return iter.Prev()
+	}
+	return iter.Next()
+}
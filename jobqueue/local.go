@@ -1,17 +1,49 @@
 package jobqueue
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 
 	v1 "github.com/st3v/plotq/api/v1"
 )
 
+// historyDir is the directory within the queue's data directory that holds
+// the records of jobs that have left the queue.
+const historyDir = "history"
+
+// legacyIndexDir is the directory of the index database of earlier versions.
+// The index is now part of the queue database.
+const legacyIndexDir = "index"
+
+// Queued jobs are stored under their position in the queue, encoded as 8 byte
+// big-endian integers like goque does, so queues written by earlier versions
+// can still be opened. All other keys of the queue database start with a letter
+// and sort after the items.
+const (
+	itemKeySize  = 8
+	prefixQueued = "q\x00" // ID of a queued job to the key of its item
+)
+
+// itemRange is the range of item keys in the queue database.
+var itemRange = &util.Range{Limit: []byte{1}}
+
 type localQueue struct {
-	q *goque.Queue // underlying queue is thread-safe
+	mu      sync.RWMutex // serializes writes and guards head and tail
+	db      *leveldb.DB  // queued jobs and the indexes of all jobs
+	head    uint64       // position of the last dequeued item
+	tail    uint64       // position of the last enqueued item
+	index   *index       // indexes of all jobs for listing
+	history *leveldb.DB  // jobs that have been dequeued, keyed by ID
+	synced  bool         // whether the index is in sync with the jobs
 }
 
 // localQueue implements the Queue interface.
@@ -19,141 +51,556 @@ var _ Queue = &localQueue{}
 
 // OpenLocal opens a local queue.
 func OpenLocal(dataDir string) (*localQueue, error) {
-	q, err := goque.OpenQueue(filepath.Join(dataDir))
+	db, err := leveldb.OpenFile(dataDir, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open queue: %w", err)
 	}
 
-	return &localQueue{
-		q: q,
-	}, nil
+	history, err := leveldb.OpenFile(filepath.Join(dataDir, historyDir), nil)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open job history: %w", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dataDir, legacyIndexDir)); err != nil {
+		history.Close()
+		db.Close()
+		return nil, fmt.Errorf("failed to remove previous job index: %w", err)
+	}
+
+	q := &localQueue{
+		db:      db,
+		index:   &index{db: db},
+		history: history,
+	}
+
+	if err := q.init(); err != nil {
+		history.Close()
+		db.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// init reads the positions of the first and last item, rebuilds the index of
+// queued jobs by ID and builds the index of all jobs if it has not been built
+// yet, e.g. for queues created by earlier versions. The index is also rebuilt
+// if the queue has not been closed, e.g. after a crash, since jobs and their
+// index entries might not have been written together.
+func (q *localQueue) init() error {
+	batch := new(leveldb.Batch)
+
+	queued := q.db.NewIterator(util.BytesPrefix([]byte(prefixQueued)), nil)
+	for queued.Next() {
+		batch.Delete(queued.Key())
+	}
+	queued.Release()
+	if err := queued.Error(); err != nil {
+		return fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	items := q.db.NewIterator(itemRange, nil)
+	defer items.Release()
+
+	for items.Next() {
+		job := &v1.Job{}
+		if err := decodeJob(items.Value(), job); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+
+		batch.Put([]byte(prefixQueued+job.ID), append([]byte{}, items.Key()...))
+	}
+	if err := items.Error(); err != nil {
+		return fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	if items.First() {
+		q.head = itemID(items.Key()) - 1
+	}
+	if items.Last() {
+		q.tail = itemID(items.Key())
+	}
+
+	if err := q.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to index queue: %w", err)
+	}
+
+	built, err := q.index.built()
+	if err != nil {
+		return fmt.Errorf("failed to read job index: %w", err)
+	}
+
+	clean, err := q.db.Has([]byte(keyClean), nil)
+	if err != nil {
+		return fmt.Errorf("failed to read job index: %w", err)
+	}
+
+	if !built || !clean {
+		jobs, err := q.GetAll()
+		if err != nil {
+			return err
+		}
+
+		if err := q.index.build(jobs); err != nil {
+			return fmt.Errorf("failed to build job index: %w", err)
+		}
+	}
+
+	// the marker is set again when the queue is closed
+	if err := q.db.Delete([]byte(keyClean), nil); err != nil {
+		return fmt.Errorf("failed to write job index: %w", err)
+	}
+
+	q.synced = true
+	return nil
 }
 
 // Close closes the queue.
 func (q *localQueue) Close() error {
-	return q.q.Close()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	herr := q.history.Close()
+
+	// the index is not rebuilt on the next open if it is in sync
+	var merr error
+	if q.synced && herr == nil {
+		merr = q.db.Put([]byte(keyClean), nil, nil)
+	}
+
+	if err := q.db.Close(); err != nil {
+		return err
+	}
+	if herr != nil {
+		return herr
+	}
+	return merr
+}
+
+// Check returns an error if the queue has been closed.
+func (q *localQueue) Check() error {
+	if _, err := q.db.GetProperty("leveldb.stats"); err != nil {
+		return fmt.Errorf("queue: %w", err)
+	}
+
+	if _, err := q.history.GetProperty("leveldb.stats"); err != nil {
+		return fmt.Errorf("job history: %w", err)
+	}
+
+	return nil
 }
 
 // Enqueue adds the given job to the queue.
 func (q *localQueue) Enqueue(job *v1.Job) error {
-	_, err := q.q.EnqueueObjectAsJSON(job)
-	return translateGoqueError(err)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := itemKey(q.tail + 1)
+	if err := q.putItem(key, job); err != nil {
+		return err
+	}
+
+	q.tail++
+	return nil
 }
 
-// GetAll returns all jobs in the queue.
+// GetAll returns all jobs in the queue followed by the jobs that have already
+// left the queue, ordered by submission time.
 func (q *localQueue) GetAll() ([]v1.Job, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
 	jobs := []v1.Job{}
 
-	err := q.walkAllItems(func(item *goque.Item) error {
-		job, err := jobFromItem(item)
-		if err != nil {
-			return translateGoqueError(err)
+	iter := q.db.NewIterator(itemRange, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		job := v1.Job{}
+		if err := decodeJob(iter.Value(), &job); err != nil {
+			return nil, fmt.Errorf("failed to decode job: %w", err)
 		}
+		jobs = append(jobs, job)
+	}
 
-		jobs = append(jobs, *job)
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
 
-		return nil
-	})
+	history, err := q.getHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(jobs, history...), nil
+}
+
+// List returns a page of the jobs matching the given query.
+func (q *localQueue) List(query v1.JobQuery) (*v1.JobList, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	ids, next, err := q.index.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &v1.JobList{Jobs: make([]v1.Job, 0, len(ids)), Next: next}
+	for _, id := range ids {
+		job, err := q.get(id)
+		if err != nil {
+			return nil, err
+		}
 
-	return jobs, translateGoqueError(err)
+		if job != nil {
+			list.Jobs = append(list.Jobs, *job)
+		}
+	}
+
+	return list, nil
 }
 
 // Get returns the job with the given ID.
 func (q *localQueue) Get(id string) (*v1.Job, error) {
-	jobs, err := q.GetAll()
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.get(id)
+}
+
+// Update replaces the stored job with the same ID as the given job.
+//
+// Jobs in the job history and their index entries are stored in different
+// databases and cannot be written at once. If indexing fails after the job has
+// been written, the index is rebuilt the next time the queue is opened.
+func (q *localQueue) Update(job *v1.Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, err := q.queuedKey(job.ID)
 	if err != nil {
-		return nil, translateGoqueError(err)
+		return err
 	}
 
-	for _, job := range jobs {
-		if job.ID == id {
-			return &job, nil
-		}
+	if key != nil {
+		return q.putItem(key, job)
 	}
 
-	// Job not found, return no error
-	return nil, nil
+	if err := q.putHistory(job); err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	err = q.index.put(batch, job)
+	if err == nil {
+		err = q.db.Write(batch, nil)
+	}
+	if err != nil {
+		q.synced = false
+		return fmt.Errorf("failed to index job: %w", err)
+	}
+	return nil
 }
 
-// Cancel marks the job with the given ID as canceled.
-func (q *localQueue) Cancel(id string) (*v1.Job, error) {
-	var res *v1.Job
+// Converted returns the jobs whose SVG with the given hash has been converted
+// to HPGL using the given settings, most recently submitted first.
+func (q *localQueue) Converted(svgHash string, settings v1.JobSettings) ([]v1.Job, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 
-	err := q.walkAllItems(func(item *goque.Item) error {
-		job, err := jobFromItem(item)
+	ids, err := q.index.converted(conversion(svgHash, settings))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]v1.Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := q.get(id)
 		if err != nil {
-			return translateGoqueError(err)
+			return nil, err
 		}
 
-		if job.ID != id {
-			return nil
+		if job != nil {
+			jobs = append(jobs, *job)
 		}
+	}
 
-		job.Status = v1.JobStatusCanceled
+	return jobs, nil
+}
 
-		if _, err := q.q.UpdateObjectAsJSON(item.ID, job); err != nil {
-			return fmt.Errorf("failed to update job: %w", err)
-		}
+// Cancel marks the queued job with the given ID as canceled. It returns nil if
+// there is no such job in the queue.
+func (q *localQueue) Cancel(id string) (*v1.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-		res = job
+	key, err := q.queuedKey(id)
+	if err != nil || key == nil {
+		return nil, err
+	}
 
-		return stopWalk
-	})
+	job, err := q.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job.Status = v1.JobStatusCanceled
+	job.FinishedAt = &now
 
-	return res, translateGoqueError(err)
+	if err := q.putItem(key, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
 }
 
-// Peek returns the next job from the queue without removing it.
-func (q *localQueue) Peek() (*v1.Job, error) {
-	item, err := q.q.Peek()
+// Delete removes the record of the job with the given ID from the job history.
+// Jobs that are still queued can only be canceled, deleting them returns ErrJobQueued.
+// Like for Update, the index is rebuilt on the next open if removing the job
+// from it fails.
+func (q *localQueue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, err := q.queuedKey(id)
 	if err != nil {
-		return nil, translateGoqueError(err)
+		return err
+	}
+
+	if key != nil {
+		return ErrJobQueued
+	}
+
+	if err := q.history.Delete([]byte(id), nil); err != nil {
+		return fmt.Errorf("failed to delete job: %w", err)
 	}
 
-	return jobFromItem(item)
+	batch := new(leveldb.Batch)
+	err = q.index.remove(batch, id)
+	if err == nil {
+		err = q.db.Write(batch, nil)
+	}
+	if err != nil {
+		q.synced = false
+		return fmt.Errorf("failed to remove job from index: %w", err)
+	}
+	return nil
 }
 
-// Dequeue returns the next job from the queue.
-func (q *localQueue) Dequeue() (*v1.Job, error) {
-	item, err := q.q.Dequeue()
+// Restore puts the canceled job with the given ID back into the pending state
+// at its position in the queue. It returns nil if there is no such job in the
+// queue, e.g. because it has already been removed from the queue, and
+// ErrNotCanceled if the job has not been canceled.
+func (q *localQueue) Restore(id string) (*v1.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, err := q.queuedKey(id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	job, err := q.getItem(key)
 	if err != nil {
-		return nil, translateGoqueError(err)
+		return nil, err
 	}
 
-	return jobFromItem(item)
+	if job.Status != v1.JobStatusCanceled {
+		return nil, ErrNotCanceled
+	}
+
+	job.Status = v1.JobStatusPending
+	job.FinishedAt = nil
+
+	if err := q.putItem(key, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
 }
 
-var stopWalk = errors.New("stop walk")
+// Peek returns the next job from the queue without removing it. Canceled jobs
+// are skipped.
+func (q *localQueue) Peek() (*v1.Job, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 
-func (q *localQueue) walkAllItems(callback func(item *goque.Item) error) error {
-	for i := uint64(0); i < q.q.Length(); i++ {
-		item, err := q.q.PeekByOffset(i)
+	for id := q.head + 1; id <= q.tail; id++ {
+		job, err := q.getItem(itemKey(id))
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if err := callback(item); err != nil {
-			if err == stopWalk {
-				return nil
-			}
-			return err
+		if job.Status != v1.JobStatusCanceled {
+			return job, nil
+		}
+	}
+
+	return nil, ErrQueueEmpty
+}
+
+// Dequeue returns the next job from the queue and moves it to the job history.
+// Canceled jobs ahead of it are moved to the job history without being returned.
+func (q *localQueue) Dequeue() (*v1.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.head < q.tail {
+		key := itemKey(q.head + 1)
+		job, err := q.getItem(key)
+		if err != nil {
+			return nil, err
+		}
+
+		// the job is recorded before it is removed so it cannot get lost
+		if err := q.putHistory(job); err != nil {
+			return nil, err
+		}
+
+		batch := new(leveldb.Batch)
+		batch.Delete(key)
+		batch.Delete([]byte(prefixQueued + job.ID))
+
+		if err := q.db.Write(batch, nil); err != nil {
+			return nil, fmt.Errorf("failed to dequeue job: %w", err)
+		}
+
+		q.head++
+
+		if job.Status != v1.JobStatusCanceled {
+			return job, nil
 		}
 	}
+
+	return nil, ErrQueueEmpty
+}
+
+// get returns the job with the given ID from the queue or the job history, or
+// nil if there is none.
+func (q *localQueue) get(id string) (*v1.Job, error) {
+	key, err := q.queuedKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		return q.getItem(key)
+	}
+
+	return q.getHistoryJob(id)
+}
+
+// queuedKey returns the key of the item of the queued job with the given ID or
+// nil if the job is not queued.
+func (q *localQueue) queuedKey(id string) ([]byte, error) {
+	key, err := q.db.Get([]byte(prefixQueued+id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+	return key, nil
+}
+
+func (q *localQueue) getItem(key []byte) (*v1.Job, error) {
+	value, err := q.db.Get(key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	job := &v1.Job{}
+	if err := decodeJob(value, job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return job, nil
+}
+
+// putItem stores the job as the item with the given key along with its
+// indexes.
+func (q *localQueue) putItem(key []byte, job *v1.Job) error {
+	value, err := encodeJob(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(key, value)
+	batch.Put([]byte(prefixQueued+job.ID), key)
+	if err := q.index.put(batch, job); err != nil {
+		return err
+	}
+
+	if err := q.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to store job: %w", err)
+	}
 	return nil
 }
 
-func jobFromItem(item *goque.Item) (*v1.Job, error) {
+// putHistory stores the given job in the job history.
+func (q *localQueue) putHistory(job *v1.Job) error {
+	value, err := encodeJob(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	if err := q.history.Put([]byte(job.ID), value, nil); err != nil {
+		return fmt.Errorf("failed to store job: %w", err)
+	}
+
+	return nil
+}
+
+// getHistoryJob returns the job with the given ID from the job history or nil
+// if there is none.
+func (q *localQueue) getHistoryJob(id string) (*v1.Job, error) {
+	value, err := q.history.Get([]byte(id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read job history: %w", err)
+	}
+
 	job := &v1.Job{}
-	if err := item.ToObjectFromJSON(job); err != nil {
-		return nil, err
+	if err := decodeJob(value, job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
 	}
 	return job, nil
 }
 
-// translateGoqueError translates goque errors to jobqueue errors.
-func translateGoqueError(err error) error {
-	if errors.Is(err, goque.ErrEmpty) {
-		return ErrQueueEmpty
+// getHistory returns all jobs in the job history ordered by submission time.
+func (q *localQueue) getHistory() ([]v1.Job, error) {
+	jobs := []v1.Job{}
+
+	iter := q.history.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		job := v1.Job{}
+		if err := decodeJob(iter.Value(), &job); err != nil {
+			return nil, fmt.Errorf("failed to decode job: %w", err)
+		}
+		jobs = append(jobs, job)
 	}
-	return err
+
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read job history: %w", err)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].SubmittedAt.Before(jobs[j].SubmittedAt)
+	})
+
+	return jobs, nil
+}
+
+func itemKey(id uint64) []byte {
+	key := make([]byte, itemKeySize)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func itemID(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
 }
@@ -2,11 +2,16 @@ package jobqueue_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/beeker1121/goque"
 	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 
 	v1 "github.com/st3v/plotq/api/v1"
 	"github.com/st3v/plotq/jobqueue"
@@ -20,24 +25,39 @@ func TestEnqueue(t *testing.T) {
 	local, err := jobqueue.OpenLocal(dir)
 	require.NoError(t, err)
 
-	expected := testutil.RandJob()
+	expected := testutil.RandPendingJob()
 	err = local.Enqueue(&expected)
 	require.NoError(t, err)
 
 	local.Close()
 
-	queue, err := goque.OpenQueue(dir)
+	// jobs are stored as JSON under 8 byte big-endian positions like goque does
+	db, err := leveldb.OpenFile(dir, nil)
+	require.NoError(t, err)
+
+	value, err := db.Get([]byte{0, 0, 0, 0, 0, 0, 0, 1}, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	stored := v1.Job{}
+	require.NoError(t, json.Unmarshal(value, &stored))
+	require.Equal(t, expected.ID, stored.ID)
+	require.Equal(t, expected.Settings, stored.Settings)
+
+	// the job is still queued after reopening the queue
+	local, err = jobqueue.OpenLocal(dir)
 	require.NoError(t, err)
-	defer queue.Close()
+	defer local.Close()
 
-	item, err := queue.Dequeue()
-	actual := &v1.Job{}
-	err = json.Unmarshal(item.Value, actual)
+	actual, err := local.Dequeue()
 	require.NoError(t, err)
 
 	require.True(t, actual.SubmittedAt.Equal(expected.SubmittedAt))
 	expected.SubmittedAt = actual.SubmittedAt
 	require.Equal(t, expected, *actual)
+
+	_, err = local.Peek()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
 }
 
 func TestGetAll(t *testing.T) {
@@ -50,7 +70,7 @@ func TestGetAll(t *testing.T) {
 
 	expected := make([]v1.Job, 10)
 	for i := range expected {
-		expected[i] = testutil.RandJob()
+		expected[i] = testutil.RandPendingJob()
 		err = local.Enqueue(&expected[i])
 		require.NoError(t, err)
 	}
@@ -77,7 +97,7 @@ func TestGet(t *testing.T) {
 
 	expected := make([]v1.Job, 10)
 	for i := range expected {
-		expected[i] = testutil.RandJob()
+		expected[i] = testutil.RandPendingJob()
 		err = local.Enqueue(&expected[i])
 		require.NoError(t, err)
 	}
@@ -121,6 +141,103 @@ func TestCancel(t *testing.T) {
 	require.Equal(t, v1.JobStatusCanceled, actual.Status)
 }
 
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	defer os.RemoveAll(dir)
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	expected := testutil.RandJob()
+	expected.Status = v1.JobStatusPending
+	err = local.Enqueue(&expected)
+	require.NoError(t, err)
+
+	// update the job while it is still queued
+	expected.User = testutil.RandString(5)
+	err = local.Update(&expected)
+	require.NoError(t, err)
+
+	actual, err := local.Get(expected.ID)
+	require.NoError(t, err)
+	require.Equal(t, expected.User, actual.User)
+
+	// dequeued jobs remain available
+	_, err = local.Dequeue()
+	require.NoError(t, err)
+
+	expected.Status = v1.JobStatusFailed
+	expected.PlotterErrors = []string{"position overflow"}
+	err = local.Update(&expected)
+	require.NoError(t, err)
+
+	actual, err = local.Get(expected.ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusFailed, actual.Status)
+	require.Equal(t, expected.PlotterErrors, actual.PlotterErrors)
+
+	all, err := local.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+
+	_, err = local.Peek()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+}
+
+func TestPrivateFields(t *testing.T) {
+	testutil.ForEachQueue(t, func(t *testing.T, q jobqueue.Queue) {
+		// fields hidden from the API are stored along with the job
+		job := testutil.RandPendingJob()
+		job.Notify = "alice@example.com"
+		job.TraceContext = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+		require.NoError(t, q.Enqueue(&job))
+
+		actual, err := q.Get(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, job.Notify, actual.Notify)
+		require.Equal(t, job.TraceContext, actual.TraceContext)
+
+		_, err = q.Dequeue()
+		require.NoError(t, err)
+
+		job.Status = v1.JobStatusSucceeded
+		require.NoError(t, q.Update(&job))
+
+		actual, err = q.Get(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, job.Notify, actual.Notify)
+		require.Equal(t, job.TraceContext, actual.TraceContext)
+	})
+}
+
+func TestDelete(t *testing.T) {
+	dir := t.TempDir()
+	defer os.RemoveAll(dir)
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	expected := testutil.RandPendingJob()
+	err = local.Enqueue(&expected)
+	require.NoError(t, err)
+
+	// queued jobs cannot be deleted
+	err = local.Delete(expected.ID)
+	require.ErrorIs(t, err, jobqueue.ErrJobQueued)
+
+	_, err = local.Dequeue()
+	require.NoError(t, err)
+
+	err = local.Delete(expected.ID)
+	require.NoError(t, err)
+
+	actual, err := local.Get(expected.ID)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+}
+
 func TestPeek(t *testing.T) {
 	dir := t.TempDir()
 	defer os.RemoveAll(dir)
@@ -131,7 +248,7 @@ func TestPeek(t *testing.T) {
 
 	expected := make([]v1.Job, 10)
 	for i := range expected {
-		expected[i] = testutil.RandJob()
+		expected[i] = testutil.RandPendingJob()
 		err = local.Enqueue(&expected[i])
 		require.NoError(t, err)
 	}
@@ -155,7 +272,7 @@ func TestDequeue(t *testing.T) {
 
 	expected := make([]v1.Job, 10)
 	for i := range expected {
-		expected[i] = testutil.RandJob()
+		expected[i] = testutil.RandPendingJob()
 		err = local.Enqueue(&expected[i])
 		require.NoError(t, err)
 	}
@@ -172,3 +289,595 @@ func TestDequeue(t *testing.T) {
 	require.Error(t, jobqueue.ErrQueueEmpty)
 	require.Nil(t, actual)
 }
+
+func TestCheck(t *testing.T) {
+	local, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, local.Check())
+
+	local.Close()
+	require.Error(t, local.Check())
+}
+
+func ids(jobs []v1.Job) []string {
+	res := []string{}
+	for _, job := range jobs {
+		res = append(res, job.ID)
+	}
+	return res
+}
+
+func TestList(t *testing.T) {
+	testutil.ForEachQueue(t, testList)
+}
+
+func testList(t *testing.T, q jobqueue.Queue) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jobs := []v1.Job{
+		{ID: "a", User: "alice", Plotter: "p1", Filename: "Drawing.svg", Status: v1.JobStatusPending},
+		{ID: "b", User: "bob", Plotter: "p2", Filename: "cat.svg", Status: v1.JobStatusPending},
+		{ID: "c", User: "alice", Plotter: "p2", Filename: "drawing-2.svg", Status: v1.JobStatusPending},
+		{ID: "d", User: "bob", Plotter: "p1", Status: v1.JobStatusPending},
+		{ID: "e", User: "alice", Plotter: "p1", Status: v1.JobStatusPending},
+	}
+	for i := range jobs {
+		jobs[i].SubmittedAt = start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, q.Enqueue(&jobs[i]))
+	}
+
+	// move some jobs to the history
+	for i := 0; i < 2; i++ {
+		job, err := q.Dequeue()
+		require.NoError(t, err)
+		job.Status = v1.JobStatusSucceeded
+		require.NoError(t, q.Update(job))
+	}
+
+	for _, tc := range []struct {
+		query    v1.JobQuery
+		expected []string
+	}{
+		{v1.JobQuery{}, []string{"a", "b", "c", "d", "e"}},
+		{v1.JobQuery{Sort: v1.JobSortSubmittedAtDesc}, []string{"e", "d", "c", "b", "a"}},
+		{v1.JobQuery{Status: v1.JobStatusPending}, []string{"c", "d", "e"}},
+		{v1.JobQuery{User: "alice"}, []string{"a", "c", "e"}},
+		{v1.JobQuery{User: "alice", Plotter: "p1"}, []string{"a", "e"}},
+		{v1.JobQuery{User: "bob", Status: v1.JobStatusSucceeded}, []string{"b"}},
+		{v1.JobQuery{SubmittedAfter: start.Add(time.Minute), SubmittedBefore: start.Add(3 * time.Minute)}, []string{"b", "c"}},
+		{v1.JobQuery{Plotter: "p1", SubmittedAfter: start.Add(time.Minute)}, []string{"d", "e"}},
+		{v1.JobQuery{Search: "DRAWING"}, []string{"a", "c"}},
+		{v1.JobQuery{Search: "d"}, []string{"a", "c", "d"}},
+		{v1.JobQuery{Sort: v1.JobSortUser}, []string{"a", "c", "e", "b", "d"}},
+		{v1.JobQuery{Sort: v1.JobSortPlotterDesc}, []string{"c", "b", "e", "d", "a"}},
+		{v1.JobQuery{Sort: v1.JobSortStatus, User: "alice"}, []string{"c", "e", "a"}},
+		{v1.JobQuery{Sort: v1.JobSortStatus, SubmittedAfter: start.Add(time.Minute)}, []string{"c", "d", "e", "b"}},
+	} {
+		list, err := q.List(tc.query)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, ids(list.Jobs), "%+v", tc.query)
+		require.Empty(t, list.Next)
+	}
+
+	list, err := q.List(v1.JobQuery{Status: v1.JobStatusSucceeded})
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusSucceeded, list.Jobs[0].Status)
+	require.Equal(t, "Drawing.svg", list.Jobs[0].Filename)
+}
+
+func TestListPages(t *testing.T) {
+	testutil.ForEachQueue(t, testListPages)
+}
+
+func testListPages(t *testing.T, q jobqueue.Queue) {
+	expected := []string{}
+	for i := 0; i < 10; i++ {
+		job := testutil.RandPendingJob()
+		job.User = "alice"
+		job.Plotter = "p1"
+		job.SubmittedAt = job.SubmittedAt.Add(time.Duration(i) * time.Second)
+		require.NoError(t, q.Enqueue(&job))
+		expected = append(expected, job.ID)
+	}
+
+	for _, sort := range []v1.JobSort{v1.JobSortSubmittedAt, v1.JobSortUser} {
+		query := v1.JobQuery{Sort: sort, Limit: 3}
+		actual := []string{}
+		for pages := 1; ; pages++ {
+			list, err := q.List(query)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(list.Jobs), 3)
+			actual = append(actual, ids(list.Jobs)...)
+
+			if list.Next == "" {
+				require.Equal(t, 4, pages)
+				break
+			}
+			query.Cursor = list.Next
+		}
+		require.Equal(t, expected, actual)
+	}
+
+	// an older job only some of the queries below match
+	other := testutil.RandPendingJob()
+	other.User = "alice"
+	other.Plotter = "p2"
+	other.SubmittedAt = other.SubmittedAt.Add(-time.Hour)
+	require.NoError(t, q.Enqueue(&other))
+
+	for _, query := range []v1.JobQuery{
+		// read from the plotter index in the order of the user index
+		{Sort: v1.JobSortUser, User: "alice", Plotter: "p1"},
+		// read from the plotter index and sorted by status
+		{Sort: v1.JobSortStatus, User: "alice", Plotter: "p1"},
+		// read from the time index and sorted by user
+		{Sort: v1.JobSortUser, Status: v1.JobStatusPending, SubmittedAfter: other.SubmittedAt.Add(time.Minute)},
+	} {
+		query.Limit = 3
+		actual := []string{}
+		for {
+			list, err := q.List(query)
+			require.NoError(t, err)
+			actual = append(actual, ids(list.Jobs)...)
+
+			if list.Next == "" {
+				break
+			}
+			query.Cursor = list.Next
+		}
+		require.Equal(t, expected, actual, "%+v", query)
+	}
+
+	// descending pages continue after deleted jobs
+	list, err := q.List(v1.JobQuery{Sort: v1.JobSortSubmittedAtDesc, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{expected[9], expected[8]}, ids(list.Jobs))
+
+	for i := 0; i < 9; i++ {
+		_, err := q.Dequeue()
+		require.NoError(t, err)
+	}
+	require.NoError(t, q.Delete(expected[8]))
+
+	list, err = q.List(v1.JobQuery{Sort: v1.JobSortSubmittedAtDesc, Limit: 2, Cursor: list.Next})
+	require.NoError(t, err)
+	require.Equal(t, []string{expected[7], expected[6]}, ids(list.Jobs))
+
+	// cursors belong to their query
+	_, err = q.List(v1.JobQuery{Sort: v1.JobSortUser, Cursor: list.Next})
+	require.ErrorIs(t, err, jobqueue.ErrInvalidCursor)
+
+	_, err = q.List(v1.JobQuery{Cursor: "not base64!"})
+	require.ErrorIs(t, err, jobqueue.ErrInvalidCursor)
+}
+
+func TestListSeparatorInValues(t *testing.T) {
+	testutil.ForEachQueue(t, func(t *testing.T, q jobqueue.Queue) {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		jobs := []v1.Job{
+			{ID: "a", User: "a\x00b", Plotter: "p1"},
+			{ID: "b", User: "a", Plotter: "p1\x00"},
+			{ID: "c", User: "ab", Plotter: "p1"},
+		}
+		for i := range jobs {
+			jobs[i].Status = v1.JobStatusPending
+			jobs[i].SubmittedAt = start.Add(time.Duration(i) * time.Minute)
+			require.NoError(t, q.Enqueue(&jobs[i]))
+		}
+
+		list, err := q.List(v1.JobQuery{User: "a", Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, ids(list.Jobs))
+
+		list, err = q.List(v1.JobQuery{Plotter: "p1", Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "c"}, ids(list.Jobs))
+
+		list, err = q.List(v1.JobQuery{Sort: v1.JobSortUser, Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "a", "c"}, ids(list.Jobs))
+	})
+}
+
+func TestListUpdatesIndex(t *testing.T) {
+	testutil.ForEachQueue(t, testListUpdatesIndex)
+}
+
+func testListUpdatesIndex(t *testing.T, q jobqueue.Queue) {
+	job := testutil.RandJob()
+	job.Status = v1.JobStatusPending
+	require.NoError(t, q.Enqueue(&job))
+
+	_, err := q.Cancel(job.ID)
+	require.NoError(t, err)
+
+	list, err := q.List(v1.JobQuery{Status: v1.JobStatusPending})
+	require.NoError(t, err)
+	require.Empty(t, list.Jobs)
+
+	list, err = q.List(v1.JobQuery{Status: v1.JobStatusCanceled})
+	require.NoError(t, err)
+	require.Equal(t, []string{job.ID}, ids(list.Jobs))
+
+	_, err = q.Dequeue()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+	require.NoError(t, q.Delete(job.ID))
+
+	list, err = q.List(v1.JobQuery{})
+	require.NoError(t, err)
+	require.Empty(t, list.Jobs)
+}
+
+func TestSkipCanceled(t *testing.T) {
+	testutil.ForEachQueue(t, testSkipCanceled)
+}
+
+func testSkipCanceled(t *testing.T, q jobqueue.Queue) {
+	jobs := make([]v1.Job, 4)
+	for i := range jobs {
+		jobs[i] = testutil.RandPendingJob()
+		require.NoError(t, q.Enqueue(&jobs[i]))
+	}
+
+	for _, i := range []int{0, 1, 3} {
+		_, err := q.Cancel(jobs[i].ID)
+		require.NoError(t, err)
+	}
+
+	job, err := q.Peek()
+	require.NoError(t, err)
+	require.Equal(t, jobs[2].ID, job.ID)
+
+	job, err = q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, jobs[2].ID, job.ID)
+
+	// the skipped jobs have left the queue and can no longer be restored
+	for _, i := range []int{0, 1} {
+		job, err = q.Get(jobs[i].ID)
+		require.NoError(t, err)
+		require.Equal(t, v1.JobStatusCanceled, job.Status)
+
+		job, err = q.Restore(jobs[i].ID)
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		require.NoError(t, q.Delete(jobs[i].ID))
+	}
+
+	_, err = q.Peek()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+
+	_, err = q.Dequeue()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+
+	_, err = q.Dequeue()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+}
+
+func TestRestore(t *testing.T) {
+	testutil.ForEachQueue(t, testRestore)
+}
+
+func testRestore(t *testing.T, q jobqueue.Queue) {
+	jobs := make([]v1.Job, 2)
+	for i := range jobs {
+		jobs[i] = testutil.RandPendingJob()
+		require.NoError(t, q.Enqueue(&jobs[i]))
+	}
+
+	_, err := q.Restore(jobs[0].ID)
+	require.ErrorIs(t, err, jobqueue.ErrNotCanceled)
+
+	job, err := q.Restore("unknown")
+	require.NoError(t, err)
+	require.Nil(t, job)
+
+	_, err = q.Cancel(jobs[0].ID)
+	require.NoError(t, err)
+
+	job, err = q.Restore(jobs[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusPending, job.Status)
+	require.Nil(t, job.FinishedAt)
+
+	// the restored job keeps its position in the queue
+	job, err = q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, jobs[0].ID, job.ID)
+	require.Equal(t, v1.JobStatusPending, job.Status)
+
+	job, err = q.Restore(jobs[0].ID)
+	require.NoError(t, err)
+	require.Nil(t, job)
+}
+
+func TestConverted(t *testing.T) {
+	testutil.ForEachQueue(t, testConverted)
+}
+
+func testConverted(t *testing.T, q jobqueue.Queue) {
+	settings := v1.JobSettings{Device: "hp7475a", Pagesize: "a4", Orientation: v1.OrientationPortrait, Velocity: 10}
+
+	jobs := make([]v1.Job, 4)
+	for i := range jobs {
+		jobs[i] = testutil.RandPendingJob()
+		jobs[i].SVGHash = "svg"
+		jobs[i].HPGL = "hpgl"
+		jobs[i].Settings = settings
+		jobs[i].SubmittedAt = jobs[i].SubmittedAt.Add(time.Duration(i) * time.Second)
+	}
+
+	// converted with other settings
+	jobs[1].Settings.Velocity = 20
+	// not converted yet
+	jobs[0].HPGL = ""
+	jobs[3].HPGL = ""
+
+	for i := range jobs {
+		require.NoError(t, q.Enqueue(&jobs[i]))
+	}
+
+	// converted once it has been processed
+	_, err := q.Dequeue()
+	require.NoError(t, err)
+	jobs[0].HPGL = "hpgl"
+	require.NoError(t, q.Update(&jobs[0]))
+
+	converted, err := q.Converted("svg", settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{jobs[2].ID, jobs[0].ID}, ids(converted))
+
+	converted, err = q.Converted("other", settings)
+	require.NoError(t, err)
+	require.Empty(t, converted)
+}
+
+func TestConvertedRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+
+	job := testutil.RandPendingJob()
+	job.SVGHash = "svg"
+	job.HPGL = "hpgl"
+	require.NoError(t, local.Enqueue(&job))
+	require.NoError(t, local.Close())
+
+	// an index written before jobs were indexed by their conversion
+	db, err := leveldb.OpenFile(dir, nil)
+	require.NoError(t, err)
+	iter := db.NewIterator(util.BytesPrefix([]byte("i\x00hpgl\x00")), nil)
+	for iter.Next() {
+		require.NoError(t, db.Delete(iter.Key(), nil))
+	}
+	iter.Release()
+	require.NoError(t, db.Put([]byte("version"), []byte("1"), nil))
+	require.NoError(t, db.Close())
+
+	local, err = jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	converted, err := local.Converted("svg", job.Settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{job.ID}, ids(converted))
+
+	list, err := local.List(v1.JobQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []string{job.ID}, ids(list.Jobs))
+}
+
+func TestRepairIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+
+	job := testutil.RandPendingJob()
+	require.NoError(t, local.Enqueue(&job))
+	require.NoError(t, local.Close())
+
+	// a queue that has not been closed after its job was written without
+	// index entries
+	db, err := leveldb.OpenFile(dir, nil)
+	require.NoError(t, err)
+	for _, prefix := range []string{"e\x00", "i\x00", "clean"} {
+		iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for iter.Next() {
+			require.NoError(t, db.Delete(iter.Key(), nil))
+		}
+		iter.Release()
+	}
+	require.NoError(t, db.Close())
+
+	local, err = jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	list, err := local.List(v1.JobQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []string{job.ID}, ids(list.Jobs))
+}
+
+func TestRemoveLegacyIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	// the index database of earlier versions
+	legacy := filepath.Join(dir, "index")
+	db, err := leveldb.OpenFile(legacy, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	_, err = os.Stat(legacy)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOpenGoqueQueue(t *testing.T) {
+	dir := t.TempDir()
+
+	// a queue written by earlier versions using goque
+	queue, err := goque.OpenQueue(dir)
+	require.NoError(t, err)
+
+	expected := make([]v1.Job, 3)
+	for i := range expected {
+		expected[i] = testutil.RandPendingJob()
+		expected[i].SubmittedAt = expected[i].SubmittedAt.Add(time.Duration(i) * time.Second)
+		_, err := queue.EnqueueObjectAsJSON(expected[i])
+		require.NoError(t, err)
+	}
+
+	// the first job has already been dequeued
+	_, err = queue.Dequeue()
+	require.NoError(t, err)
+	require.NoError(t, queue.Close())
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	list, err := local.List(v1.JobQuery{})
+	require.NoError(t, err)
+	require.Equal(t, ids(expected[1:]), ids(list.Jobs))
+
+	job, err := local.Get(expected[2].ID)
+	require.NoError(t, err)
+	require.Equal(t, expected[2].ID, job.ID)
+
+	canceled, err := local.Cancel(expected[2].ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusCanceled, canceled.Status)
+
+	job, err = local.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, expected[1].ID, job.ID)
+
+	// new jobs are queued after the existing ones, canceled jobs are skipped
+	next := testutil.RandPendingJob()
+	require.NoError(t, local.Enqueue(&next))
+
+	job, err = local.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, next.ID, job.ID)
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+
+	expected := make([]v1.Job, 3)
+	for i := range expected {
+		expected[i] = testutil.RandPendingJob()
+		require.NoError(t, local.Enqueue(&expected[i]))
+	}
+
+	_, err = local.Dequeue()
+	require.NoError(t, err)
+	require.NoError(t, local.Close())
+
+	local, err = jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	defer local.Close()
+
+	// jobs are found by ID whether queued or not
+	for _, job := range expected {
+		actual, err := local.Get(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, job.ID, actual.ID)
+	}
+
+	// only queued jobs can be canceled
+	canceled, err := local.Cancel(expected[0].ID)
+	require.NoError(t, err)
+	require.Nil(t, canceled)
+
+	canceled, err = local.Cancel(expected[2].ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusCanceled, canceled.Status)
+
+	require.ErrorIs(t, local.Delete(expected[1].ID), jobqueue.ErrJobQueued)
+
+	job, err := local.Peek()
+	require.NoError(t, err)
+	require.Equal(t, expected[1].ID, job.ID)
+
+	all, err := local.GetAll()
+	require.NoError(t, err)
+	require.Equal(t, []string{expected[1].ID, expected[2].ID, expected[0].ID}, ids(all))
+}
+
+// queue returns a queue of n pending jobs and the ID of a job in the middle.
+func queue(b *testing.B, n int) (jobqueue.Queue, string) {
+	local, err := jobqueue.OpenLocal(b.TempDir())
+	require.NoError(b, err)
+	b.Cleanup(func() { local.Close() })
+
+	id := ""
+	for i := 0; i < n; i++ {
+		job := testutil.RandJob()
+		job.Status = v1.JobStatusPending
+		require.NoError(b, local.Enqueue(&job))
+		if i == n/2 {
+			id = job.ID
+		}
+	}
+
+	return local, id
+}
+
+var sizes = []int{100, 1000, 10000}
+
+func BenchmarkGet(b *testing.B) {
+	for _, n := range sizes {
+		b.Run(fmt.Sprintf("queued=%d", n), func(b *testing.B) {
+			local, id := queue(b, n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := local.Get(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	for _, n := range sizes {
+		b.Run(fmt.Sprintf("queued=%d", n), func(b *testing.B) {
+			local, id := queue(b, n)
+			job, err := local.Get(id)
+			require.NoError(b, err)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := local.Update(job); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCancel(b *testing.B) {
+	for _, n := range sizes {
+		b.Run(fmt.Sprintf("queued=%d", n), func(b *testing.B) {
+			local, id := queue(b, n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := local.Cancel(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
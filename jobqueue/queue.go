@@ -1,7 +1,9 @@
 package jobqueue
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	v1 "github.com/st3v/plotq/api/v1"
 )
@@ -9,10 +11,66 @@ import (
 type Queue interface {
 	Enqueue(job *v1.Job) error
 	GetAll() ([]v1.Job, error)
+	List(query v1.JobQuery) (*v1.JobList, error)
 	Get(id string) (*v1.Job, error)
 	Cancel(id string) (*v1.Job, error)
+	Restore(id string) (*v1.Job, error)
+	Update(job *v1.Job) error
+	Delete(id string) error
+	Converted(svgHash string, settings v1.JobSettings) ([]v1.Job, error)
 	Peek() (*v1.Job, error)
 	Dequeue() (*v1.Job, error)
 }
 
-var ErrQueueEmpty = errors.New("queue empty")
+var (
+	ErrQueueEmpty  = errors.New("queue empty")
+	ErrJobQueued   = errors.New("job still queued")
+	ErrNotCanceled = errors.New("job not canceled")
+)
+
+// conversion identifies the HPGL converted from the SVG with the given hash
+// using the given settings.
+func conversion(svgHash string, settings v1.JobSettings) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%d", svgHash, settings.Device, settings.Pagesize, settings.Orientation, settings.Velocity)
+}
+
+// jobConversion returns the conversion of the job's HPGL, or an empty string if
+// the job has not been converted.
+func jobConversion(job *v1.Job) string {
+	if job.HPGL == "" || job.SVGHash == "" {
+		return ""
+	}
+	return conversion(job.SVGHash, job.Settings)
+}
+
+// record is the stored form of a job, including the fields that are not
+// exposed by the API.
+type record struct {
+	*v1.Job
+	Notify       string            `json:"notify,omitempty"`
+	TraceContext map[string]string `json:"traceContext,omitempty"`
+}
+
+// encodeJob returns the stored form of the job.
+func encodeJob(job *v1.Job) ([]byte, error) {
+	return json.Marshal(record{Job: job, Notify: job.Notify, TraceContext: job.TraceContext})
+}
+
+// decodeJob decodes the stored form of a job into the given job.
+func decodeJob(value []byte, job *v1.Job) error {
+	r := record{Job: job}
+	if err := json.Unmarshal(value, &r); err != nil {
+		return err
+	}
+
+	job.Notify, job.TraceContext = r.Notify, r.TraceContext
+	return nil
+}
+
+// PartitionedQueue is a queue that can also hand out the jobs of a single
+// plotter in order.
+type PartitionedQueue interface {
+	Queue
+	PeekPlotter(plotter string) (*v1.Job, error)
+	DequeuePlotter(plotter string) (*v1.Job, error)
+}
@@ -0,0 +1,528 @@
+package jobqueue
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+// migrations create and update the schema of SQLite queues in order. The number
+// of migrations applied is stored as the database's user_version.
+var migrations = []string{
+	`CREATE TABLE jobs (
+		seq          INTEGER PRIMARY KEY AUTOINCREMENT,
+		id           TEXT NOT NULL UNIQUE,
+		queued       INTEGER NOT NULL,
+		priority     INTEGER NOT NULL DEFAULT 0,
+		status       TEXT NOT NULL,
+		user         TEXT NOT NULL,
+		plotter      TEXT NOT NULL,
+		filename     TEXT NOT NULL DEFAULT '',
+		conversion   TEXT NOT NULL DEFAULT '',
+		submitted_at INTEGER NOT NULL,
+		job          TEXT NOT NULL
+	);
+	CREATE INDEX jobs_queue ON jobs (priority DESC, seq) WHERE queued = 1;
+	CREATE INDEX jobs_plotter_queue ON jobs (plotter, priority DESC, seq) WHERE queued = 1;
+	CREATE INDEX jobs_submitted_at ON jobs (submitted_at, id);
+	CREATE INDEX jobs_status ON jobs (status, submitted_at, id);
+	CREATE INDEX jobs_user ON jobs (user, submitted_at, id);
+	CREATE INDEX jobs_plotter ON jobs (plotter, submitted_at, id);
+	CREATE INDEX jobs_conversion ON jobs (conversion, submitted_at) WHERE conversion != '';`,
+}
+
+// sortColumns are the columns of the fields jobs can be sorted by. Jobs with
+// the same value are ordered by submission time.
+var sortColumns = map[string]string{
+	fieldAll:     "",
+	fieldStatus:  "status",
+	fieldUser:    "user",
+	fieldPlotter: "plotter",
+}
+
+// queueOrder is the order in which queued jobs are processed.
+const queueOrder = "ORDER BY priority DESC, seq"
+
+type sqliteQueue struct {
+	db *sql.DB
+}
+
+// sqliteQueue implements the PartitionedQueue interface.
+var _ PartitionedQueue = &sqliteQueue{}
+
+// OpenSQLite opens the SQLite queue stored in the given file, creating the file
+// if it does not exist. Unlike the local queue, jobs are processed in order of
+// priority and can be taken from the queue per plotter.
+func OpenSQLite(path string) (*sqliteQueue, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(wal)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue: %w", err)
+	}
+
+	// SQLite serializes writes, a single connection avoids busy errors
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteQueue{db: db}, nil
+}
+
+// migrate applies the migrations the database has not seen yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for ; version < len(migrations); version++ {
+		err := transaction(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migrations[version]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate schema to version %d: %w", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+// transaction runs fn in a transaction that is committed if fn succeeds.
+func transaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the queue.
+func (q *sqliteQueue) Close() error {
+	return q.db.Close()
+}
+
+// Check returns an error if the queue has been closed.
+func (q *sqliteQueue) Check() error {
+	return q.db.Ping()
+}
+
+// Enqueue adds the given job to the queue.
+func (q *sqliteQueue) Enqueue(job *v1.Job) error {
+	value, err := encodeJob(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	_, err = q.db.Exec(`INSERT INTO jobs (id, queued, priority, status, user, plotter, filename, conversion, submitted_at, job)
+		VALUES (?, 1, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Priority, job.Status, job.User, job.Plotter, job.Filename, jobConversion(job), job.SubmittedAt.UnixNano(), value)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// GetAll returns all jobs in the queue in the order they will be processed
+// followed by the jobs that have already left the queue, ordered by submission time.
+func (q *sqliteQueue) GetAll() ([]v1.Job, error) {
+	queued, err := q.query(q.db, "SELECT job FROM jobs WHERE queued = 1 "+queueOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := q.query(q.db, "SELECT job FROM jobs WHERE queued = 0 ORDER BY submitted_at, seq")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(queued, history...), nil
+}
+
+// List returns a page of the jobs matching the given query.
+func (q *sqliteQueue) List(query v1.JobQuery) (*v1.JobList, error) {
+	field, desc := query.Sort.Field()
+	column, ok := sortColumns[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", field)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = v1.DefaultListLimit
+	}
+
+	where, args := []string{"1 = 1"}, []interface{}{}
+	filter := func(condition string, values ...interface{}) {
+		where = append(where, condition)
+		args = append(args, values...)
+	}
+
+	if query.Status != "" {
+		filter("status = ?", query.Status)
+	}
+	if query.User != "" {
+		filter("user = ?", query.User)
+	}
+	if query.Plotter != "" {
+		filter("plotter = ?", query.Plotter)
+	}
+	if !query.SubmittedAfter.IsZero() {
+		filter("submitted_at >= ?", query.SubmittedAfter.UnixNano())
+	}
+	if !query.SubmittedBefore.IsZero() {
+		filter("submitted_at < ?", query.SubmittedBefore.UnixNano())
+	}
+	if query.Search != "" {
+		search := strings.ToLower(query.Search)
+		filter("(instr(lower(id), ?) > 0 OR instr(lower(filename), ?) > 0)", search, search)
+	}
+
+	columns := []string{"submitted_at", "id"}
+	if column != "" {
+		columns = append([]string{column}, columns...)
+	}
+
+	direction, compare := "ASC", ">"
+	if desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil || c.Sort != field {
+			return nil, ErrInvalidCursor
+		}
+
+		values := []interface{}{c.SubmittedAt, c.ID}
+		if column != "" {
+			values = append([]interface{}{c.Value}, values...)
+		}
+
+		filter(fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), compare, strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")), values...)
+	}
+
+	order := make([]string, len(columns))
+	for i, c := range columns {
+		order[i] = c + " " + direction
+	}
+
+	jobs, err := q.query(q.db, fmt.Sprintf("SELECT job FROM jobs WHERE %s ORDER BY %s LIMIT %d",
+		strings.Join(where, " AND "), strings.Join(order, ", "), limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &v1.JobList{Jobs: jobs}
+	if len(jobs) > limit {
+		list.Jobs = jobs[:limit]
+		list.Next = encodeCursor(field, list.Jobs[limit-1])
+	}
+
+	return list, nil
+}
+
+// cursor is the position of the last job of a page in the order of the query.
+type cursor struct {
+	Sort        string `json:"s"`
+	Value       string `json:"v,omitempty"`
+	SubmittedAt int64  `json:"t"`
+	ID          string `json:"id"`
+}
+
+func encodeCursor(field string, job v1.Job) string {
+	c := cursor{Sort: field, SubmittedAt: job.SubmittedAt.UnixNano(), ID: job.ID}
+	switch field {
+	case fieldStatus:
+		c.Value = string(job.Status)
+	case fieldUser:
+		c.Value = job.User
+	case fieldPlotter:
+		c.Value = job.Plotter
+	}
+
+	value, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	c := cursor{}
+
+	value, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	return c, json.Unmarshal(value, &c)
+}
+
+// Get returns the job with the given ID.
+func (q *sqliteQueue) Get(id string) (*v1.Job, error) {
+	return q.queryOne(q.db, "SELECT job FROM jobs WHERE id = ?", id)
+}
+
+// Update replaces the stored job with the same ID as the given job. Jobs that
+// are not stored yet are added to the job history.
+func (q *sqliteQueue) Update(job *v1.Job) error {
+	value, err := encodeJob(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	_, err = q.db.Exec(`INSERT INTO jobs (id, queued, priority, status, user, plotter, filename, conversion, submitted_at, job)
+		VALUES (?, 0, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			priority = excluded.priority,
+			status = excluded.status,
+			user = excluded.user,
+			plotter = excluded.plotter,
+			filename = excluded.filename,
+			conversion = excluded.conversion,
+			submitted_at = excluded.submitted_at,
+			job = excluded.job`,
+		job.ID, job.Priority, job.Status, job.User, job.Plotter, job.Filename, jobConversion(job), job.SubmittedAt.UnixNano(), value)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// Converted returns the jobs whose SVG with the given hash has been converted
+// to HPGL using the given settings, most recently submitted first.
+func (q *sqliteQueue) Converted(svgHash string, settings v1.JobSettings) ([]v1.Job, error) {
+	return q.query(q.db, "SELECT job FROM jobs WHERE conversion = ? ORDER BY submitted_at DESC, seq DESC", conversion(svgHash, settings))
+}
+
+// Cancel marks the queued job with the given ID as canceled. It returns nil if
+// there is no such job in the queue.
+func (q *sqliteQueue) Cancel(id string) (*v1.Job, error) {
+	var job *v1.Job
+
+	err := transaction(q.db, func(tx *sql.Tx) error {
+		var err error
+		job, err = q.queryOne(tx, "SELECT job FROM jobs WHERE id = ? AND queued = 1", id)
+		if err != nil || job == nil {
+			return err
+		}
+
+		now := time.Now()
+		job.Status = v1.JobStatusCanceled
+		job.FinishedAt = &now
+
+		value, err := encodeJob(job)
+		if err != nil {
+			return fmt.Errorf("failed to encode job: %w", err)
+		}
+
+		_, err = tx.Exec("UPDATE jobs SET status = ?, job = ? WHERE id = ?", job.Status, value, id)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel job: %w", err)
+	}
+
+	return job, nil
+}
+
+// Restore puts the canceled job with the given ID back into the pending state
+// at its position in the queue. It returns nil if there is no such job in the
+// queue and ErrNotCanceled if the job has not been canceled.
+func (q *sqliteQueue) Restore(id string) (*v1.Job, error) {
+	var job *v1.Job
+
+	err := transaction(q.db, func(tx *sql.Tx) error {
+		var err error
+		job, err = q.queryOne(tx, "SELECT job FROM jobs WHERE id = ? AND queued = 1", id)
+		if err != nil || job == nil {
+			return err
+		}
+
+		if job.Status != v1.JobStatusCanceled {
+			return ErrNotCanceled
+		}
+
+		job.Status = v1.JobStatusPending
+		job.FinishedAt = nil
+
+		value, err := encodeJob(job)
+		if err != nil {
+			return fmt.Errorf("failed to encode job: %w", err)
+		}
+
+		_, err = tx.Exec("UPDATE jobs SET status = ?, job = ? WHERE id = ?", job.Status, value, id)
+		return err
+	})
+	if errors.Is(err, ErrNotCanceled) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to restore job: %w", err)
+	}
+
+	return job, nil
+}
+
+// Delete removes the record of the job with the given ID from the job history.
+// Jobs that are still queued can only be canceled, deleting them returns ErrJobQueued.
+func (q *sqliteQueue) Delete(id string) error {
+	return transaction(q.db, func(tx *sql.Tx) error {
+		var queued bool
+		err := tx.QueryRow("SELECT queued FROM jobs WHERE id = ?", id).Scan(&queued)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to delete job: %w", err)
+		}
+
+		if queued {
+			return ErrJobQueued
+		}
+
+		if _, err := tx.Exec("DELETE FROM jobs WHERE id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete job: %w", err)
+		}
+		return nil
+	})
+}
+
+// Peek returns the next job from the queue without removing it. Canceled jobs
+// are skipped.
+func (q *sqliteQueue) Peek() (*v1.Job, error) {
+	return q.peek(q.db, "", false)
+}
+
+// PeekPlotter returns the next job for the given plotter without removing it.
+// Canceled jobs are skipped.
+func (q *sqliteQueue) PeekPlotter(plotter string) (*v1.Job, error) {
+	return q.peek(q.db, plotter, false)
+}
+
+// Dequeue returns the next job from the queue and moves it to the job history.
+// Canceled jobs ahead of it are moved to the job history without being returned.
+func (q *sqliteQueue) Dequeue() (*v1.Job, error) {
+	return q.dequeue("")
+}
+
+// DequeuePlotter returns the next job for the given plotter and moves it to
+// the job history. Canceled jobs ahead of it are moved to the job history
+// without being returned.
+func (q *sqliteQueue) DequeuePlotter(plotter string) (*v1.Job, error) {
+	return q.dequeue(plotter)
+}
+
+func (q *sqliteQueue) dequeue(plotter string) (*v1.Job, error) {
+	var job *v1.Job
+
+	err := transaction(q.db, func(tx *sql.Tx) error {
+		for {
+			next, err := q.peek(tx, plotter, true)
+			if errors.Is(err, ErrQueueEmpty) {
+				// commit the canceled jobs that have been skipped
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if _, err = tx.Exec("UPDATE jobs SET queued = 0 WHERE id = ?", next.ID); err != nil {
+				return err
+			}
+
+			if next.Status != v1.JobStatusCanceled {
+				job = next
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if job == nil {
+		return nil, ErrQueueEmpty
+	}
+	return job, nil
+}
+
+// peek returns the next job in the queue, limited to the given plotter if it
+// is not empty. Canceled jobs are only returned if canceled is true.
+func (q *sqliteQueue) peek(db querier, plotter string, canceled bool) (*v1.Job, error) {
+	where := "queued = 1"
+	args := []interface{}{}
+
+	if plotter != "" {
+		where += " AND plotter = ?"
+		args = append(args, plotter)
+	}
+
+	if !canceled {
+		where += " AND status != ?"
+		args = append(args, v1.JobStatusCanceled)
+	}
+
+	job, err := q.queryOne(db, "SELECT job FROM jobs WHERE "+where+" "+queueOrder+" LIMIT 1", args...)
+	if err == nil && job == nil {
+		return nil, ErrQueueEmpty
+	}
+	return job, err
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// query returns the jobs selected by the query.
+func (q *sqliteQueue) query(db querier, query string, args ...interface{}) ([]v1.Job, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []v1.Job{}
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to read jobs: %w", err)
+		}
+
+		job := v1.Job{}
+		if err := decodeJob(value, &job); err != nil {
+			return nil, fmt.Errorf("failed to decode job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// queryOne returns the first job selected by the query or nil if there is none.
+func (q *sqliteQueue) queryOne(db querier, query string, args ...interface{}) (*v1.Job, error) {
+	jobs, err := q.query(db, query, args...)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
@@ -0,0 +1,202 @@
+package jobqueue_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/testutil"
+)
+
+func TestSQLiteQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+
+	q, err := jobqueue.OpenSQLite(path)
+	require.NoError(t, err)
+
+	_, err = q.Peek()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+
+	expected := make([]v1.Job, 3)
+	for i := range expected {
+		expected[i] = testutil.RandPendingJob()
+		require.NoError(t, q.Enqueue(&expected[i]))
+	}
+
+	// the same job cannot be queued twice
+	require.Error(t, q.Enqueue(&expected[0]))
+
+	job, err := q.Peek()
+	require.NoError(t, err)
+	require.Equal(t, expected[0].ID, job.ID)
+
+	job, err = q.Dequeue()
+	require.NoError(t, err)
+	require.True(t, job.SubmittedAt.Equal(expected[0].SubmittedAt))
+	expected[0].SubmittedAt = job.SubmittedAt
+	require.Equal(t, expected[0], *job)
+
+	// dequeued jobs remain available
+	job.Status = v1.JobStatusSucceeded
+	require.NoError(t, q.Update(job))
+
+	require.NoError(t, q.Close())
+	require.Error(t, q.Check())
+
+	// the queue is kept when reopened
+	q, err = jobqueue.OpenSQLite(path)
+	require.NoError(t, err)
+	defer q.Close()
+	require.NoError(t, q.Check())
+
+	all, err := q.GetAll()
+	require.NoError(t, err)
+	require.Equal(t, []string{expected[1].ID, expected[2].ID, expected[0].ID}, ids(all))
+	require.Equal(t, v1.JobStatusSucceeded, all[2].Status)
+
+	// only queued jobs can be canceled, only dequeued jobs deleted
+	canceled, err := q.Cancel(expected[0].ID)
+	require.NoError(t, err)
+	require.Nil(t, canceled)
+
+	canceled, err = q.Cancel(expected[1].ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusCanceled, canceled.Status)
+	require.NotNil(t, canceled.FinishedAt)
+
+	job, err = q.Get(expected[1].ID)
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusCanceled, job.Status)
+
+	require.ErrorIs(t, q.Delete(expected[1].ID), jobqueue.ErrJobQueued)
+	require.NoError(t, q.Delete(expected[0].ID))
+	require.NoError(t, q.Delete("unknown"))
+
+	job, err = q.Get(expected[0].ID)
+	require.NoError(t, err)
+	require.Nil(t, job)
+
+	// updating a queued job keeps it queued
+	expected[2].User = "alice"
+	require.NoError(t, q.Update(&expected[2]))
+
+	// the canceled job is skipped
+	job, err = q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, expected[2].ID, job.ID)
+	require.Equal(t, "alice", job.User)
+
+	_, err = q.Dequeue()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+}
+
+func TestSQLitePriorities(t *testing.T) {
+	q, err := jobqueue.OpenSQLite(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer q.Close()
+
+	jobs := []v1.Job{
+		{ID: "a", Plotter: "p1"},
+		{ID: "b", Plotter: "p2", Priority: 1},
+		{ID: "c", Plotter: "p1", Priority: 1},
+		{ID: "d", Plotter: "p2"},
+		{ID: "e", Plotter: "p1", Priority: -1},
+	}
+	for i := range jobs {
+		require.NoError(t, q.Enqueue(&jobs[i]))
+	}
+
+	all, err := q.GetAll()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c", "a", "d", "e"}, ids(all))
+
+	// plotters have their own partition of the queue
+	job, err := q.PeekPlotter("p1")
+	require.NoError(t, err)
+	require.Equal(t, "c", job.ID)
+
+	for _, id := range []string{"c", "a", "e"} {
+		job, err = q.DequeuePlotter("p1")
+		require.NoError(t, err)
+		require.Equal(t, id, job.ID)
+	}
+
+	_, err = q.DequeuePlotter("p1")
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+
+	_, err = q.PeekPlotter("p3")
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+
+	// canceled jobs are skipped within a partition
+	_, err = q.Cancel("b")
+	require.NoError(t, err)
+
+	job, err = q.PeekPlotter("p2")
+	require.NoError(t, err)
+	require.Equal(t, "d", job.ID)
+
+	job, err = q.DequeuePlotter("p2")
+	require.NoError(t, err)
+	require.Equal(t, "d", job.ID)
+
+	job, err = q.Get("b")
+	require.NoError(t, err)
+	require.Equal(t, v1.JobStatusCanceled, job.Status)
+
+	_, err = q.Dequeue()
+	require.ErrorIs(t, err, jobqueue.ErrQueueEmpty)
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+
+	// a queue written by earlier versions using goque
+	queue, err := goque.OpenQueue(dir)
+	require.NoError(t, err)
+
+	expected := make([]v1.Job, 4)
+	for i := range expected {
+		expected[i] = testutil.RandPendingJob()
+		expected[i].SubmittedAt = expected[i].SubmittedAt.Add(time.Duration(i) * time.Second)
+		_, err := queue.EnqueueObjectAsJSON(expected[i])
+		require.NoError(t, err)
+	}
+	require.NoError(t, queue.Close())
+
+	// with some jobs already processed
+	local, err := jobqueue.OpenLocal(dir)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		job, err := local.Dequeue()
+		require.NoError(t, err)
+		job.Status = v1.JobStatusSucceeded
+		require.NoError(t, local.Update(job))
+	}
+	require.NoError(t, local.Close())
+
+	q, err := jobqueue.OpenSQLite(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer q.Close()
+
+	n, err := jobqueue.Import(q, dir)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	all, err := q.GetAll()
+	require.NoError(t, err)
+	require.Equal(t, []string{expected[2].ID, expected[3].ID, expected[0].ID, expected[1].ID}, ids(all))
+	require.Equal(t, v1.JobStatusSucceeded, all[2].Status)
+
+	job, err := q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, expected[2].ID, job.ID)
+
+	// jobs are only imported once
+	_, err = jobqueue.Import(q, dir)
+	require.ErrorIs(t, err, jobqueue.ErrNotEmpty)
+}
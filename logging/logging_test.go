@@ -0,0 +1,88 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/st3v/plotq/logging"
+	"github.com/st3v/plotq/testutil"
+)
+
+func TestNew(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	logger, err := logging.New(buf, "warn", "json")
+	require.NoError(t, err)
+
+	logger.Info("hidden")
+	logger.Warn("shown", "job", "abc")
+
+	line := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	require.Equal(t, "shown", line["msg"])
+	require.Equal(t, "abc", line["job"])
+
+	_, err = logging.New(buf, "loud", "")
+	require.ErrorContains(t, err, "invalid log level")
+
+	_, err = logging.New(buf, "", "xml")
+	require.ErrorContains(t, err, "invalid log format")
+}
+
+func TestJob(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := logging.New(buf, "", "json")
+	require.NoError(t, err)
+
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	job := testutil.RandJob()
+	logging.Job(job).Info("processing job")
+
+	line := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	require.Equal(t, job.ID, line["job"])
+	require.Equal(t, job.Plotter, line["plotter"])
+	require.Equal(t, job.User, line["user"])
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := logging.New(buf, "", "json")
+	require.NoError(t, err)
+
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("handling request")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs", nil)
+	req.Header.Set(logging.RequestIDHeader, "abc123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	require.Equal(t, "abc123", rec.Header().Get(logging.RequestIDHeader))
+
+	dec := json.NewDecoder(buf)
+	for _, msg := range []string{"handling request", "served request"} {
+		line := map[string]interface{}{}
+		require.NoError(t, dec.Decode(&line))
+		require.Equal(t, msg, line["msg"])
+		require.Equal(t, "abc123", line["request"])
+	}
+
+	// requests without ID are assigned one
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Len(t, rec.Header().Get(logging.RequestIDHeader), 16)
+}
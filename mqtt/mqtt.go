@@ -0,0 +1,300 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+)
+
+const (
+	// DefaultClientID is the default client ID used to connect to the broker.
+	DefaultClientID = "plotq"
+
+	// DefaultPrefix is the default prefix of all topics.
+	DefaultPrefix = "plotq"
+
+	// qos is the quality of service used for all messages.
+	qos = 1
+
+	// timeout limits how long to wait for the broker.
+	timeout = 10 * time.Second
+
+	// quiesce is the time given to outstanding work when disconnecting.
+	quiesce = 250 // ms
+)
+
+// Commands accepted on the command topic.
+const (
+	CommandPause  = "pause"
+	CommandResume = "resume"
+	CommandStart  = "start" // same as resume
+	CommandCancel = "cancel"
+)
+
+// Plotter states published on the plotter status topics.
+const (
+	StateIdle    = "idle"
+	StateBusy    = "busy"
+	StateOffline = "offline"
+)
+
+// Config is the configuration for the MQTT bridge.
+type Config struct {
+	// Broker is the URL of the broker, e.g. tcp://mqtt:1883.
+	Broker string
+
+	// ClientID is the client ID used to connect to the broker. Defaults to plotq.
+	ClientID string
+
+	// Username and Password are used to authenticate with the broker.
+	Username string
+	Password string
+
+	// Prefix is prepended to all topics. Defaults to plotq.
+	Prefix string
+
+	// Commands enables pausing and resuming the queue and canceling jobs
+	// through the command topic. Anyone allowed to publish to the topic by the
+	// broker can do so.
+	Commands bool
+}
+
+// Command is a message on the command topic, <prefix>/commands.
+type Command struct {
+	// Command is one of pause, resume, start or cancel.
+	Command string `json:"command"`
+
+	// Job is the ID of the job to cancel.
+	Job string `json:"job,omitempty"`
+}
+
+// PlotterStatus is the retained message on a plotter's status topic,
+// <prefix>/plotters/<plotter>/status.
+type PlotterStatus struct {
+	Plotter string    `json:"plotter"`
+	State   string    `json:"state"`
+	Job     string    `json:"job,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type Spooler interface {
+	DeleteJob(id string) (*v1.Job, error)
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// Bridge publishes job and plotter state to an MQTT broker and optionally
+// accepts commands from it.
+type Bridge struct {
+	config  Config
+	spooler Spooler
+	client  paho.Client
+
+	mu       sync.Mutex // guards plotters
+	plotters map[string]PlotterStatus
+}
+
+// NewBridge returns a new MQTT bridge for the given spooler.
+func NewBridge(config Config, spooler Spooler) (*Bridge, error) {
+	if config.Broker == "" {
+		return nil, errors.New("no broker specified")
+	}
+
+	if config.ClientID == "" {
+		config.ClientID = DefaultClientID
+	}
+
+	if config.Prefix == "" {
+		config.Prefix = DefaultPrefix
+	}
+
+	b := &Bridge{
+		config:   config,
+		spooler:  spooler,
+		plotters: map[string]PlotterStatus{},
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetWill(b.topic("status"), "offline", qos, true).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(b.onConnect)
+
+	b.client = paho.NewClient(opts)
+
+	return b, nil
+}
+
+// Run connects to the broker and publishes events from the bus until the
+// context is done.
+func (b *Bridge) Run(ctx context.Context, bus *events.Bus) error {
+	sub, cancel := bus.Subscribe(events.DefaultBuffer)
+	defer cancel()
+
+	if err := wait(b.client.Connect()); err != nil {
+		return fmt.Errorf("could not connect to broker %s: %w", b.config.Broker, err)
+	}
+	defer b.client.Disconnect(quiesce)
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.publish("status", "offline", true)
+			return nil
+		case event := <-sub:
+			b.handleEvent(event)
+		}
+	}
+}
+
+// onConnect subscribes to the command topic and publishes the current state.
+// It is called again after reconnecting.
+func (b *Bridge) onConnect(client paho.Client) {
+	if b.config.Commands {
+		if err := wait(client.Subscribe(b.topic("commands"), qos, b.handleCommand)); err != nil {
+			slog.Error("failed to subscribe to MQTT commands", "topic", b.topic("commands"), "error", err)
+		}
+	}
+
+	b.publish("status", "online", true)
+	b.publishQueue()
+
+	b.mu.Lock()
+	plotters := make([]PlotterStatus, 0, len(b.plotters))
+	for _, status := range b.plotters {
+		plotters = append(plotters, status)
+	}
+	b.mu.Unlock()
+
+	for _, status := range plotters {
+		b.publishPlotter(status)
+	}
+}
+
+// handleEvent publishes the event to the job's topic and updates the plotter
+// and queue status.
+func (b *Bridge) handleEvent(event v1.Event) {
+	if event.Job != nil {
+		b.publishJSON("jobs/"+segment(event.Job.ID)+"/events", event, false)
+	}
+
+	switch event.Type {
+	case v1.EventQueuePaused, v1.EventQueueResumed:
+		b.publishQueue()
+		return
+	case v1.EventPlotterOnline:
+		b.setPlotter(event.Plotter, StateIdle, "")
+	case v1.EventPlotterOffline:
+		b.setPlotter(event.Plotter, StateOffline, "")
+	case v1.EventJobStarted:
+		b.setPlotter(event.Plotter, StateBusy, event.Job.ID)
+	case v1.EventJobSucceeded, v1.EventJobFailed:
+		if b.state(event.Plotter) != StateOffline {
+			b.setPlotter(event.Plotter, StateIdle, "")
+		}
+	}
+}
+
+// handleCommand executes a command received on the command topic.
+func (b *Bridge) handleCommand(_ paho.Client, msg paho.Message) {
+	cmd := Command{}
+	if err := json.Unmarshal(msg.Payload(), &cmd); err != nil {
+		slog.Warn("invalid MQTT command", "error", err)
+		return
+	}
+
+	switch cmd.Command {
+	case CommandPause:
+		b.spooler.Pause()
+	case CommandResume, CommandStart:
+		b.spooler.Resume()
+	case CommandCancel:
+		job, err := b.spooler.DeleteJob(cmd.Job)
+		if err != nil {
+			slog.Error("failed to cancel job", "job", cmd.Job, "error", err)
+		} else if job == nil {
+			slog.Warn("failed to cancel job", "job", cmd.Job, "error", "job not found")
+		}
+	default:
+		slog.Warn("unknown MQTT command", "command", cmd.Command)
+	}
+}
+
+// state returns the last known state of the plotter.
+func (b *Bridge) state(plotter string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.plotters[plotter].State
+}
+
+// setPlotter records and publishes the plotter's status.
+func (b *Bridge) setPlotter(plotter, state, job string) {
+	if plotter == "" {
+		return
+	}
+
+	status := PlotterStatus{Plotter: plotter, State: state, Job: job, Time: time.Now()}
+
+	b.mu.Lock()
+	b.plotters[plotter] = status
+	b.mu.Unlock()
+
+	b.publishPlotter(status)
+}
+
+func (b *Bridge) publishPlotter(status PlotterStatus) {
+	b.publishJSON("plotters/"+segment(status.Plotter)+"/status", status, true)
+}
+
+func (b *Bridge) publishQueue() {
+	b.publishJSON("queue/status", v1.QueueStatus{Paused: b.spooler.Paused()}, true)
+}
+
+// publishJSON publishes the JSON-encoded payload to the topic below the prefix.
+func (b *Bridge) publishJSON(topic string, payload interface{}, retained bool) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode MQTT message", "topic", b.topic(topic), "error", err)
+		return
+	}
+	b.publish(topic, data, retained)
+}
+
+// publish publishes the payload to the topic below the prefix.
+func (b *Bridge) publish(topic string, payload interface{}, retained bool) {
+	if err := wait(b.client.Publish(b.topic(topic), qos, retained, payload)); err != nil {
+		slog.Error("failed to publish MQTT message", "topic", b.topic(topic), "error", err)
+	}
+}
+
+// topic returns the full name of the topic below the prefix.
+func (b *Bridge) topic(name string) string {
+	return strings.TrimSuffix(b.config.Prefix, "/") + "/" + name
+}
+
+// segment replaces characters that have a special meaning in topic names.
+func segment(name string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(name)
+}
+
+// wait waits for the token to complete.
+func wait(token paho.Token) error {
+	if !token.WaitTimeout(timeout) {
+		return errors.New("timed out")
+	}
+	return token.Error()
+}
@@ -0,0 +1,185 @@
+package mqtt_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/mqtt"
+	"github.com/st3v/plotq/testutil"
+)
+
+type spooler struct {
+	mu       sync.Mutex
+	paused   bool
+	canceled []string
+}
+
+func (s *spooler) DeleteJob(id string) (*v1.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.canceled = append(s.canceled, id)
+	return &v1.Job{ID: id}, nil
+}
+
+func (s *spooler) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+func (s *spooler) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+}
+
+func (s *spooler) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+func run(t *testing.T, broker *testutil.MQTTBroker, s *spooler, commands bool) *events.Bus {
+	bridge, err := mqtt.NewBridge(mqtt.Config{Broker: broker.URL, Commands: commands}, s)
+	require.NoError(t, err)
+
+	bus := events.NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		require.NoError(t, bridge.Run(ctx, bus))
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	require.Eventually(t, func() bool {
+		status, ok := broker.Retained("plotq/status")
+		return ok && string(status) == "online"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return bus
+}
+
+func plotterStatus(t *testing.T, broker *testutil.MQTTBroker, plotter string) mqtt.PlotterStatus {
+	status := mqtt.PlotterStatus{}
+	payload, ok := broker.Retained("plotq/plotters/" + plotter + "/status")
+	if ok {
+		require.NoError(t, json.Unmarshal(payload, &status))
+	}
+	return status
+}
+
+func TestPublishState(t *testing.T) {
+	broker := testutil.NewMQTTBroker(t)
+	t.Cleanup(broker.Close)
+
+	bus := run(t, broker, &spooler{}, false)
+
+	payload, ok := broker.Retained("plotq/queue/status")
+	require.True(t, ok)
+	require.JSONEq(t, `{"paused": false}`, string(payload))
+
+	job := testutil.RandJob()
+	job.Plotter = "hp7550:1337"
+
+	bus.Publish(v1.Event{Type: v1.EventJobStarted, Job: &job, Plotter: job.Plotter})
+	require.Eventually(t, func() bool {
+		status := plotterStatus(t, broker, job.Plotter)
+		return status.State == mqtt.StateBusy && status.Job == job.ID
+	}, 5*time.Second, 10*time.Millisecond)
+
+	bus.Publish(v1.Event{Type: v1.EventJobSucceeded, Job: &job, Plotter: job.Plotter})
+	require.Eventually(t, func() bool {
+		return plotterStatus(t, broker, job.Plotter).State == mqtt.StateIdle
+	}, 5*time.Second, 10*time.Millisecond)
+
+	bus.Publish(v1.Event{Type: v1.EventPlotterOffline, Plotter: job.Plotter})
+	require.Eventually(t, func() bool {
+		return plotterStatus(t, broker, job.Plotter).State == mqtt.StateOffline
+	}, 5*time.Second, 10*time.Millisecond)
+
+	events := []v1.EventType{}
+	for len(events) < 2 {
+		select {
+		case msg := <-broker.Messages:
+			if msg.Topic != "plotq/jobs/"+job.ID+"/events" {
+				continue
+			}
+			require.False(t, msg.Retained)
+
+			event := v1.Event{}
+			require.NoError(t, json.Unmarshal(msg.Payload, &event))
+			events = append(events, event.Type)
+		case <-time.After(5 * time.Second):
+			t.Fatal("job events not published")
+		}
+	}
+	require.Equal(t, []v1.EventType{v1.EventJobStarted, v1.EventJobSucceeded}, events)
+}
+
+func TestCommands(t *testing.T) {
+	broker := testutil.NewMQTTBroker(t)
+	t.Cleanup(broker.Close)
+
+	s := &spooler{}
+	run(t, broker, s, true)
+	require.Eventually(t, func() bool { return broker.Subscribed("plotq/commands") }, 5*time.Second, 10*time.Millisecond)
+
+	broker.Publish("plotq/commands", []byte(`{"command": "pause"}`))
+	require.Eventually(t, s.Paused, 5*time.Second, 10*time.Millisecond)
+
+	broker.Publish("plotq/commands", []byte(`{"command": "start"}`))
+	require.Eventually(t, func() bool { return !s.Paused() }, 5*time.Second, 10*time.Millisecond)
+
+	broker.Publish("plotq/commands", []byte(`{"command": "cancel", "job": "job"}`))
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.canceled) == 1 && s.canceled[0] == "job"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCommandsDisabled(t *testing.T) {
+	broker := testutil.NewMQTTBroker(t)
+	t.Cleanup(broker.Close)
+
+	run(t, broker, &spooler{}, false)
+	require.False(t, broker.Subscribed("plotq/commands"))
+}
+
+func TestOfflineOnShutdown(t *testing.T) {
+	broker := testutil.NewMQTTBroker(t)
+	defer broker.Close()
+
+	bridge, err := mqtt.NewBridge(mqtt.Config{Broker: broker.URL, Prefix: "space/plotq"}, &spooler{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bridge.Run(ctx, events.NewBus())
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, ok := broker.Retained("space/plotq/status")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	status, _ := broker.Retained("space/plotq/status")
+	require.Equal(t, "offline", string(status))
+}
@@ -0,0 +1,334 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/logging"
+)
+
+const (
+	// DefaultPort is the default SMTP port.
+	DefaultPort = 25
+
+	// DefaultTimeout is the default time allowed for sending a mail.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultSubject is the default template of the subject line.
+	DefaultSubject = `[plotq] Job {{.Job.ID}} {{.Reason}}`
+
+	// DefaultBody is the default template of the message body.
+	DefaultBody = `Your plot on {{.Plotter}} {{.Reason}}.
+
+Job:       {{.Job.ID}}
+Plotter:   {{.Plotter}}
+Submitted: {{.Job.SubmittedAt.Format "2006-01-02 15:04:05 MST"}}
+{{- if .Duration}}
+Duration:  {{.Duration}}
+{{- end}}
+{{- if .Job.Error}}
+
+Error: {{.Job.Error}}
+{{- end}}
+{{- if .Job.PlotterErrors}}
+
+The plotter reported:
+{{- range .Job.PlotterErrors}}
+  - {{.}}
+{{- end}}
+
+Please check the plotter before submitting the job again.
+{{- end}}
+`
+)
+
+// Reasons for sending a notification.
+const (
+	ReasonSucceeded      = "succeeded"
+	ReasonFailed         = "failed"
+	ReasonActionRequired = "needs attention"
+)
+
+// MailConfig is the configuration for email notifications.
+type MailConfig struct {
+	// Host and Port of the SMTP server. Port defaults to 25.
+	Host string
+	Port int
+
+	// Username and Password used to authenticate with the SMTP server. No
+	// authentication is attempted if Username is empty.
+	Username string
+	Password string
+
+	// From is the sender address.
+	From string
+
+	// Subject and Body are text/template templates for the message, executed
+	// with a Message. They default to DefaultSubject and DefaultBody.
+	Subject string
+	Body    string
+
+	// Timeout limits connecting to the SMTP server and sending a mail.
+	// Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Message is the data the subject and body templates are executed with.
+type Message struct {
+	// Reason is why the notification is sent, one of the Reason constants.
+	Reason string
+
+	// Job is the job the notification is about.
+	Job v1.Job
+
+	// Plotter is the network address of the job's plotter.
+	Plotter string
+
+	// Duration is the time the job took to process, zero if unknown.
+	Duration time.Duration
+}
+
+// Mailer notifies users by email when their jobs finished.
+type Mailer struct {
+	config  MailConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewMailer returns a new mailer.
+func NewMailer(config MailConfig) (*Mailer, error) {
+	if config.Host == "" {
+		return nil, errors.New("no SMTP host specified")
+	}
+
+	if config.From == "" {
+		return nil, errors.New("no sender address specified")
+	}
+
+	if config.Port == 0 {
+		config.Port = DefaultPort
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.Subject == "" {
+		config.Subject = DefaultSubject
+	}
+
+	if config.Body == "" {
+		config.Body = DefaultBody
+	}
+
+	subject, err := template.New("subject").Parse(config.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+
+	body, err := template.New("body").Parse(config.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	return &Mailer{
+		config:  config,
+		subject: subject,
+		body:    body,
+	}, nil
+}
+
+// notification is a notification waiting to be sent.
+type notification struct {
+	reason string
+	job    v1.Job
+}
+
+// Run sends notifications for jobs finishing on the bus until the context is
+// done. Mails are sent in the background so slow SMTP servers do not hold up
+// the subscription, notifications are dropped if too many are pending.
+func (m *Mailer) Run(ctx context.Context, bus *events.Bus) error {
+	sub, cancel := bus.Subscribe(events.DefaultBuffer)
+	defer cancel()
+
+	pending := make(chan notification, events.DefaultBuffer)
+	go m.send(ctx, pending)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-sub:
+			if event.Job == nil || event.Job.Notify == "" {
+				continue
+			}
+
+			reason := ""
+			switch {
+			case event.Type == v1.EventJobSucceeded:
+				reason = ReasonSucceeded
+			case event.Type == v1.EventJobFailed && len(event.Job.PlotterErrors) > 0:
+				reason = ReasonActionRequired
+			case event.Type == v1.EventJobFailed:
+				reason = ReasonFailed
+			default:
+				continue
+			}
+
+			select {
+			case pending <- notification{reason: reason, job: *event.Job}:
+			default:
+				logging.Job(*event.Job).Warn("dropped notification, too many pending", "to", event.Job.Notify)
+			}
+		}
+	}
+}
+
+// send sends the pending notifications until the context is done.
+func (m *Mailer) send(ctx context.Context, pending <-chan notification) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-pending:
+			if err := m.Notify(ctx, n.reason, n.job); err != nil {
+				logging.Job(n.job).Error("failed to send notification", "to", n.job.Notify, "error", err)
+			}
+		}
+	}
+}
+
+// Notify sends a notification for the given reason to the job's notification
+// address. Sending is aborted once the context is done or the timeout expires.
+func (m *Mailer) Notify(ctx context.Context, reason string, job v1.Job) error {
+	msg := Message{
+		Reason:  reason,
+		Job:     job,
+		Plotter: job.Plotter,
+	}
+
+	if job.StartedAt != nil && job.FinishedAt != nil {
+		msg.Duration = job.FinishedAt.Sub(*job.StartedAt).Round(time.Second)
+	}
+
+	subject := &strings.Builder{}
+	if err := m.subject.Execute(subject, msg); err != nil {
+		return fmt.Errorf("could not render subject: %w", err)
+	}
+
+	body := &strings.Builder{}
+	if err := m.body.Execute(body, msg); err != nil {
+		return fmt.Errorf("could not render body: %w", err)
+	}
+
+	mail := &bytes.Buffer{}
+	fmt.Fprintf(mail, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(mail, "To: %s\r\n", job.Notify)
+	fmt.Fprintf(mail, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(mail, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(mail, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(mail, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(mail, "\r\n")
+	mail.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+
+	if err := m.sendMail(ctx, auth, job.Notify, mail.Bytes()); err != nil {
+		return fmt.Errorf("could not send mail: %w", err)
+	}
+
+	return nil
+}
+
+// sendMail sends the message to the given address like smtp.SendMail, but
+// within the configured timeout and aborted once the context is done.
+func (m *Mailer) sendMail(ctx context.Context, auth smtp.Auth, to string, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the connection is closed to interrupt the client once the context is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	defer c.Close()
+
+	if err := m.deliver(c, auth, to, msg); err != nil {
+		return contextErr(ctx, err)
+	}
+	return nil
+}
+
+// deliver sends the message to the given address using the SMTP client.
+func (m *Mailer) deliver(c *smtp.Client, auth smtp.Auth, to string, msg []byte) error {
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("server does not support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.config.From); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// contextErr returns the context's error if it is done, as it caused err.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package notify_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/notify"
+	"github.com/st3v/plotq/testutil"
+)
+
+func newMailer(t *testing.T, server *testutil.SMTPServer) *notify.Mailer {
+	mailer, err := notify.NewMailer(notify.MailConfig{
+		Host: server.Host,
+		Port: server.Port,
+		From: "plotq@example.com",
+	})
+	require.NoError(t, err)
+	return mailer
+}
+
+func receive(t *testing.T, server *testutil.SMTPServer) testutil.SMTPMessage {
+	select {
+	case msg := <-server.Messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return testutil.SMTPMessage{}
+}
+
+func finishedJob(status v1.JobStatus) v1.Job {
+	job := testutil.RandJob()
+	job.Status = status
+	job.Notify = "alice@example.com"
+
+	started := time.Now().Add(-90 * time.Second)
+	finished := time.Now()
+	job.StartedAt = &started
+	job.FinishedAt = &finished
+
+	return job
+}
+
+func TestNotifySucceeded(t *testing.T) {
+	server := testutil.NewSMTPServer(t)
+	defer server.Close()
+
+	job := finishedJob(v1.JobStatusSucceeded)
+	require.NoError(t, newMailer(t, server).Notify(context.Background(), notify.ReasonSucceeded, job))
+
+	msg := receive(t, server)
+	require.Equal(t, "plotq@example.com", msg.From)
+	require.Equal(t, []string{"alice@example.com"}, msg.To)
+	require.Equal(t, "[plotq] Job "+job.ID+" succeeded", msg.Header.Get("Subject"))
+
+	body, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "Job:       "+job.ID)
+	require.Contains(t, string(body), "Plotter:   "+job.Plotter)
+	require.Contains(t, string(body), "Duration:  1m30s")
+}
+
+func TestNotifyCustomTemplates(t *testing.T) {
+	server := testutil.NewSMTPServer(t)
+	defer server.Close()
+
+	mailer, err := notify.NewMailer(notify.MailConfig{
+		Host:    server.Host,
+		Port:    server.Port,
+		From:    "plotq@example.com",
+		Subject: "{{.Reason}}: {{.Job.ID}}",
+		Body:    "{{.Job.Error}}",
+	})
+	require.NoError(t, err)
+
+	job := finishedJob(v1.JobStatusFailed)
+	job.Error = "connection refused"
+	require.NoError(t, mailer.Notify(context.Background(), notify.ReasonFailed, job))
+
+	msg := receive(t, server)
+	require.Equal(t, "failed: "+job.ID, msg.Header.Get("Subject"))
+
+	body, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	require.Equal(t, "connection refused", strings.TrimSpace(string(body)))
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	_, err := notify.NewMailer(notify.MailConfig{Host: "localhost", From: "plotq@example.com", Subject: "{{.Job"})
+	require.ErrorContains(t, err, "invalid subject template")
+}
+
+func TestRun(t *testing.T) {
+	server := testutil.NewSMTPServer(t)
+	defer server.Close()
+
+	bus := events.NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go newMailer(t, server).Run(ctx, bus)
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, time.Second, time.Millisecond)
+
+	// jobs without notification address and other events are ignored
+	silent := finishedJob(v1.JobStatusSucceeded)
+	silent.Notify = ""
+	bus.Publish(v1.Event{Type: v1.EventJobSucceeded, Job: &silent})
+
+	started := finishedJob(v1.JobStatusProcessing)
+	bus.Publish(v1.Event{Type: v1.EventJobStarted, Job: &started})
+
+	job := finishedJob(v1.JobStatusFailed)
+	job.Error = "plotter error"
+	job.PlotterErrors = []string{"paper not loaded"}
+	bus.Publish(v1.Event{Type: v1.EventJobFailed, Job: &job})
+
+	msg := receive(t, server)
+	require.Equal(t, "[plotq] Job "+job.ID+" needs attention", msg.Header.Get("Subject"))
+
+	body, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "  - paper not loaded")
+
+	require.Empty(t, server.Messages)
+}
+
+func TestRunSlowServer(t *testing.T) {
+	// the server accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	mailer, err := notify.NewMailer(notify.MailConfig{Host: addr.IP.String(), Port: addr.Port, From: "plotq@example.com"})
+	require.NoError(t, err)
+
+	bus := events.NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mailer.Run(ctx, bus)
+	}()
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, time.Second, time.Millisecond)
+
+	job := finishedJob(v1.JobStatusSucceeded)
+	for i := 0; i < 2*events.DefaultBuffer; i++ {
+		bus.Publish(v1.Event{Type: v1.EventJobSucceeded, Job: &job})
+	}
+
+	// the mailer keeps up with the bus and stops while a mail is stuck
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("mailer did not stop")
+	}
+}
+
+func TestNotifyStalledServer(t *testing.T) {
+	// the server accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	config := notify.MailConfig{Host: addr.IP.String(), Port: addr.Port, From: "plotq@example.com", Timeout: 100 * time.Millisecond}
+	mailer, err := notify.NewMailer(config)
+	require.NoError(t, err)
+
+	job := finishedJob(v1.JobStatusSucceeded)
+
+	// sending times out
+	err = mailer.Notify(context.Background(), notify.ReasonSucceeded, job)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// and is aborted once the context is done
+	config.Timeout = time.Minute
+	mailer, err = notify.NewMailer(config)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = mailer.Notify(ctx, notify.ReasonSucceeded, job)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,70 @@
+package plotter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// OtherPlotters is the metric label of plotters not reported by address.
+	OtherPlotters = "other"
+
+	// maxLabels is the maximum number of plotters reported by address.
+	maxLabels = 16
+)
+
+var (
+	labelsMu sync.Mutex
+	labels   = map[string]bool{}
+)
+
+// MetricLabel returns the label of the plotter with the given address in
+// metrics. Plotter addresses are chosen by users, so plotters are only reported
+// by address once a connection to them succeeded, and only up to a fixed number
+// of them. All others are reported as OtherPlotters.
+func MetricLabel(addr string) string {
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+
+	if labels[addr] {
+		return addr
+	}
+	return OtherPlotters
+}
+
+// addLabel reports the plotter with the given address by address from now on,
+// unless the maximum number of labels has been reached.
+func addLabel(addr string) {
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+
+	if len(labels) < maxLabels {
+		labels[addr] = true
+	}
+}
+
+var (
+	ackLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "plotq",
+		Subsystem: "plotter",
+		Name:      "ack_latency_seconds",
+		Help:      "Time between sending a chunk to the PlotterFeeder and receiving its ack.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"plotter"})
+
+	bytesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "plotq",
+		Subsystem: "plotter",
+		Name:      "bytes_sent_total",
+		Help:      "Bytes of HPGL acked by the PlotterFeeder.",
+	}, []string{"plotter"})
+
+	connectionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "plotq",
+		Subsystem: "plotter",
+		Name:      "connection_errors_total",
+		Help:      "Failed attempts to connect to the PlotterFeeder.",
+	}, []string{"plotter"})
+)
@@ -1,11 +1,20 @@
 package plotter
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/st3v/plotq/tracing"
 )
 
 const (
@@ -17,8 +26,14 @@ const (
 
 	// ack is the expected ack message from the PlotterFeeder
 	ack = "OK"
+
+	// park terminates any instruction cut off by an aborted plot, lifts the pen
+	// and puts it back into its stall.
+	park = ";PU;SP0;"
 )
 
+var tracer = otel.Tracer("github.com/st3v/plotq/plotter")
+
 // Option is a configuration option .
 type ConnOption func(*connOptions)
 
@@ -29,10 +44,49 @@ func WithTimeout(d time.Duration) ConnOption {
 	}
 }
 
+// WithBidirectional enables status queries for transports that forward the
+// plotter's responses back to us.
+func WithBidirectional() ConnOption {
+	return func(c *connOptions) {
+		c.bidirectional = true
+	}
+}
+
+// WithProgress sets a function that is called after every chunk acked by the
+// PlotterFeeder with the number of bytes sent so far and the total number of
+// bytes to send.
+func WithProgress(fn func(sent, total int)) ConnOption {
+	return func(c *connOptions) {
+		c.progress = fn
+	}
+}
+
+// WithLogger sets the logger used for the connection. By default the default
+// logger is used with the plotter's address attached.
+func WithLogger(logger *slog.Logger) ConnOption {
+	return func(c *connOptions) {
+		c.logger = logger
+	}
+}
+
+// WithContext sets the context that spans of the connection are recorded in.
+// Writes are aborted once the context is done.
+func WithContext(ctx context.Context) ConnOption {
+	return func(c *connOptions) {
+		c.ctx = ctx
+	}
+}
+
 // Conn represents a connection to a PlotterFeeder.
 type Conn struct {
-	conn    net.Conn
-	timeout time.Duration
+	ctx           context.Context
+	addr          string
+	logger        *slog.Logger
+	conn          net.Conn
+	reader        *bufio.Reader
+	timeout       time.Duration
+	bidirectional bool
+	progress      func(sent, total int)
 }
 
 // feed implements io.WriteCloser.
@@ -40,41 +94,91 @@ var _ io.WriteCloser = &Conn{}
 
 // connOptions is the configuration for a connection.
 type connOptions struct {
-	timeout time.Duration
+	ctx           context.Context
+	logger        *slog.Logger
+	timeout       time.Duration
+	bidirectional bool
+	progress      func(sent, total int)
 }
 
 // Connect creates a new connection to a PlotterFeeder.
 // See https://github.com/xHain-hackspace/PlotterFeeder
 func Connect(addr string, opts ...ConnOption) (*Conn, error) {
+	cfg := config(opts)
+
+	_, span := tracer.Start(cfg.ctx, "plotter.Connect", trace.WithAttributes(attribute.String("plotter", addr)))
+	defer span.End()
+
 	server, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("could not resolve address %s: %w", server, err)
+		connectionErrors.WithLabelValues(MetricLabel(addr)).Inc()
+		err = fmt.Errorf("could not resolve address %s: %w", server, err)
+		tracing.Fail(span, err)
+		return nil, err
 	}
 
 	conn, err := net.DialTCP("tcp", nil, server)
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to %s: %w", addr, err)
+		connectionErrors.WithLabelValues(MetricLabel(addr)).Inc()
+		err = fmt.Errorf("could not connect to %s: %w", addr, err)
+		tracing.Fail(span, err)
+		return nil, err
 	}
 
+	addLabel(addr)
+
+	if cfg.logger == nil {
+		cfg.logger = slog.With("plotter", addr)
+	}
+	cfg.logger.Debug("connected to plotter")
+
 	return &Conn{
-		conn:    conn,
-		timeout: config(opts).timeout,
+		ctx:           cfg.ctx,
+		addr:          addr,
+		logger:        cfg.logger,
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		timeout:       cfg.timeout,
+		bidirectional: cfg.bidirectional,
+		progress:      cfg.progress,
 	}, nil
 }
 
 // Close closes the connection to the PlotterFeeder.
 func (c *Conn) Close() error {
+	c.logger.Debug("closing connection to plotter")
 	return c.conn.Close()
 }
 
 // Plot sends the given HPGL data to the PlotterFeeder server.
-func (c *Conn) Write(hpgl []byte) (int, error) {
-	var err error
-	reader := bytes.NewReader(hpgl)
-	total := 0
+func (c *Conn) Write(hpgl []byte) (total int, err error) {
+	return c.Send(bytes.NewReader(hpgl), len(hpgl))
+}
+
+// Send sends the HPGL read from r to the plotter in chunks and waits for the
+// PlotterFeeder to ack each of them. Size is the number of bytes expected to be
+// read from r and is reported as the total to the progress function.
+func (c *Conn) Send(r io.Reader, size int) (total int, err error) {
+	_, span := tracer.Start(c.ctx, "plotter.Write", trace.WithAttributes(
+		attribute.String("plotter", c.addr),
+		attribute.Int("hpgl.bytes", size),
+	))
+	chunks := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("plotter.bytes_sent", total), attribute.Int("plotter.chunks", chunks))
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
 	for err != io.EOF {
+		if err := c.ctx.Err(); err != nil {
+			return total, fmt.Errorf("plot aborted: %w", err)
+		}
+
 		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
-		n, err := io.CopyN(c.conn, reader, buflen)
+		n, err := io.CopyN(c.conn, r, buflen)
 		if err != nil && err != io.EOF {
 			return total, fmt.Errorf("could not copy to server: %w", err)
 		} else if n == 0 {
@@ -82,26 +186,54 @@ func (c *Conn) Write(hpgl []byte) (int, error) {
 		}
 
 		total += int(n)
+		chunks++
 
-		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
-		buf := make([]byte, len(ack))
-		_, rerr := c.conn.Read(buf)
-		if rerr != nil {
-			return total, fmt.Errorf("could not read from sever: %w", rerr)
+		sent := time.Now()
+		if err := c.readAck(); err != nil {
+			return total, c.withPlotterErrors(err)
 		}
+		label := MetricLabel(c.addr)
+		ackLatency.WithLabelValues(label).Observe(time.Since(sent).Seconds())
+		bytesSent.WithLabelValues(label).Add(float64(n))
 
-		got := string(buf)
-		if got != ack {
-			return total, fmt.Errorf("server did not ack with %s but %s", ack, got)
+		if c.progress != nil {
+			c.progress(total, size)
 		}
 	}
 
 	return total, nil
 }
 
+// Park lifts the pen and puts it back, e.g. after a plot has been aborted.
+func (c *Conn) Park() error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write([]byte(park)); err != nil {
+		return fmt.Errorf("could not send %q: %w", park, err)
+	}
+	return c.readAck()
+}
+
+// readAck reads the ack sent by the PlotterFeeder for every chunk.
+func (c *Conn) readAck() error {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	buf := make([]byte, len(ack))
+	_, err := io.ReadFull(c.reader, buf)
+	if err != nil {
+		return fmt.Errorf("could not read from sever: %w", err)
+	}
+
+	got := string(buf)
+	if got != ack {
+		return fmt.Errorf("server did not ack with %s but %s", ack, got)
+	}
+
+	return nil
+}
+
 // config creates new connOptions
 func config(opts []ConnOption) *connOptions {
 	c := &connOptions{
+		ctx:     context.Background(),
 		timeout: defaultTimeout,
 	}
 
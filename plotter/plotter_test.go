@@ -1,10 +1,15 @@
 package plotter_test
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/st3v/plotq/plotter"
 	"github.com/st3v/plotq/testutil"
 	"github.com/stretchr/testify/require"
 )
@@ -41,6 +46,104 @@ func TestPlotterWriteLarge(t *testing.T) {
 	require.Equal(t, len(payload), n)
 }
 
+func TestPlotterWriteProgress(t *testing.T) {
+	payload := make([]byte, 1024*1024)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	server := testutil.NewTestServer(t, payload)
+	defer server.Close()
+
+	var sent []int
+	conn := server.MustConnect(plotter.WithProgress(func(n, total int) {
+		require.Equal(t, len(payload), total)
+		sent = append(sent, n)
+	}))
+	defer conn.Close()
+
+	_, err = conn.Write(payload)
+	require.NoError(t, err)
+
+	require.Greater(t, len(sent), 1)
+	require.IsIncreasing(t, sent)
+	require.Equal(t, len(payload), sent[len(sent)-1])
+}
+
+func TestPlotterWriteAbortAndPark(t *testing.T) {
+	payload := make([]byte, 1024)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	// the first chunk is sent before the plot is aborted
+	server := testutil.NewTestServer(t, append(payload[:254:254], ";PU;SP0;"...))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn := server.MustConnect(plotter.WithContext(ctx), plotter.WithProgress(func(_, _ int) { cancel() }))
+	defer conn.Close()
+
+	n, err := conn.Write(payload)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 254, n)
+
+	require.NoError(t, conn.Park())
+}
+
+func TestPlotterWriteMetrics(t *testing.T) {
+	server := testutil.NewTestServer(t, hpgl)
+	defer server.Close()
+
+	// all test servers share the same address
+	sent := metric(t, "plotq_plotter_bytes_sent_total", server.Addr()).GetCounter().GetValue()
+	acks := metric(t, "plotq_plotter_ack_latency_seconds", server.Addr()).GetHistogram().GetSampleCount()
+
+	conn := server.MustConnect()
+	defer conn.Close()
+
+	_, err := conn.Write(hpgl)
+	require.NoError(t, err)
+
+	require.Equal(t, sent+float64(len(hpgl)), metric(t, "plotq_plotter_bytes_sent_total", server.Addr()).GetCounter().GetValue())
+	require.Equal(t, acks+1, metric(t, "plotq_plotter_ack_latency_seconds", server.Addr()).GetHistogram().GetSampleCount())
+}
+
+func TestPlotterConnectMetrics(t *testing.T) {
+	// plotters that could never be reached are not reported by address
+	addr := "localhost:1"
+	failures := metric(t, "plotq_plotter_connection_errors_total", plotter.OtherPlotters).GetCounter().GetValue()
+
+	_, err := plotter.Connect(addr)
+	require.Error(t, err)
+
+	require.Equal(t, failures+1, metric(t, "plotq_plotter_connection_errors_total", plotter.OtherPlotters).GetCounter().GetValue())
+	require.Equal(t, plotter.OtherPlotters, plotter.MetricLabel(addr))
+}
+
+// metric returns the metric with the given name for the plotter or an empty
+// metric if it has not been recorded yet.
+func metric(t *testing.T, name, plotter string) *dto.Metric {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "plotter" && label.GetValue() == plotter {
+					return m
+				}
+			}
+		}
+	}
+
+	return &dto.Metric{}
+}
+
 func TestPlotterWriteEmpty(t *testing.T) {
 	payload := make([]byte, 0)
 
@@ -80,3 +183,76 @@ func TestPlotterTimeout(t *testing.T) {
 	require.ErrorContains(t, err, "timeout")
 	require.Equal(t, len(hpgl), n)
 }
+
+func TestPlotterCheck(t *testing.T) {
+	server := testutil.NewTestServer(t, hpgl)
+	server.Responses = map[string]string{"\x1b.E": "0", "OE;": "0", "OS;": "24"}
+	defer server.Close()
+
+	conn := server.MustConnect(plotter.WithBidirectional())
+	defer conn.Close()
+
+	n, err := conn.Write(hpgl)
+	require.NoError(t, err)
+	require.Equal(t, len(hpgl), n)
+
+	require.NoError(t, conn.Check())
+}
+
+func TestPlotterCheckReportsErrors(t *testing.T) {
+	server := testutil.NewTestServer(t, hpgl)
+	server.Responses = map[string]string{"\x1b.E": "15", "OE;": "6", "OS;": "40"}
+	defer server.Close()
+
+	conn := server.MustConnect(plotter.WithBidirectional())
+	defer conn.Close()
+
+	n, err := conn.Write(hpgl)
+	require.NoError(t, err)
+	require.Equal(t, len(hpgl), n)
+
+	err = conn.Check()
+	plotterErr := &plotter.Error{}
+	require.True(t, errors.As(err, &plotterErr))
+	require.Equal(t, []string{
+		"framing, parity or overrun error",
+		"position overflow",
+		"not ready for data, paper not loaded or lever raised",
+	}, plotterErr.Messages)
+}
+
+func TestPlotterCheckUnidirectional(t *testing.T) {
+	server := testutil.NewTestServer(t, hpgl)
+	defer server.Close()
+
+	conn := server.MustConnect()
+	defer conn.Close()
+
+	n, err := conn.Write(hpgl)
+	require.NoError(t, err)
+	require.Equal(t, len(hpgl), n)
+
+	require.NoError(t, conn.Check())
+
+	_, err = conn.QueryErrors()
+	require.ErrorIs(t, err, plotter.ErrNotBidirectional)
+}
+
+func TestPlotterInvalidAckQueriesErrors(t *testing.T) {
+	server := testutil.NewTestServer(t, hpgl)
+	server.Ack = "NO"
+	server.Responses = map[string]string{"\x1b.E": "0", "OE;": "1", "OS;": "56"}
+	defer server.Close()
+
+	conn := server.MustConnect(plotter.WithBidirectional())
+	defer conn.Close()
+
+	n, err := conn.Write(hpgl)
+	require.ErrorContains(t, err, "did not ack with OK but NO")
+	require.ErrorContains(t, err, "instruction not recognized")
+	require.Equal(t, len(hpgl), n)
+
+	plotterErr := &plotter.Error{}
+	require.True(t, errors.As(err, &plotterErr))
+	require.Equal(t, []string{"instruction not recognized"}, plotterErr.Messages)
+}
@@ -0,0 +1,208 @@
+package plotter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// queryTimeout is the timeout for status queries sent after a failure.
+	queryTimeout = 5 * time.Second
+
+	// queryTerminator terminates every response the plotter sends to an output instruction.
+	queryTerminator = '\r'
+)
+
+// ErrNotBidirectional is returned when querying the plotter over a transport that
+// does not forward the plotter's responses.
+var ErrNotBidirectional = errors.New("transport is not bidirectional")
+
+// Error is returned when the plotter reports one or more error conditions.
+type Error struct {
+	// Err is the error that caused the plotter to be queried, if any.
+	Err error
+
+	// Messages are the decoded error conditions reported by the plotter.
+	Messages []string
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	msg := strings.Join(e.Messages, "; ")
+	if e.Err == nil {
+		return fmt.Sprintf("plotter reported: %s", msg)
+	}
+	return fmt.Sprintf("%v: plotter reported: %s", e.Err, msg)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// hpglErrors maps the HPGL error numbers returned by OE to readable messages.
+var hpglErrors = map[int]string{
+	1: "instruction not recognized",
+	2: "wrong number of parameters",
+	3: "parameter out of range",
+	4: "illegal character",
+	5: "unknown character set",
+	6: "position overflow",
+	7: "buffer overflow",
+}
+
+// ioErrors maps the extended error numbers returned by ESC.E to readable messages.
+var ioErrors = map[int]string{
+	10: "output instruction received while another output instruction is executing",
+	11: "invalid byte received after escape sequence",
+	12: "invalid byte received while parsing device control instruction",
+	13: "device control parameter out of range",
+	14: "too many device control parameters received",
+	15: "framing, parity or overrun error",
+	16: "input buffer overflow",
+}
+
+// Output status bits returned by OS.
+const (
+	statusReady      = 1 << 4
+	statusServiceReq = 1 << 6
+)
+
+// Check queries the plotter for error conditions and returns an *Error if any are
+// reported. It returns nil without querying if the transport is not bidirectional.
+func (c *Conn) Check() error {
+	if !c.bidirectional {
+		return nil
+	}
+
+	msgs, err := c.QueryErrors()
+	if err != nil {
+		return fmt.Errorf("could not query plotter status: %w", err)
+	}
+
+	if len(msgs) > 0 {
+		return &Error{Messages: msgs}
+	}
+
+	return nil
+}
+
+// QueryErrors queries the plotter's extended I/O error (ESC.E), HPGL error (OE)
+// and output status (OS) and returns a readable message for every problem reported.
+func (c *Conn) QueryErrors() ([]string, error) {
+	if !c.bidirectional {
+		return nil, ErrNotBidirectional
+	}
+
+	msgs := []string{}
+
+	code, err := c.query("\x1b.E")
+	if err != nil {
+		return msgs, err
+	}
+	msgs = append(msgs, decodeIOError(code)...)
+
+	code, err = c.query("OE;")
+	if err != nil {
+		return msgs, err
+	}
+	msgs = append(msgs, decodeHPGLError(code)...)
+
+	code, err = c.query("OS;")
+	if err != nil {
+		return msgs, err
+	}
+	msgs = append(msgs, decodeStatus(code)...)
+
+	return msgs, nil
+}
+
+// withPlotterErrors queries the plotter after err occurred and wraps err with the
+// reported errors. It returns err unchanged if nothing could be learned.
+func (c *Conn) withPlotterErrors(err error) error {
+	if !c.bidirectional {
+		return err
+	}
+
+	timeout := c.timeout
+	c.timeout = queryTimeout
+	defer func() { c.timeout = timeout }()
+
+	msgs, _ := c.QueryErrors()
+	if len(msgs) == 0 {
+		return err
+	}
+
+	c.logger.Warn("plotter reported errors", "errors", msgs)
+
+	return &Error{Err: err, Messages: msgs}
+}
+
+// query sends an output instruction and returns the number the plotter responds with.
+func (c *Conn) query(instruction string) (int, error) {
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write([]byte(instruction)); err != nil {
+		return 0, fmt.Errorf("could not send %q: %w", instruction, err)
+	}
+
+	if err := c.readAck(); err != nil {
+		return 0, err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	resp, err := c.reader.ReadString(queryTerminator)
+	if err != nil {
+		return 0, fmt.Errorf("could not read response to %q: %w", instruction, err)
+	}
+
+	code, err := strconv.Atoi(strings.TrimSpace(resp))
+	if err != nil {
+		return 0, fmt.Errorf("invalid response to %q: %q", instruction, resp)
+	}
+
+	return code, nil
+}
+
+// decodeHPGLError decodes the response to OE.
+func decodeHPGLError(code int) []string {
+	if code == 0 {
+		return nil
+	}
+
+	if msg, ok := hpglErrors[code]; ok {
+		return []string{msg}
+	}
+
+	return []string{fmt.Sprintf("unknown HPGL error %d", code)}
+}
+
+// decodeIOError decodes the response to ESC.E.
+func decodeIOError(code int) []string {
+	if code == 0 {
+		return nil
+	}
+
+	if msg, ok := ioErrors[code]; ok {
+		return []string{msg}
+	}
+
+	return []string{fmt.Sprintf("unknown I/O error %d", code)}
+}
+
+// decodeStatus decodes the response to OS. Only bits indicating a problem are reported.
+func decodeStatus(status int) []string {
+	msgs := []string{}
+
+	if status&statusReady == 0 {
+		msgs = append(msgs, "not ready for data, paper not loaded or lever raised")
+	}
+
+	if status&statusServiceReq != 0 {
+		msgs = append(msgs, "service requested")
+	}
+
+	return msgs
+}
@@ -0,0 +1,75 @@
+package spooler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/plotter"
+)
+
+var (
+	queueDepth = prometheus.NewDesc(
+		"plotq_spooler_queue_depth",
+		"Jobs waiting to be processed by plotter.",
+		[]string{"plotter"}, nil,
+	)
+
+	jobsCanceled = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "plotq",
+		Subsystem: "spooler",
+		Name:      "jobs_canceled_total",
+		Help:      "Jobs canceled before they were processed.",
+	})
+)
+
+// spooler implements prometheus.Collector
+var _ prometheus.Collector = &spooler{}
+
+// Describe implements prometheus.Collector.
+func (s *spooler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepth
+}
+
+// Collect implements prometheus.Collector. The queue depth is tracked as jobs
+// are queued and dequeued, plotters are labeled by plotter.MetricLabel.
+func (s *spooler) Collect(ch chan<- prometheus.Metric) {
+	depth := map[string]int{}
+
+	s.mu.Lock()
+	for _, addr := range s.pending {
+		depth[plotter.MetricLabel(addr)]++
+	}
+	s.mu.Unlock()
+
+	for label, n := range depth {
+		ch <- prometheus.MustNewConstMetric(queueDepth, prometheus.GaugeValue, float64(n), label)
+	}
+}
+
+// countPending counts the pending jobs in the queue towards the queue depth.
+func (s *spooler) countPending() error {
+	jobs, err := s.queue.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.Status == v1.JobStatusPending {
+			s.setPending(job, true)
+		}
+	}
+	return nil
+}
+
+// setPending adds the job to or removes it from the queue depth.
+func (s *spooler) setPending(job v1.Job, pending bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pending {
+		s.pending[job.ID] = job.Plotter
+	} else {
+		delete(s.pending, job.ID)
+	}
+}
@@ -4,30 +4,81 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
 	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	v1 "github.com/st3v/plotq/api/v1"
 	"github.com/st3v/plotq/converter"
+	"github.com/st3v/plotq/events"
 	"github.com/st3v/plotq/filestore"
 	"github.com/st3v/plotq/jobqueue"
+	"github.com/st3v/plotq/logging"
 	"github.com/st3v/plotq/plotter"
+	"github.com/st3v/plotq/tracing"
 )
 
 const (
-	// DefaultTick is the default tick duration for the spooler to check for the next job to be processed.
-	DefaultTick = 1 * time.Second
-
 	// DefaultTimeout is the default timeout for connections to the plotter.
 	DefaultTimeout = time.Minute
+
+	// progressInterval is the minimum interval between two progress events of a job.
+	progressInterval = time.Second
+
+	// retryInterval is the time waited before dequeuing again after a failure.
+	retryInterval = time.Second
 )
 
+var tracer = otel.Tracer("github.com/st3v/plotq/spooler")
+
 type spooler struct {
-	queue   jobqueue.Queue
-	store   filestore.Store
-	convert converter.Convert
-	tick    time.Duration
+	queue       jobqueue.Queue
+	store       filestore.Store
+	blobs       *filestore.ContentStore
+	convert     converter.Convert
+	plotterOpts []plotter.ConnOption
+	events      *events.Bus
+
+	mu       sync.Mutex        // guards online, pending, paused, draining and wake
+	online   map[string]bool   // plotters by address that were reachable on last connect
+	pending  map[string]string // plotters of the pending jobs by job ID
+	paused   bool
+	draining bool
+	wake     chan struct{} // closed to wake up workers waiting for the next job
+}
+
+// ErrDraining is returned for job requests submitted after Drain.
+var ErrDraining = errors.New("spooler is shutting down")
+
+// ErrNotConverted is returned for the HPGL of jobs that have not been converted yet.
+var ErrNotConverted = errors.New("job has not been converted yet")
+
+// ErrSVGNotFound is returned when resubmitting a job whose SVG has been removed.
+var ErrSVGNotFound = errors.New("SVG of the job not found")
+
+// Option is an option for the spooler.
+type Option func(*spooler)
+
+// PlotterOptions sets additional options used when connecting to plotters.
+func PlotterOptions(opts ...plotter.ConnOption) Option {
+	return func(s *spooler) {
+		s.plotterOpts = append(s.plotterOpts, opts...)
+	}
+}
+
+// WithEventBus publishes job and plotter events to the given bus.
+func WithEventBus(bus *events.Bus) Option {
+	return func(s *spooler) {
+		s.events = bus
+	}
 }
 
 func init() {
@@ -35,35 +86,68 @@ func init() {
 }
 
 // NewSpooler creates a new job spooler.
-func NewSpooler(queue jobqueue.Queue, svgStore filestore.Store, convert converter.Convert) *spooler {
-	return &spooler{
-		queue:   queue,
-		store:   svgStore,
-		convert: convert,
-		tick:    DefaultTick,
+func NewSpooler(queue jobqueue.Queue, svgStore filestore.Store, convert converter.Convert, opts ...Option) *spooler {
+	s := &spooler{
+		queue:       queue,
+		store:       svgStore,
+		blobs:       filestore.NewContentStore(svgStore),
+		convert:     convert,
+		plotterOpts: []plotter.ConnOption{plotter.WithTimeout(DefaultTimeout)},
+		online:      map[string]bool{},
+		pending:     map[string]string{},
+		wake:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	if err := s.countPending(); err != nil {
+		slog.Error("failed to count pending jobs", "error", err)
+	}
+
+	return s
 }
 
-// SubmitRequest submits a new job request to the queue.
-func (s *spooler) SubmitRequest(request *v1.JobRequest) (*v1.Job, error) {
+// SubmitRequest submits a new job request to the queue. The trace context of
+// ctx is stored with the job so processing can be linked to the request.
+func (s *spooler) SubmitRequest(ctx context.Context, request *v1.JobRequest) (job *v1.Job, err error) {
+	ctx, span := tracer.Start(ctx, "spooler.SubmitRequest", trace.WithAttributes(
+		attribute.String("plotter", request.Plotter),
+		attribute.String("user", request.User),
+	))
+	defer func() {
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
+	if s.Draining() {
+		return nil, ErrDraining
+	}
+
 	if err := request.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid request: %w", err)
 	}
 
 	request.SetDefaults()
 
-	id := newID()
-	path, err := s.storeSVG(id, request)
+	sum, err := s.storeSVG(ctx, request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to store SVG: %w", err)
 	}
 
-	job := &v1.Job{
-		ID:          id,
-		SVG:         path,
+	job = &v1.Job{
+		ID:          newID(),
+		SVG:         sum,
+		Filename:    request.SVG.Filename,
+		SVGHash:     sum,
 		Plotter:     request.Plotter,
 		User:        request.User,
+		Notify:      request.Notify,
 		Status:      v1.JobStatusPending,
+		Priority:    request.Priority,
 		SubmittedAt: time.Now(),
 		Settings: v1.JobSettings{
 			Pagesize:    request.Pagesize,
@@ -71,102 +155,625 @@ func (s *spooler) SubmitRequest(request *v1.JobRequest) (*v1.Job, error) {
 			Orientation: request.Orientation,
 			Device:      request.Device,
 		},
+		TraceContext: tracing.Inject(ctx),
 	}
 
-	if err := s.queue.Enqueue(job); err != nil {
-		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	span.SetAttributes(attribute.String("job", job.ID))
+
+	if err := s.submit(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ResubmitJob submits a new job plotting the SVG of the job with the given ID.
+// The job keeps the settings of the previous job unless they are overridden by
+// the request, its priority is taken from the request only. It returns nil if
+// there is no job with the given ID.
+func (s *spooler) ResubmitJob(ctx context.Context, id string, request *v1.ResubmitRequest) (job *v1.Job, err error) {
+	ctx, span := tracer.Start(ctx, "spooler.ResubmitJob", trace.WithAttributes(
+		attribute.String("parent", id),
+	))
+	defer func() {
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
+	if s.Draining() {
+		return nil, ErrDraining
+	}
+
+	if err := request.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	parent, err := s.queue.Get(id)
+	if err != nil || parent == nil {
+		return nil, err
+	}
+
+	sum, err := s.retainSVG(*parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retain SVG: %w", err)
+	}
+
+	job = &v1.Job{
+		ID:           newID(),
+		SVG:          sum,
+		Filename:     parent.Filename,
+		SVGHash:      sum,
+		Plotter:      parent.Plotter,
+		User:         parent.User,
+		Notify:       parent.Notify,
+		Status:       v1.JobStatusPending,
+		Priority:     request.Priority,
+		Parent:       parent.ID,
+		SubmittedAt:  time.Now(),
+		Settings:     parent.Settings,
+		TraceContext: tracing.Inject(ctx),
+	}
+
+	// the previous job's address is only notified about jobs of the same user
+	if request.User != "" && request.User != parent.User {
+		job.User = request.User
+		job.Notify = ""
+	}
+	if request.Notify != "" {
+		job.Notify = request.Notify
+	}
+	if request.Plotter != "" {
+		job.Plotter = request.Plotter
+	}
+	if request.Device != "" {
+		job.Settings.Device = request.Device
+	}
+	if request.Pagesize != "" {
+		job.Settings.Pagesize = request.Pagesize
+	}
+	if request.Orientation != "" {
+		job.Settings.Orientation = request.Orientation
+	}
+	if request.Velocity != 0 {
+		job.Settings.Velocity = request.Velocity
+	}
+
+	span.SetAttributes(
+		attribute.String("job", job.ID),
+		attribute.String("plotter", job.Plotter),
+		attribute.String("user", job.User),
+	)
+
+	if err := s.submit(job); err != nil {
+		return nil, err
 	}
 
 	return job, nil
 }
 
-// GetJobs returns all jobs currently waiting to be processed.
+// submit adds the given new job to the queue, reusing the HPGL of a previous
+// job if possible, and wakes up waiting workers. The job's files are released
+// if it cannot be enqueued.
+func (s *spooler) submit(job *v1.Job) error {
+	logger := logging.Job(*job)
+
+	if err := s.reuseHPGL(job); err != nil {
+		logger.Warn("failed to reuse converted HPGL", "error", err)
+	}
+
+	if err := s.queue.Enqueue(job); err != nil {
+		for _, sum := range []string{job.SVGHash, job.HPGL} {
+			if sum == "" {
+				continue
+			}
+			if err := s.blobs.Release(sum); err != nil {
+				logger.Warn("failed to release file of rejected job", "sum", sum, "error", err)
+			}
+		}
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	s.setPending(*job, true)
+	s.notify()
+
+	logger.Info("job submitted", "svg", job.SVGHash, "reused_hpgl", job.HPGL != "", "parent", job.Parent)
+
+	s.publishJob(v1.EventJobSubmitted, *job)
+
+	return nil
+}
+
+// reuseHPGL looks for a previous job with the same SVG and settings that has
+// already been converted and references its HPGL from the given job.
+func (s *spooler) reuseHPGL(job *v1.Job) error {
+	jobs, err := s.queue.Converted(job.SVGHash, job.Settings)
+	if err != nil {
+		return err
+	}
+
+	for _, prev := range jobs {
+		err := s.blobs.Retain(prev.HPGL)
+		if errors.Is(err, filestore.ErrBlobNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		job.HPGL = prev.HPGL
+		return nil
+	}
+
+	return nil
+}
+
+// GetJobs returns all jobs waiting to be processed followed by the jobs already processed.
 func (s *spooler) GetJobs() ([]v1.Job, error) {
 	return s.queue.GetAll()
 }
 
-// GetJob returns the job with the given ID from the queue of all jobs waiting to be processed.
+// ListJobs returns a page of the jobs matching the given query. The limit of
+// the query is capped at v1.MaxListLimit.
+func (s *spooler) ListJobs(query v1.JobQuery) (*v1.JobList, error) {
+	if query.Limit <= 0 {
+		query.Limit = v1.DefaultListLimit
+	}
+	if query.Limit > v1.MaxListLimit {
+		query.Limit = v1.MaxListLimit
+	}
+	return s.queue.List(query)
+}
+
+// GetJob returns the job with the given ID.
 func (s *spooler) GetJob(id string) (*v1.Job, error) {
 	return s.queue.Get(id)
 }
 
+// GetPlotters returns the plotters with pending or processing jobs and those the
+// spooler has connected to, sorted by address. A plotter's status is unknown
+// until the spooler has connected to it.
+func (s *spooler) GetPlotters() ([]v1.Plotter, error) {
+	processing := []v1.Job{}
+	query := v1.JobQuery{Status: v1.JobStatusProcessing, Limit: v1.MaxListLimit}
+	for {
+		list, err := s.queue.List(query)
+		if err != nil {
+			return nil, err
+		}
+
+		processing = append(processing, list.Jobs...)
+		if list.Next == "" {
+			break
+		}
+		query.Cursor = list.Next
+	}
+
+	plotters := map[string]*v1.Plotter{}
+	entry := func(addr string) *v1.Plotter {
+		p, ok := plotters[addr]
+		if !ok {
+			p = &v1.Plotter{Address: addr, Status: v1.PlotterStatusUnknown}
+			plotters[addr] = p
+		}
+		return p
+	}
+
+	for _, job := range processing {
+		entry(job.Plotter).Processing = job.ID
+	}
+
+	s.mu.Lock()
+	for _, addr := range s.pending {
+		entry(addr).Pending++
+	}
+	for addr, online := range s.online {
+		p := entry(addr)
+		p.Status = v1.PlotterStatusOffline
+		if online {
+			p.Status = v1.PlotterStatusOnline
+		}
+	}
+	s.mu.Unlock()
+
+	result := make([]v1.Plotter, 0, len(plotters))
+	for _, p := range plotters {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Address < result[j].Address })
+
+	return result, nil
+}
+
+// GetSVG returns the SVG file submitted with the job.
+func (s *spooler) GetSVG(job v1.Job) (io.ReadCloser, error) {
+	return s.getSVG(&job)
+}
+
+// GetHPGL returns the HPGL the job's SVG has been converted to. It returns
+// ErrNotConverted if the job has not been converted yet.
+func (s *spooler) GetHPGL(job v1.Job) (io.ReadCloser, error) {
+	if job.HPGL == "" {
+		return nil, ErrNotConverted
+	}
+	return s.blobs.Get(job.HPGL)
+}
+
+// UpdateJob persists the given job.
+func (s *spooler) UpdateJob(job v1.Job) error {
+	return s.queue.Update(&job)
+}
+
 // DeleteJob deletes the job with the given ID.
 func (s *spooler) DeleteJob(id string) (*v1.Job, error) {
-	return s.queue.Cancel(id)
+	job, err := s.queue.Cancel(id)
+	if err == nil && job != nil {
+		s.setPending(*job, false)
+		jobsCanceled.Inc()
+		s.publishJob(v1.EventJobCanceled, *job)
+	}
+	return job, err
 }
 
-// Incoming returns a channel that receives jobs as they move to the front of the queue.
-func (s *spooler) Incoming(ctx context.Context) <-chan v1.Job {
-	jobs := make(chan v1.Job, 0)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				close(jobs)
-				return
-			case <-time.After(s.tick):
-				job, err := s.queue.Dequeue()
-				if err == jobqueue.ErrQueueEmpty {
-					continue
-				} else if err != nil {
-					log.Printf("failed to peek job: %v", err)
-					continue
-				}
-				jobs <- *job
+// RestoreJob puts the canceled job with the given ID back into the queue.
+func (s *spooler) RestoreJob(id string) (*v1.Job, error) {
+	job, err := s.queue.Restore(id)
+	if err == nil && job != nil {
+		s.setPending(*job, true)
+		s.notify()
+		s.publishJob(v1.EventJobRestored, *job)
+	}
+	return job, err
+}
+
+// RemoveJob removes the record of the given job along with its files.
+// Jobs that are still queued are not removed.
+func (s *spooler) RemoveJob(job v1.Job) error {
+	if err := s.queue.Delete(job.ID); err != nil {
+		return err
+	}
+
+	if job.SVGHash == "" {
+		// jobs submitted before the content store was introduced
+		if err := s.store.Delete(job.SVG); err != nil {
+			return fmt.Errorf("failed to delete SVG: %w", err)
+		}
+	} else if err := s.blobs.Release(job.SVGHash); err != nil {
+		return fmt.Errorf("failed to release SVG: %w", err)
+	}
+
+	if job.HPGL != "" {
+		if err := s.blobs.Release(job.HPGL); err != nil {
+			return fmt.Errorf("failed to release HPGL: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Pause stops handing out queued jobs. The job currently being processed is not affected.
+// The paused state is kept in memory only, a restarted spooler is not paused.
+func (s *spooler) Pause() {
+	s.setPaused(true)
+}
+
+// Resume continues handing out queued jobs after Pause.
+func (s *spooler) Resume() {
+	s.setPaused(false)
+}
+
+// Paused returns whether the spooler is paused.
+func (s *spooler) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Drain stops accepting job requests and handing out queued jobs ahead of a
+// shutdown. Queued jobs are kept and processed after the next start.
+func (s *spooler) Drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+}
+
+// Draining returns whether the spooler has been drained.
+func (s *spooler) Draining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// setPaused pauses or resumes the spooler and publishes an event if that changed anything.
+func (s *spooler) setPaused(paused bool) {
+	s.mu.Lock()
+	changed := s.paused != paused
+	s.paused = paused
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if !paused {
+		s.notify()
+	}
+
+	event := v1.EventQueueResumed
+	if paused {
+		event = v1.EventQueuePaused
+	}
+
+	s.events.Publish(v1.Event{Type: event})
+}
+
+// Next removes the next job from the queue and returns it. It blocks until a
+// job has been queued and the spooler is neither paused nor drained, or until
+// ctx is done, in which case it returns the context's error. Jobs are only
+// dequeued once the caller is ready to process them, so none are lost when
+// the caller stops waiting.
+func (s *spooler) Next(ctx context.Context) (*v1.Job, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// wake is read first so jobs queued after the check below wake us up
+		s.mu.Lock()
+		wake, hold := s.wake, s.paused || s.draining
+		s.mu.Unlock()
+
+		var retry <-chan time.Time
+		if !hold {
+			job, err := s.queue.Dequeue()
+			if err == nil {
+				s.setPending(*job, false)
+				return job, nil
+			}
+
+			if !errors.Is(err, jobqueue.ErrQueueEmpty) {
+				slog.Error("failed to dequeue job", "error", err)
+				retry = time.After(retryInterval)
 			}
 		}
-	}()
-	return jobs
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wake:
+		case <-retry:
+		}
+	}
 }
 
-// Process processes a job.
-func (s *spooler) Process(job v1.Job) (sent int64, err error) {
-	file, err := s.store.Get(job.SVG)
+// notify wakes up workers waiting for the next job.
+func (s *spooler) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// Process processes a job. Converted HPGL is stored and recorded on the job so
+// it can be reused by later jobs. If ctx is done before the plot has been sent,
+// the plot is aborted and the pen parked.
+func (s *spooler) Process(ctx context.Context, job *v1.Job) (sent int64, err error) {
+	hpgl, size, err := s.hpgl(ctx, job)
 	if err != nil {
 		return 0, err
 	}
+	defer hpgl.Close()
+
+	logger := logging.Job(*job)
 
-	conn, err := plotter.Connect(job.Plotter, plotter.WithTimeout(DefaultTimeout))
+	opts := append([]plotter.ConnOption{}, s.plotterOpts...)
+	opts = append(opts,
+		plotter.WithProgress(s.progress(*job)),
+		plotter.WithLogger(logger),
+		plotter.WithContext(ctx),
+	)
+	conn, err := plotter.Connect(job.Plotter, opts...)
+	s.setOnline(job.Plotter, err == nil)
 	if err != nil {
-		log.Printf("failed to connect to plotter %s: %v", job.Plotter, err)
+		logger.Error("failed to connect to plotter", "error", err)
 		return 0, err
 	}
 	defer conn.Close()
 
-	n, err := s.convert(
-		file,
-		converter.Orientation(job.Settings.Orientation),
-		converter.Device(job.Settings.Device),
-		converter.Velocity(job.Settings.Velocity),
-		converter.Pagesize(job.Settings.Pagesize),
-	).WriteTo(conn)
+	n, err := conn.Send(hpgl, int(size))
+	if err != nil && ctx.Err() != nil {
+		logger.Warn("aborted plot", "bytes", n, "total", size)
+		if err := conn.Park(); err != nil {
+			logger.Error("failed to park pen", "error", err)
+		}
+		return int64(n), err
+	} else if err != nil {
+		return int64(n), fmt.Errorf("failed to send to plotter: %w", err)
+	}
+
+	if err := conn.Check(); err != nil {
+		return int64(n), err
+	}
+
+	return int64(n), nil
+}
+
+// progress returns a function publishing progress events for the given job.
+func (s *spooler) progress(job v1.Job) func(sent, total int) {
+	var last time.Time
+	return func(sent, total int) {
+		if sent < total && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+
+		s.events.Publish(v1.Event{
+			Type:     v1.EventJobProgress,
+			Job:      &job,
+			Plotter:  job.Plotter,
+			Progress: &v1.Progress{Sent: sent, Total: total},
+		})
+	}
+}
+
+// setOnline records whether the plotter was reachable and publishes an event if that changed.
+func (s *spooler) setOnline(addr string, online bool) {
+	s.mu.Lock()
+	was, known := s.online[addr]
+	s.online[addr] = online
+	s.mu.Unlock()
+
+	if known && was == online {
+		return
+	}
 
+	event := v1.EventPlotterOffline
+	if online {
+		event = v1.EventPlotterOnline
+	}
+
+	s.events.Publish(v1.Event{Type: event, Plotter: addr})
+}
+
+// publishJob publishes an event for the given job.
+func (s *spooler) publishJob(event v1.EventType, job v1.Job) {
+	s.events.Publish(v1.Event{Type: event, Job: &job, Plotter: job.Plotter})
+}
+
+// hpgl returns the job's stored HPGL along with its size. If the job's SVG has
+// not been converted yet, it is converted and the result stored first.
+func (s *spooler) hpgl(ctx context.Context, job *v1.Job) (io.ReadCloser, int64, error) {
+	if job.HPGL != "" {
+		logging.Job(*job).Debug("using stored HPGL", "hpgl", job.HPGL)
+	} else if err := s.convertSVG(ctx, job); err != nil {
+		return nil, 0, err
+	}
+
+	info, err := s.blobs.Stat(job.HPGL)
 	if err != nil {
-		return n, fmt.Errorf("failed to convert file and send to plotter: %v", err)
+		return nil, 0, fmt.Errorf("failed to get HPGL: %w", err)
 	}
 
-	return n, nil
+	file, err := s.blobs.Get(job.HPGL)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get HPGL: %w", err)
+	}
+
+	return file, info.Size, nil
 }
 
-func (s *spooler) storeSVG(id string, request *v1.JobRequest) (string, error) {
+// convertSVG converts the job's SVG and stores the resulting HPGL. The HPGL is
+// streamed into the content store as it is converted.
+func (s *spooler) convertSVG(ctx context.Context, job *v1.Job) error {
+	file, err := s.getSVG(job)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, span := tracer.Start(ctx, "converter.WriteTo", trace.WithAttributes(
+		attribute.String("device", string(job.Settings.Device)),
+		attribute.String("pagesize", string(job.Settings.Pagesize)),
+	))
+	defer span.End()
+	start := time.Now()
+
+	hpgl, w := io.Pipe()
+	converted := make(chan error, 1)
+	go func() {
+		_, err := s.convert(
+			file,
+			converter.Orientation(job.Settings.Orientation),
+			converter.Device(job.Settings.Device),
+			converter.Velocity(job.Settings.Velocity),
+			converter.Pagesize(job.Settings.Pagesize),
+		).WriteTo(w)
+		w.CloseWithError(err)
+		converted <- err
+	}()
+
+	sum, size, err := s.blobs.Put(hpgl)
+
+	// stops the conversion if the HPGL could not be stored
+	hpgl.Close()
+
+	if cerr := <-converted; cerr != nil && !errors.Is(cerr, io.ErrClosedPipe) {
+		tracing.Fail(span, cerr)
+		return fmt.Errorf("failed to convert file: %w", cerr)
+	} else if err != nil {
+		tracing.Fail(span, err)
+		return fmt.Errorf("failed to store HPGL: %w", err)
+	}
+	span.SetAttributes(attribute.Int64("hpgl.bytes", size))
+	job.HPGL = sum
+
+	logging.Job(*job).Info("converted SVG to HPGL", "hpgl", sum, "bytes", size, "duration", time.Since(start))
+
+	return nil
+}
+
+// getSVG returns the job's SVG file.
+func (s *spooler) getSVG(job *v1.Job) (io.ReadCloser, error) {
+	if job.SVGHash == "" {
+		// jobs submitted before the content store was introduced
+		return s.store.Get(job.SVG)
+	}
+	return s.blobs.Get(job.SVGHash)
+}
+
+// retainSVG adds a reference to the SVG of the given job and returns its hash.
+// The SVGs of jobs submitted before the content store was introduced are
+// copied to the content store. It returns ErrSVGNotFound if the SVG is gone.
+func (s *spooler) retainSVG(job v1.Job) (string, error) {
+	if job.SVGHash != "" {
+		err := s.blobs.Retain(job.SVGHash)
+		if errors.Is(err, filestore.ErrBlobNotFound) {
+			return "", ErrSVGNotFound
+		}
+		return job.SVGHash, err
+	}
+
+	svg, err := s.store.Get(job.SVG)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrSVGNotFound
+	} else if err != nil {
+		return "", err
+	}
+	defer svg.Close()
+
+	sum, _, err := s.blobs.Put(svg)
+	return sum, err
+}
+
+// storeSVG stores the request's SVG file and returns its hash.
+func (s *spooler) storeSVG(ctx context.Context, request *v1.JobRequest) (sum string, err error) {
+	_, span := tracer.Start(ctx, "spooler.storeSVG", trace.WithAttributes(attribute.Int64("svg.bytes", request.SVG.Size)))
+	defer func() {
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
 	svg, err := request.SVG.Open()
 	if err != nil {
 		return "", fmt.Errorf("could not open file %s: %w", request.SVG.Filename, err)
 	}
 	defer svg.Close()
 
-	path := fmt.Sprintf("%s.svg", id)
-
-	written, err := s.store.Put(path, svg)
+	sum, written, err := s.blobs.Put(svg)
 	if err != nil {
 		return "", err
 	}
 
 	if written != request.SVG.Size {
+		if err := s.blobs.Release(sum); err != nil {
+			return "", fmt.Errorf("size mismatch, failed to release SVG: %w", err)
+		}
 		return "", errors.New("size mismatch")
 	}
 
-	return path, nil
+	return sum, nil
 }
 
 const alphanumeric = "0123456789abcdefghijklmnopqrstuvwxyz"
@@ -1,14 +1,20 @@
 package spooler_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"mime/multipart"
 	"os"
-	"sync"
+	"strings"
 	"testing"
 	"time"
 
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	v1 "github.com/st3v/plotq/api/v1"
 	fakeconverter "github.com/st3v/plotq/converter/fake"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/filestore"
 	fakefilestore "github.com/st3v/plotq/filestore/fake"
 	"github.com/st3v/plotq/jobqueue"
 	"github.com/st3v/plotq/spooler"
@@ -29,38 +35,470 @@ func TestJobs(t *testing.T) {
 
 	c := fakeconverter.Convert{}
 	s := spooler.NewSpooler(q, &fakefilestore.Store{}, c.Spy)
-	jobs := s.Incoming(ctx)
 
 	expected := make([]v1.Job, 10)
 	for i := range expected {
-		expected[i] = testutil.RandJob()
+		expected[i] = testutil.RandPendingJob()
 		err = q.Enqueue(&expected[i])
 		require.NoError(t, err)
 	}
 
-	var mu sync.Mutex
-	actual := []v1.Job{}
-
-	for loop := true; loop; {
-		select {
-		case <-ctx.Done():
-			loop = false
-		case job := <-jobs:
-			mu.Lock()
-			actual = append(actual, job)
-			actualLen := len(actual)
-			mu.Unlock()
-			if actualLen == len(expected) {
-				loop = false
-			}
-		}
+	for i := range expected {
+		actual, err := s.Next(ctx)
+		require.NoError(t, err)
+		require.True(t, actual.SubmittedAt.Equal(expected[i].SubmittedAt))
+		expected[i].SubmittedAt = actual.SubmittedAt
+		require.Equal(t, expected[i], *actual)
+	}
+}
+
+// next calls Next in the background and returns a channel receiving the job.
+func next(ctx context.Context, s interface {
+	Next(context.Context) (*v1.Job, error)
+}) <-chan *v1.Job {
+	jobs := make(chan *v1.Job, 1)
+	go func() {
+		job, _ := s.Next(ctx)
+		jobs <- job
+	}()
+	return jobs
+}
+
+func TestNextWaitsForJobs(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, store, c.Spy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	jobs := next(ctx, s)
+
+	select {
+	case <-jobs:
+		t.Fatal("spooler handed out a job from an empty queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// submitting a job wakes up the waiting worker right away
+	job, err := s.SubmitRequest(ctx, &v1.JobRequest{User: "alice", Plotter: "hp7550:1337", SVG: svgFile(t)})
+	require.NoError(t, err)
+
+	select {
+	case actual := <-jobs:
+		require.Equal(t, job.ID, actual.ID)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("submitted job was not handed out")
+	}
+
+	// jobs are only taken from the queue for waiting workers
+	waitCtx, stop := context.WithCancel(ctx)
+	jobs = next(waitCtx, s)
+	stop()
+	require.Nil(t, <-jobs)
+
+	queued := testutil.RandPendingJob()
+	require.NoError(t, q.Enqueue(&queued))
+
+	_, err = s.Next(waitCtx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	actual, err := q.Peek()
+	require.NoError(t, err)
+	require.Equal(t, queued.ID, actual.ID)
+
+	// restored jobs wake up waiting workers
+	_, err = q.Cancel(queued.ID)
+	require.NoError(t, err)
+
+	jobs = next(ctx, s)
+	_, err = s.RestoreJob(queued.ID)
+	require.NoError(t, err)
+
+	select {
+	case actual := <-jobs:
+		require.Equal(t, queued.ID, actual.ID)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("restored job was not handed out")
+	}
+}
+
+func TestPause(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	bus := events.NewBus()
+	sub, unsubscribe := bus.Subscribe(10)
+	defer unsubscribe()
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, &fakefilestore.Store{}, c.Spy, spooler.WithEventBus(bus))
+
+	s.Pause()
+	s.Pause()
+	require.True(t, s.Paused())
+	require.Equal(t, v1.EventQueuePaused, (<-sub).Type)
+
+	job := testutil.RandPendingJob()
+	require.NoError(t, q.Enqueue(&job))
+
+	jobs := next(ctx, s)
+
+	select {
+	case <-jobs:
+		t.Fatal("paused spooler handed out a job")
+	case <-time.After(100 * time.Millisecond):
 	}
 
-	require.Equal(t, len(expected), len(actual))
+	s.Resume()
+	require.False(t, s.Paused())
+	require.Equal(t, v1.EventQueueResumed, (<-sub).Type)
+	require.Empty(t, sub)
 
-	for i := range actual {
-		require.True(t, actual[i].SubmittedAt.Equal(expected[i].SubmittedAt))
-		expected[i].SubmittedAt = actual[i].SubmittedAt
-		require.Equal(t, expected[i], actual[i])
+	select {
+	case actual := <-jobs:
+		require.Equal(t, job.ID, actual.ID)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("resumed spooler did not hand out the job")
 	}
 }
+
+func TestDrain(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, &fakefilestore.Store{}, c.Spy)
+
+	s.Drain()
+	require.True(t, s.Draining())
+
+	_, err = s.SubmitRequest(ctx, &v1.JobRequest{User: "alice", Plotter: "hp7550:1337"})
+	require.ErrorIs(t, err, spooler.ErrDraining)
+
+	job := testutil.RandPendingJob()
+	require.NoError(t, q.Enqueue(&job))
+
+	_, err = s.Next(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// queued jobs are kept for the next start
+	queued, err := q.Peek()
+	require.NoError(t, err)
+	require.Equal(t, job.ID, queued.ID)
+}
+
+func TestResubmitJob(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, store, c.Spy)
+	ctx := context.Background()
+
+	parent, err := s.SubmitRequest(ctx, &v1.JobRequest{
+		User:     "alice",
+		Notify:   "Alice <alice@example.com>",
+		Plotter:  "hp7550:1337",
+		Device:   v1.DeviceHP7550,
+		Pagesize: v1.PagesizeA4,
+		Priority: 1,
+		SVG:      svgFile(t),
+	})
+	require.NoError(t, err)
+
+	job, err := s.ResubmitJob(ctx, parent.ID, &v1.ResubmitRequest{Pagesize: v1.PagesizeA3, Velocity: 10})
+	require.NoError(t, err)
+	require.NotEqual(t, parent.ID, job.ID)
+	require.Equal(t, parent.ID, job.Parent)
+	require.Equal(t, v1.JobStatusPending, job.Status)
+	require.Equal(t, parent.SVGHash, job.SVGHash)
+	require.Equal(t, "alice", job.User)
+	require.Equal(t, "alice@example.com", job.Notify)
+	require.Equal(t, 0, job.Priority)
+	require.Equal(t, v1.JobSettings{
+		Device:      v1.DeviceHP7550,
+		Pagesize:    v1.PagesizeA3,
+		Orientation: parent.Settings.Orientation,
+		Velocity:    10,
+	}, job.Settings)
+
+	queued, err := q.Get(job.ID)
+	require.NoError(t, err)
+	require.Equal(t, parent.ID, queued.Parent)
+
+	// the SVG is kept until both jobs have been removed
+	dequeued, err := q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, parent.ID, dequeued.ID)
+	require.NoError(t, s.RemoveJob(*parent))
+
+	svg, err := s.GetSVG(*job)
+	require.NoError(t, err)
+	svg.Close()
+
+	// other users are not notified at the previous job's address
+	job, err = s.ResubmitJob(ctx, job.ID, &v1.ResubmitRequest{User: "bob", Priority: 2})
+	require.NoError(t, err)
+	require.Equal(t, "bob", job.User)
+	require.Empty(t, job.Notify)
+	require.Equal(t, 2, job.Priority)
+
+	job, err = s.ResubmitJob(ctx, job.ID, &v1.ResubmitRequest{Notify: "Bob <bob@example.com>"})
+	require.NoError(t, err)
+	require.Equal(t, "bob@example.com", job.Notify)
+
+	// SVGs of jobs submitted before the content store are copied to it
+	legacy := testutil.RandPendingJob()
+	legacy.SVG = "legacy.svg"
+	_, err = store.Put(legacy.SVG, strings.NewReader("<svg/>"))
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(&legacy))
+
+	job, err = s.ResubmitJob(ctx, legacy.ID, &v1.ResubmitRequest{})
+	require.NoError(t, err)
+	require.Equal(t, parent.SVGHash, job.SVGHash)
+
+	// jobs whose SVG has been removed cannot be resubmitted
+	missing := testutil.RandPendingJob()
+	require.NoError(t, q.Enqueue(&missing))
+
+	_, err = s.ResubmitJob(ctx, missing.ID, &v1.ResubmitRequest{})
+	require.ErrorIs(t, err, spooler.ErrSVGNotFound)
+
+	missing = testutil.RandPendingJob()
+	missing.SVGHash = "0123456789abcdef"
+	require.NoError(t, q.Enqueue(&missing))
+
+	_, err = s.ResubmitJob(ctx, missing.ID, &v1.ResubmitRequest{})
+	require.ErrorIs(t, err, spooler.ErrSVGNotFound)
+
+	job, err = s.ResubmitJob(ctx, "unknown", &v1.ResubmitRequest{})
+	require.NoError(t, err)
+	require.Nil(t, job)
+}
+
+func TestSubmitReleasesSVG(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, store, c.Spy)
+	ctx := context.Background()
+
+	job, err := s.SubmitRequest(ctx, &v1.JobRequest{User: "alice", Plotter: "hp7550:1337", SVG: svgFile(t)})
+	require.NoError(t, err)
+
+	// jobs that cannot be enqueued do not keep a reference to their SVG
+	require.NoError(t, q.Close())
+
+	_, err = s.SubmitRequest(ctx, &v1.JobRequest{User: "alice", Plotter: "hp7550:1337", SVG: svgFile(t)})
+	require.Error(t, err)
+
+	blobs := filestore.NewContentStore(store)
+	require.NoError(t, blobs.Release(job.SVGHash))
+	require.ErrorIs(t, blobs.Retain(job.SVGHash), filestore.ErrBlobNotFound)
+}
+
+func TestSubmitReusesHPGL(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, store, c.Spy)
+	ctx := context.Background()
+
+	first, err := s.SubmitRequest(ctx, &v1.JobRequest{User: "alice", Plotter: "hp7550:1337", SVG: svgFile(t)})
+	require.NoError(t, err)
+	require.Empty(t, first.HPGL)
+
+	// the first job has been converted
+	first.HPGL, _, err = filestore.NewContentStore(store).Put(strings.NewReader("IN;"))
+	require.NoError(t, err)
+	require.NoError(t, q.Update(first))
+
+	job, err := s.SubmitRequest(ctx, &v1.JobRequest{User: "bob", Plotter: "hp7550:1337", SVG: svgFile(t)})
+	require.NoError(t, err)
+	require.Equal(t, first.HPGL, job.HPGL)
+
+	// other settings require another conversion
+	other, err := s.SubmitRequest(ctx, &v1.JobRequest{User: "bob", Plotter: "hp7550:1337", Velocity: 20, SVG: svgFile(t)})
+	require.NoError(t, err)
+	require.Empty(t, other.HPGL)
+
+	// the HPGL is kept until both jobs have been removed
+	dequeued, err := q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, first.ID, dequeued.ID)
+	require.NoError(t, s.RemoveJob(*first))
+
+	hpgl, err := s.GetHPGL(*job)
+	require.NoError(t, err)
+	hpgl.Close()
+}
+
+// svgFile returns an uploaded SVG file.
+func svgFile(t *testing.T) *multipart.FileHeader {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	file, err := form.CreateFormFile("svg", "plot.svg")
+	require.NoError(t, err)
+	_, err = file.Write([]byte("<svg/>"))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	parsed, err := multipart.NewReader(body, form.Boundary()).ReadForm(1 << 20)
+	require.NoError(t, err)
+	t.Cleanup(func() { parsed.RemoveAll() })
+
+	return parsed.File["svg"][0]
+}
+
+func TestQueueDepth(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	// plotters are reported by address once they have been reached
+	server := testutil.NewTestServer(t, nil)
+	defer server.Close()
+	server.MustConnect().Close()
+
+	jobs := []v1.Job{}
+	for _, plotter := range []string{server.Addr(), server.Addr(), "hp7475a:1337"} {
+		job := testutil.RandJob()
+		job.Plotter = plotter
+		job.Status = v1.JobStatusPending
+		require.NoError(t, q.Enqueue(&job))
+		jobs = append(jobs, job)
+	}
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, &fakefilestore.Store{}, c.Spy)
+
+	depth := func(known, other int) string {
+		return fmt.Sprintf(`
+# HELP plotq_spooler_queue_depth Jobs waiting to be processed by plotter.
+# TYPE plotq_spooler_queue_depth gauge
+plotq_spooler_queue_depth{plotter="%s"} %d
+plotq_spooler_queue_depth{plotter="other"} %d
+`, server.Addr(), known, other)
+	}
+
+	// jobs queued before the start are counted
+	require.NoError(t, promtestutil.CollectAndCompare(s, strings.NewReader(depth(2, 1))))
+
+	_, err = s.DeleteJob(jobs[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, promtestutil.CollectAndCompare(s, strings.NewReader(depth(1, 1))))
+
+	_, err = s.RestoreJob(jobs[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, promtestutil.CollectAndCompare(s, strings.NewReader(depth(2, 1))))
+
+	_, err = s.Next(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, promtestutil.CollectAndCompare(s, strings.NewReader(depth(1, 1))))
+}
+
+func TestGetPlotters(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	for _, status := range []v1.JobStatus{v1.JobStatusPending, v1.JobStatusPending, v1.JobStatusProcessing} {
+		job := testutil.RandJob()
+		job.Plotter = "hp7550:1337"
+		job.Status = status
+		require.NoError(t, q.Enqueue(&job))
+	}
+
+	done := testutil.RandJob()
+	done.Plotter = "hp7475a:1337"
+	done.Status = v1.JobStatusSucceeded
+	require.NoError(t, q.Enqueue(&done))
+
+	c := fakeconverter.Convert{}
+	store, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	s := spooler.NewSpooler(q, store, c.Spy)
+
+	// plotters without pending or processing jobs are not listed
+	plotters, err := s.GetPlotters()
+	require.NoError(t, err)
+	require.Len(t, plotters, 1)
+
+	require.Equal(t, "hp7550:1337", plotters[0].Address)
+	require.Equal(t, v1.PlotterStatusUnknown, plotters[0].Status)
+	require.Equal(t, 2, plotters[0].Pending)
+	require.NotEmpty(t, plotters[0].Processing)
+
+	// pending jobs are counted as they are submitted and dequeued
+	job, err := s.SubmitRequest(context.Background(), &v1.JobRequest{User: "alice", Plotter: "hp7475a:1337", SVG: svgFile(t)})
+	require.NoError(t, err)
+
+	plotters, err = s.GetPlotters()
+	require.NoError(t, err)
+	require.Equal(t, v1.Plotter{Address: "hp7475a:1337", Status: v1.PlotterStatusUnknown, Pending: 1}, plotters[0])
+
+	_, err = s.DeleteJob(job.ID)
+	require.NoError(t, err)
+
+	plotters, err = s.GetPlotters()
+	require.NoError(t, err)
+	require.Len(t, plotters, 1)
+
+	_, err = s.GetHPGL(done)
+	require.ErrorIs(t, err, spooler.ErrNotConverted)
+}
+
+func TestListJobs(t *testing.T) {
+	q, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer q.Close()
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		job := testutil.RandPendingJob()
+		job.User = user
+		require.NoError(t, q.Enqueue(&job))
+	}
+
+	c := fakeconverter.Convert{}
+	s := spooler.NewSpooler(q, &fakefilestore.Store{}, c.Spy)
+
+	list, err := s.ListJobs(v1.JobQuery{User: "alice", Limit: 5000})
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 2)
+	require.Empty(t, list.Next)
+
+	list, err = s.ListJobs(v1.JobQuery{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 1)
+	require.NotEmpty(t, list.Next)
+}
@@ -0,0 +1,183 @@
+package testutil
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// MQTTMessage is a message published to the MQTT stand-in.
+type MQTTMessage struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// MQTTBroker is an in-process stand-in for an MQTT broker supporting a single
+// session per connection, QoS 0 and 1 and retained messages.
+type MQTTBroker struct {
+	URL      string
+	Messages chan MQTTMessage
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	retained map[string][]byte
+	subs     map[net.Conn][]string
+}
+
+// NewMQTTBroker starts a new MQTT stand-in on a random local port.
+func NewMQTTBroker(t *testing.T) *MQTTBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	b := &MQTTBroker{
+		URL:      "tcp://" + listener.Addr().String(),
+		Messages: make(chan MQTTMessage, 100),
+		listener: listener,
+		retained: map[string][]byte{},
+		subs:     map[net.Conn][]string{},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, conn)
+		}
+	}()
+
+	return b
+}
+
+// Close stops the broker.
+func (b *MQTTBroker) Close() {
+	b.listener.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn := range b.subs {
+		conn.Close()
+	}
+}
+
+// Retained returns the retained message of the topic.
+func (b *MQTTBroker) Retained(topic string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+// Subscribed returns whether a client subscribed to the topic filter.
+func (b *MQTTBroker) Subscribed(filter string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, filters := range b.subs {
+		for _, f := range filters {
+			if f == filter {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Publish sends a message to all clients subscribed to the topic.
+func (b *MQTTBroker) Publish(topic string, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn, filters := range b.subs {
+		for _, filter := range filters {
+			if !matches(filter, topic) {
+				continue
+			}
+
+			pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			pub.TopicName = topic
+			pub.Payload = payload
+			pub.Write(conn)
+			break
+		}
+	}
+}
+
+func (b *MQTTBroker) serve(t *testing.T, conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	b.mu.Lock()
+	b.subs[conn] = nil
+	b.mu.Unlock()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			err = ack.Write(conn)
+		case *packets.SubscribePacket:
+			b.subs[conn] = append(b.subs[conn], p.Topics...)
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = make([]byte, len(p.Topics))
+			err = ack.Write(conn)
+		case *packets.PublishPacket:
+			if p.Retain {
+				b.retained[p.TopicName] = p.Payload
+			}
+			b.Messages <- MQTTMessage{Topic: p.TopicName, Payload: p.Payload, Retained: p.Retain}
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				err = ack.Write(conn)
+			}
+		case *packets.PubackPacket:
+		case *packets.PingreqPacket:
+			err = packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			b.mu.Unlock()
+			return
+		default:
+			t.Errorf("unsupported MQTT packet %s", packet)
+		}
+		b.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// matches returns whether the topic matches the filter.
+func matches(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	n := strings.Split(topic, "/")
+
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(n) || (part != "+" && part != n[i]) {
+			return false
+		}
+	}
+
+	return len(f) == len(n)
+}
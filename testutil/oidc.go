@@ -0,0 +1,93 @@
+package testutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// OIDCIssuer is an in-process stand-in for an OpenID Connect provider that
+// publishes its discovery document and keys and issues RS256 signed tokens.
+type OIDCIssuer struct {
+	*httptest.Server
+	*testing.T
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	keyID string
+}
+
+// NewOIDCIssuer starts a new OpenID Connect provider stand-in.
+func NewOIDCIssuer(t *testing.T) *OIDCIssuer {
+	issuer := &OIDCIssuer{T: t}
+	issuer.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer.URL,
+			"jwks_uri": issuer.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": issuer.keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(issuer.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(issuer.key.E)).Bytes()),
+			}},
+		})
+	})
+
+	issuer.Server = httptest.NewServer(mux)
+
+	return issuer
+}
+
+// RotateKey replaces the issuer's signing key.
+func (i *OIDCIssuer) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(i, err)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.key = key
+	i.keyID = RandString(8)
+}
+
+// Token returns a token with the given claims signed by the issuer.
+func (i *OIDCIssuer) Token(claims map[string]interface{}) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": i.keyID})
+	require.NoError(i, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(i, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	require.NoError(i, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
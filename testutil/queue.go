@@ -0,0 +1,36 @@
+package testutil
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/st3v/plotq/jobqueue"
+)
+
+// queues open an empty queue of each implementation.
+var queues = map[string]func(t *testing.T) jobqueue.Queue{
+	"local": func(t *testing.T) jobqueue.Queue {
+		q, err := jobqueue.OpenLocal(t.TempDir())
+		require.NoError(t, err)
+		t.Cleanup(func() { q.Close() })
+		return q
+	},
+	"sqlite": func(t *testing.T) jobqueue.Queue {
+		q, err := jobqueue.OpenSQLite(filepath.Join(t.TempDir(), "jobs.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { q.Close() })
+		return q
+	},
+}
+
+// ForEachQueue runs the test as a subtest against an empty queue of each
+// implementation.
+func ForEachQueue(t *testing.T, test func(*testing.T, jobqueue.Queue)) {
+	for name, open := range queues {
+		t.Run(name, func(t *testing.T) {
+			test(t, open(t))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// S3Server is an in-process stand-in for an S3-compatible object store that
+// serves a single bucket using path-style requests.
+type S3Server struct {
+	*httptest.Server
+	Bucket      string
+	AccessKeyID string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+}
+
+// NewS3Server starts a new S3 stand-in serving the given bucket.
+func NewS3Server(t *testing.T, bucket, accessKeyID string) *S3Server {
+	s := &S3Server{
+		Bucket:      bucket,
+		AccessKeyID: accessKeyID,
+		objects:     map[string][]byte{},
+		modified:    map[string]time.Time{},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/", s.AccessKeyID)) {
+			s3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+			return
+		}
+
+		if r.URL.Path == "/"+s.Bucket && r.Method == http.MethodGet {
+			s.list(w, r.URL.Query().Get("prefix"))
+			return
+		}
+
+		prefix := "/" + s.Bucket + "/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			s3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, prefix)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, r.ContentLength, int64(len(data)))
+			s.objects[key] = data
+			s.modified[key] = time.Now()
+		case http.MethodGet, http.MethodHead:
+			data, ok := s.objects[key]
+			if !ok {
+				s3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Header().Set("Last-Modified", s.modified[key].Format(http.TimeFormat))
+			if r.Method == http.MethodGet {
+				w.Write(data)
+			}
+		case http.MethodDelete:
+			delete(s.objects, key)
+			delete(s.modified, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+
+	return s
+}
+
+// Object returns the content of the object with the given key.
+func (s *S3Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	return data, ok
+}
+
+// list responds with all objects whose key starts with the given prefix.
+func (s *S3Server) list(w http.ResponseWriter, prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			key, len(s.objects[key]), s.modified[key].UTC().Format(time.RFC3339))
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func s3Error(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, msg)
+}
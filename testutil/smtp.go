@@ -0,0 +1,134 @@
+package testutil
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// SMTPMessage is a message received by the SMTP stand-in.
+type SMTPMessage struct {
+	From string
+	To   []string
+	*mail.Message
+}
+
+// SMTPServer is an in-process stand-in for an SMTP server that accepts all
+// messages without authentication.
+type SMTPServer struct {
+	Host     string
+	Port     int
+	Messages chan SMTPMessage
+
+	listener net.Listener
+}
+
+// NewSMTPServer starts a new SMTP stand-in on a random local port.
+func NewSMTPServer(t *testing.T) *SMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().(*net.TCPAddr)
+	s := &SMTPServer{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Messages: make(chan SMTPMessage, 10),
+		listener: listener,
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(t, conn)
+		}
+	}()
+
+	return s
+}
+
+// Close stops the server.
+func (s *SMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *SMTPServer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	msg := SMTPMessage{}
+	reply("220 localhost ESMTP plotq test server")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.TrimSpace(line)
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg = SMTPMessage{From: address(cmd)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(cmd))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			data := &strings.Builder{}
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+
+			m, err := mail.ReadMessage(strings.NewReader(data.String()))
+			if err != nil {
+				t.Errorf("invalid message: %v", err)
+				reply("554 invalid message")
+				continue
+			}
+
+			msg.Message = m
+			s.Messages <- msg
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// address returns the address of a MAIL FROM or RCPT TO command.
+func address(cmd string) string {
+	start := strings.Index(cmd, "<")
+	end := strings.LastIndex(cmd, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return cmd[start+1 : end]
+}
@@ -34,6 +34,14 @@ func RandJob() v1.Job {
 	}
 }
 
+// RandPendingJob returns a random job that has neither been processed nor
+// canceled yet, as it is submitted to the queue.
+func RandPendingJob() v1.Job {
+	job := RandJob()
+	job.Status = v1.JobStatusPending
+	return job
+}
+
 const alphanumeric = "0123456789abcdefghijklmnopqrstuvwxyz"
 
 func RandString(n int) string {
@@ -93,6 +101,10 @@ type Testserver struct {
 	Sleep           time.Duration
 	ExpectedPayload []byte
 	ExpectedBufLen  int
+
+	// Responses maps status queries to the plotter's responses. Queries are
+	// always acked and are not considered part of the payload.
+	Responses map[string]string
 }
 
 func (t *Testserver) Addr() string {
@@ -104,11 +116,18 @@ func (t *Testserver) Close() {
 	t.listener.Close()
 }
 
-func (t *Testserver) MustConnect() *plotter.Conn {
+// Serve accepts a single connection made elsewhere, e.g. by the spooler.
+func (t *Testserver) Serve() {
+	_, err := t.acceptConnections()
+	require.NoError(t, err)
+}
+
+func (t *Testserver) MustConnect(opts ...plotter.ConnOption) *plotter.Conn {
 	addr, err := t.acceptConnections()
 	require.NoError(t, err)
 
-	conn, err := plotter.Connect(addr, plotter.WithTimeout(time.Second))
+	opts = append([]plotter.ConnOption{plotter.WithTimeout(time.Second)}, opts...)
+	conn, err := plotter.Connect(addr, opts...)
 	require.NoError(t, err)
 
 	return conn
@@ -142,6 +161,13 @@ func (t *Testserver) acceptConnections() (string, error) {
 					// verify that the feeder does not write more than expected
 					require.GreaterOrEqual(t, t.ExpectedBufLen, n)
 
+					// answer status queries like a bidirectional feeder would
+					if resp, ok := t.Responses[string(buf[:n])]; ok {
+						_, err = conn.Write([]byte("OK" + resp + "\r"))
+						require.NoError(t, err)
+						continue
+					}
+
 					// verify that the feeder wrote the expected payload
 					require.Equal(t, t.ExpectedPayload[read:read+n], buf[:n])
 					read += n
@@ -0,0 +1,82 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/st3v/plotq/testutil"
+	"github.com/st3v/plotq/tracing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestSetup(t *testing.T) {
+	collector := testutil.NewOTLPCollector(t)
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+
+	shutdown, err := tracing.Setup(context.Background())
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	span.End()
+
+	// shutting down flushes the batch
+	require.NoError(t, shutdown(context.Background()))
+
+	select {
+	case span := <-collector.Spans:
+		require.Equal(t, "test", span.Name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("span not exported")
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	require.Nil(t, tracing.Inject(context.Background()))
+
+	carrier := map[string]string{"traceparent": traceparent}
+	ctx := tracing.Extract(context.Background(), carrier)
+
+	sc := trace.SpanContextFromContext(ctx)
+	require.True(t, sc.IsRemote())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+
+	require.Equal(t, carrier, tracing.Inject(ctx))
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	var traceID trace.TraceID
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		traceID = trace.SpanContextFromContext(r.Context()).TraceID()
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/foo", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID.String())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /v1/jobs/{id}", spans[0].Name())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+}
@@ -0,0 +1,372 @@
+"use strict";
+
+// state of the page, updated from API responses and events
+const state = {
+  jobs: new Map(),      // jobs by ID in order of submission
+  plotters: new Map(),  // plotters by address
+  progress: new Map(),  // progress of processing jobs by ID
+  paused: false,
+  selected: null,       // ID of the job shown in detail
+};
+
+const active = ["Pending", "Processing"];
+const finishedShown = 5;
+const recentShown = 50; // most recent jobs loaded in addition to active ones
+
+const $ = (id) => document.getElementById(id);
+
+// api calls the API and returns the decoded JSON response, or the response
+// itself if raw is set. Errors carry the message returned by the API.
+async function api(path, options = {}, raw = false) {
+  const headers = new Headers(options.headers || {});
+  const token = localStorage.getItem("plotq.token");
+  if (token) {
+    headers.set("Authorization", "Bearer " + token);
+  }
+
+  const resp = await fetch("../v1/" + path, { ...options, headers });
+  if (!resp.ok) {
+    let message = resp.status + " " + resp.statusText;
+    try {
+      const body = await resp.json();
+      if (body.error) {
+        message = body.error;
+      }
+    } catch (e) {}
+    const err = new Error(message);
+    err.status = resp.status;
+    throw err;
+  }
+
+  return raw ? resp : resp.json();
+}
+
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs)) {
+    if (key.startsWith("on")) {
+      node.addEventListener(key.slice(2), value);
+    } else {
+      node.setAttribute(key, value);
+    }
+  }
+  node.append(...children.filter((c) => c !== null && c !== undefined));
+  return node;
+}
+
+function time(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+async function load() {
+  try {
+    const [pending, processing, recent, plotters, queue] = await Promise.all([
+      api("jobs?status=Pending&limit=1000"),
+      api("jobs?status=Processing"),
+      api("jobs?sort=-submittedAt&limit=" + recentShown),
+      api("plotters"),
+      api("queue"),
+    ]);
+    const jobs = [...pending.jobs, ...processing.jobs, ...recent.jobs];
+    jobs.sort((a, b) => new Date(a.submittedAt) - new Date(b.submittedAt));
+    state.jobs = new Map(jobs.map((job) => [job.id, job]));
+    state.plotters = new Map(plotters.map((p) => [p.address, p]));
+    state.paused = queue.paused;
+    render();
+  } catch (err) {
+    $("plotters").replaceChildren(el("p", { class: "message error" }, "Could not load jobs: " + err.message));
+  }
+}
+
+function render() {
+  renderQueue();
+  renderPlotters();
+  if (state.selected) {
+    renderDetail();
+  }
+}
+
+function renderQueue() {
+  $("queue-status").textContent = state.paused ? "Queue paused" : "Queue running";
+  $("queue-status").className = state.paused ? "badge offline" : "badge online";
+  $("queue-toggle").textContent = state.paused ? "Resume" : "Pause";
+  $("queue-toggle").disabled = false;
+}
+
+function renderPlotters() {
+  const byPlotter = new Map();
+  for (const address of state.plotters.keys()) {
+    byPlotter.set(address, []);
+  }
+  for (const job of state.jobs.values()) {
+    if (!byPlotter.has(job.plotter)) {
+      byPlotter.set(job.plotter, []);
+    }
+    byPlotter.get(job.plotter).push(job);
+  }
+
+  $("plotter-list").replaceChildren(...[...byPlotter.keys()].map((address) => el("option", { value: address })));
+
+  if (byPlotter.size === 0) {
+    $("plotters").replaceChildren(el("p", { class: "empty" }, "No jobs submitted yet."));
+    return;
+  }
+
+  const cards = [...byPlotter.entries()].sort(([a], [b]) => a.localeCompare(b)).map(([address, jobs]) => {
+    const status = (state.plotters.get(address) || {}).status || "Unknown";
+    const queued = jobs.filter((job) => active.includes(job.status));
+    const finished = jobs.filter((job) => !active.includes(job.status)).slice(-finishedShown).reverse();
+
+    return el("article", { class: "plotter" },
+      el("h3", {}, address, " ", el("span", { class: "badge " + status.toLowerCase() }, status)),
+      queued.length ? jobList(queued) : el("p", { class: "empty" }, "Nothing queued."),
+      finished.length ? el("details", {}, el("summary", {}, "Recently finished"), jobList(finished)) : null,
+    );
+  });
+
+  $("plotters").replaceChildren(...cards);
+}
+
+function jobList(jobs) {
+  return el("ul", { class: "jobs" }, ...jobs.map((job) => {
+    const progress = state.progress.get(job.id);
+    return el("li", { class: job.id === state.selected ? "selected" : "", onclick: () => select(job.id) },
+      el("span", { class: "id" }, job.id),
+      el("span", {}, job.user),
+      el("span", { class: "status " + job.status.toLowerCase() }, job.status),
+      job.status === "Processing" && progress ? el("progress", { max: progress.total, value: progress.sent }) : null,
+    );
+  }));
+}
+
+async function select(id) {
+  state.selected = id;
+  $("detail").hidden = false;
+  $("preview").removeAttribute("src");
+  renderPlotters();
+  renderDetail();
+
+  // the preview is fetched to send credentials along
+  try {
+    const resp = await api("jobs/" + encodeURIComponent(id) + "/svg", {}, true);
+    const blob = await resp.blob();
+    if (state.selected === id) {
+      $("preview").src = URL.createObjectURL(new Blob([blob], { type: "image/svg+xml" }));
+    }
+  } catch (err) {
+    $("preview").alt = "Preview not available: " + err.message;
+  }
+}
+
+function renderDetail() {
+  const job = state.jobs.get(state.selected);
+  if (!job) {
+    $("detail").hidden = true;
+    return;
+  }
+
+  $("detail-id").textContent = job.id;
+
+  const progress = state.progress.get(job.id);
+  $("detail-progress").hidden = job.status !== "Processing" || !progress;
+  if (progress) {
+    $("detail-progress").max = progress.total;
+    $("detail-progress").value = progress.sent;
+  }
+
+  const fields = [
+    ["Status", job.status],
+    ["User", job.user],
+    ["Plotter", job.plotter],
+    ["Device", job.settings.device],
+    ["Pagesize", job.settings.pagesize],
+    ["Orientation", job.settings.orientation],
+    ["Velocity", job.settings.velocity],
+    ["Submitted", time(job.submittedAt)],
+    ["Started", time(job.startedAt)],
+    ["Finished", time(job.finishedAt)],
+    ["Resubmitted from", job.parent],
+    ["Error", job.error],
+    ["Plotter errors", (job.plotterErrors || []).join(", ")],
+  ].filter(([, value]) => value !== undefined && value !== "");
+
+  $("detail-fields").replaceChildren(...fields.flatMap(([name, value]) => [el("dt", {}, name), el("dd", {}, String(value))]));
+  $("cancel").disabled = !active.includes(job.status);
+  $("restore").hidden = job.status !== "Canceled";
+}
+
+function handle(event) {
+  switch (event.type) {
+    case "job.progress":
+      state.progress.set(event.job.id, event.progress);
+      break;
+    case "plotter.online":
+    case "plotter.offline": {
+      const plotter = state.plotters.get(event.plotter) || { address: event.plotter, pending: 0 };
+      plotter.status = event.type === "plotter.online" ? "Online" : "Offline";
+      state.plotters.set(event.plotter, plotter);
+      break;
+    }
+    case "queue.paused":
+    case "queue.resumed":
+      state.paused = event.type === "queue.paused";
+      break;
+    default:
+      if (event.job) {
+        if (!active.includes(event.job.status)) {
+          state.progress.delete(event.job.id);
+        }
+        state.jobs.set(event.job.id, event.job);
+      }
+  }
+  render();
+}
+
+// streamEvents follows the event stream of the API. Server-Sent Events are read
+// with fetch since EventSource cannot send an Authorization header. The page
+// falls back to polling if the server does not stream events.
+async function streamEvents() {
+  for (;;) {
+    try {
+      const resp = await api("events", {}, true);
+      const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = "";
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+          break;
+        }
+        buffer += value;
+        const lines = buffer.split("\n");
+        buffer = lines.pop();
+        for (const line of lines) {
+          if (line.startsWith("data: ")) {
+            handle(JSON.parse(line.slice(6)));
+          }
+        }
+      }
+    } catch (err) {
+      if (err.status === 404) {
+        setInterval(load, 5000);
+        return;
+      }
+    }
+
+    // reconnect and catch up on missed events
+    await new Promise((resolve) => setTimeout(resolve, 5000));
+    await load();
+  }
+}
+
+$("submit-form").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const form = e.target;
+  const result = $("submit-result");
+  result.className = "message";
+  result.textContent = "Submitting…";
+
+  try {
+    const data = new FormData(form);
+    if (!data.get("notify")) {
+      data.delete("notify");
+    }
+    const job = await api("jobs", { method: "POST", body: data });
+    state.jobs.set(job.id, job);
+    result.textContent = "Submitted job " + job.id + ".";
+    form.reset();
+    $("velocity-value").value = form.velocity.value;
+    $("drop-text").textContent = "Drop an SVG file here or click to choose one";
+    select(job.id);
+  } catch (err) {
+    result.className = "message error";
+    result.textContent = "Could not submit: " + err.message;
+  }
+});
+
+$("drop").addEventListener("dragover", (e) => {
+  e.preventDefault();
+  $("drop").classList.add("over");
+});
+
+$("drop").addEventListener("dragleave", () => $("drop").classList.remove("over"));
+
+$("drop").addEventListener("drop", (e) => {
+  e.preventDefault();
+  $("drop").classList.remove("over");
+  $("svg").files = e.dataTransfer.files;
+  $("svg").dispatchEvent(new Event("change"));
+});
+
+$("svg").addEventListener("change", () => {
+  const file = $("svg").files[0];
+  $("drop-text").textContent = file ? file.name : "Drop an SVG file here or click to choose one";
+});
+
+$("submit-form").velocity.addEventListener("input", (e) => {
+  $("velocity-value").value = e.target.value;
+});
+
+$("queue-toggle").addEventListener("click", async () => {
+  try {
+    const queue = await api(state.paused ? "queue/resume" : "queue/pause", { method: "POST" });
+    state.paused = queue.paused;
+    renderQueue();
+  } catch (err) {
+    alert("Could not change the queue: " + err.message);
+  }
+});
+
+$("cancel").addEventListener("click", async () => {
+  try {
+    const job = await api("jobs/" + encodeURIComponent(state.selected), { method: "DELETE" });
+    state.jobs.set(job.id, job);
+    render();
+  } catch (err) {
+    alert("Could not cancel the job: " + err.message);
+  }
+});
+
+$("restore").addEventListener("click", async () => {
+  try {
+    const job = await api("jobs/" + encodeURIComponent(state.selected) + "/restore", { method: "POST" });
+    state.jobs.set(job.id, job);
+    render();
+  } catch (err) {
+    alert("Could not restore the job: " + err.message);
+  }
+});
+
+$("resubmit").addEventListener("click", async () => {
+  try {
+    const job = await api("jobs/" + encodeURIComponent(state.selected) + "/resubmit", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: "{}",
+    });
+    state.jobs.set(job.id, job);
+    render();
+    select(job.id);
+  } catch (err) {
+    alert("Could not resubmit the job: " + err.message);
+  }
+});
+
+$("close").addEventListener("click", () => {
+  state.selected = null;
+  $("detail").hidden = true;
+  renderPlotters();
+});
+
+$("token").value = localStorage.getItem("plotq.token") || "";
+$("token-form").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const token = $("token").value.trim();
+  if (token) {
+    localStorage.setItem("plotq.token", token);
+  } else {
+    localStorage.removeItem("plotq.token");
+  }
+  load();
+});
+
+load().then(streamEvents);
@@ -0,0 +1,58 @@
+// Package ui serves the web front-end for submitting and monitoring plots. The
+// front-end is embedded in the binary and talks to the v1 API.
+package ui
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	v1 "github.com/st3v/plotq/api/v1"
+)
+
+//go:embed static
+var static embed.FS
+
+var index = template.Must(template.ParseFS(static, "static/index.html"))
+
+// settings are the choices offered for the settings of a job.
+type settings struct {
+	Devices            []interface{}
+	Pagesizes          []interface{}
+	Orientations       []interface{}
+	DefaultOrientation v1.Orientation
+	DefaultVelocity    int
+}
+
+// Handler returns a handler serving the front-end at the given path prefix,
+// e.g. /ui/.
+func Handler(prefix string) http.Handler {
+	page := &bytes.Buffer{}
+	err := index.Execute(page, settings{
+		Devices:            v1.Device("").Enum(),
+		Pagesizes:          v1.Pagesize("").Enum(),
+		Orientations:       v1.Orientation("").Enum(),
+		DefaultOrientation: v1.DefaultOrientation,
+		DefaultVelocity:    v1.DefaultVelocity,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	assets, _ := fs.Sub(static, "static")
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		if path == "" || path == "index.html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(page.Bytes())
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package ui_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/ui"
+)
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestIndex(t *testing.T) {
+	h := ui.Handler("/ui/")
+
+	for _, path := range []string{"/ui/", "/ui/index.html"} {
+		rec := get(t, h, path)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		require.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+		// the settings are offered as listed by the API
+		for _, enum := range [][]interface{}{v1.Device("").Enum(), v1.Pagesize("").Enum()} {
+			for _, value := range enum {
+				require.Contains(t, rec.Body.String(), fmt.Sprintf("<option>%s</option>", value))
+			}
+		}
+		require.Contains(t, rec.Body.String(), "<option selected>portrait</option>")
+		require.Contains(t, rec.Body.String(), `value="50"`)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	h := ui.Handler("/ui/")
+
+	rec := get(t, h, "/ui/app.js")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "javascript")
+
+	rec = get(t, h, "/ui/style.css")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "text/css")
+
+	rec = get(t, h, "/ui/missing.js")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
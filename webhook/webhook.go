@@ -0,0 +1,340 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of attempts to deliver an event.
+	DefaultMaxAttempts = 5
+
+	// DefaultBackoff is the default delay before the first retry. It doubles with every further retry.
+	DefaultBackoff = 5 * time.Second
+
+	// DefaultTimeout is the default timeout of a single delivery attempt.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultLogSize is the default number of delivery attempts kept in the delivery log.
+	DefaultLogSize = 100
+
+	// maxBackoff limits the delay between two attempts.
+	maxBackoff = 5 * time.Minute
+
+	// queueSize is the number of events buffered per webhook.
+	queueSize = 64
+)
+
+const (
+	// HeaderEvent is the header holding the type of the delivered event.
+	HeaderEvent = "X-Plotq-Event"
+
+	// HeaderDelivery is the header holding the ID of the delivery, it is the same for all attempts.
+	HeaderDelivery = "X-Plotq-Delivery"
+
+	// HeaderSignature is the header holding the hex-encoded HMAC-SHA256 of the
+	// payload, prefixed by sha256=. It is only set for webhooks with a secret.
+	HeaderSignature = "X-Plotq-Signature"
+)
+
+// Hook is an outgoing webhook.
+type Hook struct {
+	// URL is the URL events are posted to.
+	URL string `json:"url"`
+
+	// Events are the types of events posted to the webhook. All events except
+	// job progress are posted if empty.
+	Events []v1.EventType `json:"events,omitempty"`
+
+	// Secret is the key used to sign payloads. Payloads are not signed if empty.
+	Secret string `json:"secret,omitempty"`
+}
+
+// wants returns whether events of the given type are posted to the webhook.
+func (h Hook) wants(event v1.EventType) bool {
+	if len(h.Events) == 0 {
+		return event != v1.EventJobProgress
+	}
+
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadHooks reads a JSON list of webhooks from the given file.
+func LoadHooks(path string) ([]Hook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read webhooks: %w", err)
+	}
+
+	hooks := []Hook{}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("could not parse webhooks %s: %w", path, err)
+	}
+
+	for i, hook := range hooks {
+		if hook.URL == "" {
+			return nil, fmt.Errorf("webhook %d in %s has no URL", i, path)
+		}
+	}
+
+	return hooks, nil
+}
+
+// Option is an option for the dispatcher.
+type Option func(*Dispatcher)
+
+// WithRetries sets the maximum number of attempts per event and the delay
+// before the first retry.
+func WithRetries(maxAttempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+	}
+}
+
+// WithClient sets the HTTP client used for deliveries.
+func WithClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithLogSize sets the number of delivery attempts kept in the delivery log.
+func WithLogSize(size int) Option {
+	return func(d *Dispatcher) {
+		d.logSize = size
+	}
+}
+
+// Dispatcher posts events to webhooks.
+type Dispatcher struct {
+	hooks       []Hook
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	logSize     int
+
+	mu  sync.Mutex // guards log
+	log []v1.WebhookDelivery
+}
+
+// NewDispatcher returns a dispatcher for the given webhooks.
+func NewDispatcher(hooks []Hook, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		hooks:       hooks,
+		client:      &http.Client{Timeout: DefaultTimeout},
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     DefaultBackoff,
+		logSize:     DefaultLogSize,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Run posts events published on the bus to the webhooks until the context is
+// done. Events are delivered to every webhook in order, a failing webhook does
+// not delay the others.
+func (d *Dispatcher) Run(ctx context.Context, bus *events.Bus) error {
+	sub, cancel := bus.Subscribe(events.DefaultBuffer)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	queues := make([]chan v1.Event, len(d.hooks))
+	for i, hook := range d.hooks {
+		queues[i] = make(chan v1.Event, queueSize)
+
+		wg.Add(1)
+		go func(hook Hook, queue <-chan v1.Event) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-queue:
+					d.deliver(ctx, hook, event)
+				}
+			}
+		}(hook, queues[i])
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-sub:
+			for i, hook := range d.hooks {
+				if !hook.wants(event.Type) {
+					continue
+				}
+
+				select {
+				case queues[i] <- event:
+				default:
+					slog.Warn("dropped event for webhook", "event", event.Type, "url", hook.URL)
+				}
+			}
+		}
+	}
+}
+
+// Deliveries returns the most recent delivery attempts, newest first.
+func (d *Dispatcher) Deliveries() []v1.WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deliveries := make([]v1.WebhookDelivery, len(d.log))
+	for i, delivery := range d.log {
+		deliveries[len(d.log)-1-i] = delivery
+	}
+
+	return deliveries
+}
+
+// deliver posts the event to the webhook, retrying with exponential backoff
+// until it succeeds, the maximum number of attempts is reached or the context
+// is done.
+func (d *Dispatcher) deliver(ctx context.Context, hook Hook, event v1.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to encode event", "event", event.Type, "error", err)
+		return
+	}
+
+	delivery := v1.WebhookDelivery{
+		ID:    newID(),
+		URL:   hook.URL,
+		Event: event.Type,
+	}
+	if event.Job != nil {
+		delivery.JobID = event.Job.ID
+	}
+
+	backoff := d.backoff
+	for delivery.Attempt = 1; ; delivery.Attempt++ {
+		delivery.Time = time.Now()
+		delivery.StatusCode, err = d.post(ctx, hook, delivery, payload)
+		delivery.Duration = time.Since(delivery.Time)
+
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		d.record(delivery)
+
+		if err == nil {
+			return
+		}
+
+		if !retryable(delivery.StatusCode) || delivery.Attempt >= d.maxAttempts {
+			slog.Warn("failed to deliver event to webhook",
+				"event", event.Type,
+				"url", hook.URL,
+				"job", delivery.JobID,
+				"delivery", delivery.ID,
+				"attempts", delivery.Attempt,
+				"error", err,
+			)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post sends a single delivery attempt and returns the response's status code.
+func (d *Dispatcher) post(ctx context.Context, hook Hook, delivery v1.WebhookDelivery, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	if hook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(hook.Secret, payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// record adds the delivery attempt to the delivery log.
+func (d *Dispatcher) record(delivery v1.WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.log = append(d.log, delivery)
+	if len(d.log) > d.logSize {
+		d.log = d.log[len(d.log)-d.logSize:]
+	}
+}
+
+// Sign returns the signature of the payload as sent in the X-Plotq-Signature header.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether the signature matches the payload.
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// retryable returns whether a failed attempt with the given status code is
+// retried. Network errors, rate limiting and server errors are retried.
+func retryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
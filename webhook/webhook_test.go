@@ -0,0 +1,173 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/testutil"
+	"github.com/st3v/plotq/webhook"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	payloads [][]byte
+	received chan struct{}
+}
+
+func newReceiver(statuses ...int) *receiver {
+	return &receiver{statuses: statuses, received: make(chan struct{}, 10)}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	payload, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	status := http.StatusNoContent
+	if len(r.requests) < len(r.statuses) {
+		status = r.statuses[len(r.requests)]
+	}
+	r.requests = append(r.requests, req)
+	r.payloads = append(r.payloads, payload)
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+	r.received <- struct{}{}
+}
+
+func (r *receiver) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d requests, got %d", n, i)
+		}
+	}
+}
+
+func run(t *testing.T, hooks []webhook.Hook) (*events.Bus, *webhook.Dispatcher) {
+	bus := events.NewBus()
+	dispatcher := webhook.NewDispatcher(hooks, webhook.WithRetries(3, time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx, bus)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// wait for the dispatcher to subscribe
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, time.Second, time.Millisecond)
+
+	return bus, dispatcher
+}
+
+func TestDeliverSigned(t *testing.T) {
+	recv := newReceiver()
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	bus, dispatcher := run(t, []webhook.Hook{{URL: server.URL, Secret: "s3cr3t"}})
+
+	job := testutil.RandJob()
+	bus.Publish(v1.Event{Type: v1.EventJobSucceeded, Job: &job})
+	recv.wait(t, 1)
+
+	req := recv.requests[0]
+	require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	require.Equal(t, "job.succeeded", req.Header.Get(webhook.HeaderEvent))
+	require.True(t, webhook.Verify("s3cr3t", recv.payloads[0], req.Header.Get(webhook.HeaderSignature)))
+	require.False(t, webhook.Verify("wrong", recv.payloads[0], req.Header.Get(webhook.HeaderSignature)))
+
+	event := v1.Event{}
+	require.NoError(t, json.Unmarshal(recv.payloads[0], &event))
+	require.Equal(t, job.ID, event.Job.ID)
+
+	require.Eventually(t, func() bool { return len(dispatcher.Deliveries()) == 1 }, time.Second, time.Millisecond)
+	delivery := dispatcher.Deliveries()[0]
+	require.Equal(t, job.ID, delivery.JobID)
+	require.Equal(t, http.StatusNoContent, delivery.StatusCode)
+	require.Empty(t, delivery.Error)
+}
+
+func TestDeliverRetries(t *testing.T) {
+	recv := newReceiver(http.StatusInternalServerError, http.StatusTooManyRequests)
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	bus, dispatcher := run(t, []webhook.Hook{{URL: server.URL}})
+
+	bus.Publish(v1.Event{Type: v1.EventJobFailed})
+	recv.wait(t, 3)
+
+	require.Eventually(t, func() bool { return len(dispatcher.Deliveries()) == 3 }, time.Second, time.Millisecond)
+	deliveries := dispatcher.Deliveries()
+	require.Equal(t, 3, deliveries[0].Attempt)
+	require.Equal(t, http.StatusNoContent, deliveries[0].StatusCode)
+	require.Equal(t, 1, deliveries[2].Attempt)
+	require.Equal(t, http.StatusInternalServerError, deliveries[2].StatusCode)
+	require.NotEmpty(t, deliveries[2].Error)
+
+	// all attempts share the delivery ID
+	require.Equal(t, recv.requests[0].Header.Get(webhook.HeaderDelivery), recv.requests[2].Header.Get(webhook.HeaderDelivery))
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	recv := newReceiver(http.StatusBadRequest)
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	bus, dispatcher := run(t, []webhook.Hook{{URL: server.URL}})
+
+	bus.Publish(v1.Event{Type: v1.EventJobFailed})
+	recv.wait(t, 1)
+
+	require.Eventually(t, func() bool { return len(dispatcher.Deliveries()) == 1 }, time.Second, time.Millisecond)
+	require.Never(t, func() bool { return len(dispatcher.Deliveries()) > 1 }, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestDeliverFiltered(t *testing.T) {
+	recv := newReceiver()
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	bus, _ := run(t, []webhook.Hook{{URL: server.URL, Events: []v1.EventType{v1.EventPlotterOnline}}})
+
+	bus.Publish(v1.Event{Type: v1.EventJobSucceeded})
+	bus.Publish(v1.Event{Type: v1.EventPlotterOnline})
+	recv.wait(t, 1)
+
+	require.Len(t, recv.requests, 1)
+	require.Equal(t, "plotter.online", recv.requests[0].Header.Get(webhook.HeaderEvent))
+}
+
+func TestLoadHooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"url": "http://example.com", "events": ["job.failed"], "secret": "s"}]`), 0600))
+
+	hooks, err := webhook.LoadHooks(path)
+	require.NoError(t, err)
+	require.Equal(t, []webhook.Hook{{URL: "http://example.com", Events: []v1.EventType{v1.EventJobFailed}, Secret: "s"}}, hooks)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"events": ["job.failed"]}]`), 0600))
+	_, err = webhook.LoadHooks(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,23 @@
+package worker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	jobsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "plotq",
+		Subsystem: "worker",
+		Name:      "jobs_finished_total",
+		Help:      "Processed jobs by final status.",
+	}, []string{"status"})
+
+	plotDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "plotq",
+		Subsystem: "worker",
+		Name:      "plot_duration_seconds",
+		Help:      "Time it took to process a job, including conversion.",
+		Buckets:   prometheus.ExponentialBuckets(10, 2, 12),
+	}, []string{"plotter"})
+)
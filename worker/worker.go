@@ -2,37 +2,170 @@ package worker
 
 import (
 	"context"
-	"log"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	v1 "github.com/st3v/plotq/api/v1"
+	"github.com/st3v/plotq/events"
+	"github.com/st3v/plotq/logging"
+	"github.com/st3v/plotq/plotter"
+	"github.com/st3v/plotq/tracing"
 )
 
+var tracer = otel.Tracer("github.com/st3v/plotq/worker")
+
+// DefaultDrainTimeout is the default time given to the current job to finish
+// after the worker has been stopped.
+const DefaultDrainTimeout = 30 * time.Second
+
 type Spooler interface {
-	Process(job v1.Job) (sent int64, err error)
-	Incoming(ctx context.Context) <-chan v1.Job
+	Process(ctx context.Context, job *v1.Job) (sent int64, err error)
+	Next(ctx context.Context) (*v1.Job, error)
+	UpdateJob(job v1.Job) error
 }
 
-// Run runs a worker loop.
-func Run(ctx context.Context, spooler Spooler) error {
-	jobs := spooler.Incoming(ctx)
-	for {
+// Option is an option for the worker.
+type Option func(*options)
+
+// WithEventBus publishes job events to the given bus.
+func WithEventBus(bus *events.Bus) Option {
+	return func(o *options) {
+		o.events = bus
+	}
+}
+
+// WithDrainTimeout sets the time given to the current job to finish once the
+// worker has been stopped. The plot is aborted when the timeout expires.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.drainTimeout = d
+	}
+}
+
+type options struct {
+	events       *events.Bus
+	drainTimeout time.Duration
+}
+
+// Run runs a worker loop until ctx is done. A job that is being processed at
+// that time is given the drain timeout to finish before it is aborted; Run
+// returns once it has finished.
+func Run(ctx context.Context, spooler Spooler, opts ...Option) error {
+	o := &options{drainTimeout: DefaultDrainTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// jobs are canceled only once the drain timeout has expired
+	jobCtx, abort := context.WithCancel(context.WithoutCancel(ctx))
+	defer abort()
+	go func() {
 		select {
+		case <-jobCtx.Done():
+			return
 		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(o.drainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-jobCtx.Done():
+		case <-timer.C:
+			abort()
+		}
+	}()
+
+	for {
+		// the next job is only taken from the queue once the previous one is done
+		next, err := spooler.Next(ctx)
+		if err != nil {
+			// ctx is done before a job has been taken from the queue
 			return nil
-		case job := <-jobs:
-			log.Printf("processing job %s...", job.ID)
-			job.Status = v1.JobStatusProcessing
-			sent, err := spooler.Process(job)
-			if err != nil {
-				log.Printf("job %s failed: %v", job.ID, err)
-				job.Error = err.Error()
-				job.Status = v1.JobStatusFailed
-			} else {
-				log.Printf("job %s succeeded: %d bytes sent to plotter", job.ID, sent)
-				job.Status = v1.JobStatusSucceeded
+		}
+		job := *next
+
+		logger := logging.Job(job)
+		logger.Info("processing job")
+
+		job.Status = v1.JobStatusProcessing
+		started := time.Now()
+		job.StartedAt = &started
+		update(spooler, job)
+		o.publish(v1.EventJobStarted, job)
+
+		spanCtx, span := startSpan(jobCtx, job)
+
+		sent, err := spooler.Process(spanCtx, &job)
+		if err != nil {
+			tracing.Fail(span, err)
+			logger.Error("job failed", "error", err)
+			job.Error = err.Error()
+			job.Status = v1.JobStatusFailed
+
+			var plotterErr *plotter.Error
+			if errors.As(err, &plotterErr) {
+				job.PlotterErrors = plotterErr.Messages
 			}
+		} else {
+			logger.Info("job succeeded", "bytes", sent, "duration", time.Since(started))
+			job.Status = v1.JobStatusSucceeded
+		}
+
+		finished := time.Now()
+		job.FinishedAt = &finished
+
+		span.SetAttributes(attribute.String("status", string(job.Status)), attribute.Int64("plotter.bytes_sent", sent))
+		span.End()
+
+		jobsFinished.WithLabelValues(string(job.Status)).Inc()
+		plotDuration.WithLabelValues(plotter.MetricLabel(job.Plotter)).Observe(finished.Sub(started).Seconds())
 
-			// todo: update job
+		update(spooler, job)
+
+		if job.Status == v1.JobStatusFailed {
+			o.publish(v1.EventJobFailed, job)
+		} else {
+			o.publish(v1.EventJobSucceeded, job)
 		}
 	}
 }
+
+// startSpan records the time the job spent in the queue in the trace of the
+// request that submitted it and starts a new trace for processing the job,
+// linked to that request.
+func startSpan(ctx context.Context, job v1.Job) (context.Context, trace.Span) {
+	submitted := tracing.Extract(ctx, job.TraceContext)
+
+	_, wait := tracer.Start(submitted, "queue.wait",
+		trace.WithTimestamp(job.SubmittedAt),
+		trace.WithAttributes(attribute.String("job", job.ID)),
+	)
+	wait.End(trace.WithTimestamp(*job.StartedAt))
+
+	return tracer.Start(ctx, "worker.Process",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(submitted)),
+		trace.WithAttributes(
+			attribute.String("job", job.ID),
+			attribute.String("plotter", job.Plotter),
+			attribute.String("user", job.User),
+		),
+	)
+}
+
+// publish publishes an event for the given job.
+func (o *options) publish(event v1.EventType, job v1.Job) {
+	o.events.Publish(v1.Event{Type: event, Job: &job, Plotter: job.Plotter})
+}
+
+// update persists the given job and logs failures.
+func update(spooler Spooler, job v1.Job) {
+	if err := spooler.UpdateJob(job); err != nil {
+		logging.Job(job).Error("failed to update job", "error", err)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -22,6 +25,9 @@ import (
 	"github.com/st3v/plotq/worker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestE2E(t *testing.T) {
@@ -53,7 +59,11 @@ func TestWorker(t *testing.T) {
 	expected := []byte("huh")
 
 	files := &filestorefake.Store{}
-	files.GetReturns(io.NopCloser(strings.NewReader(svg)), nil)
+	files.GetReturns(nil, fs.ErrNotExist)
+	files.GetReturnsOnCall(0, io.NopCloser(strings.NewReader(svg)), nil)
+	files.PutCalls(func(_ string, src io.Reader) (int64, error) {
+		return io.Copy(io.Discard, src)
+	})
 
 	convert := &converterfake.Convert{}
 	buf := bytes.NewBuffer(hpgl)
@@ -66,7 +76,7 @@ func TestWorker(t *testing.T) {
 	plotter := testutil.NewTestServer(t, expected)
 	defer plotter.Close()
 
-	job := testutil.RandJob()
+	job := testutil.RandPendingJob()
 	job.Plotter = plotter.Addr()
 
 	dir := t.TempDir()
@@ -85,6 +95,219 @@ func TestWorker(t *testing.T) {
 
 	<-time.After(timeout)
 
-	require.Equal(t, 1, files.GetCallCount())
+	require.Equal(t, job.SVG, files.GetArgsForCall(0))
 	require.Equal(t, 1, convert.CallCount())
+
+	// converted HPGL is stored by its hash along with its reference count
+	require.Equal(t, 2, files.PutCallCount())
+	name, _ := files.PutArgsForCall(0)
+	require.Len(t, name, 64)
+}
+
+func TestWorkerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	convert := &converterfake.Convert{}
+	convert.Returns(bytes.NewBufferString("IN;"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	plotter := testutil.NewTestServer(t, []byte("IN;"))
+	defer plotter.Close()
+	plotter.Serve()
+
+	job := testutil.RandPendingJob()
+	job.Plotter = plotter.Addr()
+
+	files, err := filestore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	_, err = files.Put(job.SVG, strings.NewReader("<svg/>"))
+	require.NoError(t, err)
+	job.TraceContext = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+	queue, err := jobqueue.OpenLocal(t.TempDir())
+	require.NoError(t, err)
+	defer queue.Close()
+	require.NoError(t, queue.Enqueue(&job))
+
+	go worker.Run(ctx, spooler.NewSpooler(queue, files, convert.Spy))
+
+	// spans of this job by name
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	require.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			for _, attr := range span.Attributes() {
+				if attr.Key == "job" && attr.Value.AsString() == job.ID {
+					spans[span.Name()] = span
+				}
+			}
+		}
+		return spans["worker.Process"] != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// queue wait is recorded in the trace of the submitting request
+	wait := spans["queue.wait"]
+	require.NotNil(t, wait)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", wait.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", wait.Parent().SpanID().String())
+	require.True(t, wait.StartTime().Equal(job.SubmittedAt))
+
+	// processing starts a new trace linked to the submitting request
+	process := spans["worker.Process"]
+	require.NotEqual(t, wait.SpanContext().TraceID(), process.SpanContext().TraceID())
+	require.Len(t, process.Links(), 1)
+	require.Equal(t, "00f067aa0ba902b7", process.Links()[0].SpanContext.SpanID().String())
+
+	children := []string{}
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == process.SpanContext().SpanID() {
+			children = append(children, span.Name())
+		}
+	}
+	require.ElementsMatch(t, []string{"converter.WriteTo", "plotter.Connect", "plotter.Write"}, children)
+}
+
+// blockingSpooler hands out a single job whose processing takes the given
+// duration unless it is aborted.
+type blockingSpooler struct {
+	duration time.Duration
+	started  chan struct{}
+
+	mu      sync.Mutex
+	taken   bool
+	updated []v1.Job
+}
+
+func (s *blockingSpooler) Next(ctx context.Context) (*v1.Job, error) {
+	s.mu.Lock()
+	taken := s.taken
+	s.taken = true
+	s.mu.Unlock()
+
+	if !taken {
+		job := testutil.RandPendingJob()
+		return &job, nil
+	}
+
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *blockingSpooler) Process(ctx context.Context, job *v1.Job) (int64, error) {
+	close(s.started)
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(s.duration):
+		return 0, nil
+	}
+}
+
+func (s *blockingSpooler) UpdateJob(job v1.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updated = append(s.updated, job)
+	return nil
+}
+
+func (s *blockingSpooler) last() v1.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updated[len(s.updated)-1]
+}
+
+func TestWorkerDrain(t *testing.T) {
+	for name, tc := range map[string]struct {
+		duration time.Duration
+		status   v1.JobStatus
+	}{
+		"finishes within timeout": {duration: 50 * time.Millisecond, status: v1.JobStatusSucceeded},
+		"aborted after timeout":   {duration: time.Minute, status: v1.JobStatusFailed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &blockingSpooler{duration: tc.duration, started: make(chan struct{})}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- worker.Run(ctx, s, worker.WithDrainTimeout(200*time.Millisecond))
+			}()
+
+			<-s.started
+			cancel()
+
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("worker did not return")
+			}
+
+			job := s.last()
+			require.Equal(t, tc.status, job.Status)
+			if tc.status == v1.JobStatusFailed {
+				require.Contains(t, job.Error, context.Canceled.Error())
+			}
+		})
+	}
+}
+
+func TestWorkerSkipsCanceledJobs(t *testing.T) {
+	testutil.ForEachQueue(t, func(t *testing.T, queue jobqueue.Queue) {
+		files, err := filestore.NewLocalStore(t.TempDir())
+		require.NoError(t, err)
+
+		convert := &converterfake.Convert{}
+		convert.Returns(bytes.NewBufferString("IN;"))
+
+		// the plotter of the canceled job must never be connected to
+		canceledPlotter, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer canceledPlotter.Close()
+
+		plotter, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer plotter.Close()
+
+		s := spooler.NewSpooler(queue, files, convert.Spy)
+
+		canceled := testutil.RandPendingJob()
+		canceled.Plotter = canceledPlotter.Addr().String()
+		require.NoError(t, queue.Enqueue(&canceled))
+
+		job, err := s.DeleteJob(canceled.ID)
+		require.NoError(t, err)
+		require.Equal(t, v1.JobStatusCanceled, job.Status)
+
+		next := testutil.RandPendingJob()
+		next.Plotter = plotter.Addr().String()
+		_, err = files.Put(next.SVG, strings.NewReader("<svg/>"))
+		require.NoError(t, err)
+		require.NoError(t, queue.Enqueue(&next))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		go worker.Run(ctx, s)
+
+		// the job queued after the canceled one is processed
+		require.NoError(t, plotter.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+		conn, err := plotter.Accept()
+		require.NoError(t, err)
+		conn.Close()
+
+		require.NoError(t, canceledPlotter.(*net.TCPListener).SetDeadline(time.Now().Add(100*time.Millisecond)))
+		_, err = canceledPlotter.Accept()
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		job, err = queue.Get(canceled.ID)
+		require.NoError(t, err)
+		require.Equal(t, v1.JobStatusCanceled, job.Status)
+
+		// the canceled job has left the queue
+		job, err = s.RestoreJob(canceled.ID)
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
 }